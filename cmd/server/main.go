@@ -49,7 +49,7 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     config.AppConfig.CORSOrigins,
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,Idempotency-Key",
 		AllowCredentials: true,
 	}))
 
@@ -101,19 +101,74 @@ func main() {
 	storeRoutes.Get("/products/:id", handlers.GetProduct)
 	storeRoutes.Put("/products/:id", middleware.OwnerOnlyMiddleware(), handlers.UpdateProduct)
 	storeRoutes.Delete("/products/:id", middleware.OwnerOnlyMiddleware(), handlers.DeleteProduct)
+	storeRoutes.Get("/products/:id/variants", handlers.ListProductVariants)
+	storeRoutes.Post("/products/:id/variants", middleware.OwnerOnlyMiddleware(), handlers.CreateProductVariant)
+	storeRoutes.Get("/products/:id/recipe", handlers.GetProductRecipe)
+	storeRoutes.Put("/products/:id/recipe", middleware.OwnerOnlyMiddleware(), handlers.SetProductRecipe)
+	storeRoutes.Get("/products/:id/bundle", handlers.GetProductBundle)
+	storeRoutes.Put("/products/:id/bundle", middleware.OwnerOnlyMiddleware(), handlers.SetProductBundle)
+	storeRoutes.Get("/products/:id/units", handlers.ListProductUnits)
+	storeRoutes.Post("/products/:id/units", middleware.OwnerOnlyMiddleware(), handlers.CreateProductUnit)
+	storeRoutes.Put("/products/:id/units/:unitId", middleware.OwnerOnlyMiddleware(), handlers.UpdateProductUnit)
+	storeRoutes.Delete("/products/:id/units/:unitId", middleware.OwnerOnlyMiddleware(), handlers.DeleteProductUnit)
 	storeRoutes.Get("/products/barcode/:code", handlers.GetProductByBarcode)
 	storeRoutes.Post("/products/generate-barcode", handlers.GenerateBarcode)
+	storeRoutes.Post("/products/labels", handlers.PrintProductLabels)
 
 	// Stock routes (Owner Only)
 	storeRoutes.Get("/stock", middleware.OwnerOnlyMiddleware(), handlers.ListStockMovements)
 	storeRoutes.Post("/stock/in", middleware.OwnerOnlyMiddleware(), handlers.StockIn)
 	storeRoutes.Post("/stock/out", middleware.OwnerOnlyMiddleware(), handlers.StockOut)
 	storeRoutes.Get("/stock/low", middleware.OwnerOnlyMiddleware(), handlers.GetLowStock)
+	storeRoutes.Get("/stock/batches", middleware.OwnerOnlyMiddleware(), handlers.ListStockBatches)
+	storeRoutes.Get("/stock/expiring", middleware.OwnerOnlyMiddleware(), handlers.GetExpiringStock)
+
+	// Stock opname routes (Staff count; owner or manager opens and closes)
+	storeRoutes.Get("/stock-opnames", handlers.ListStockOpnames)
+	storeRoutes.Post("/stock-opnames", middleware.ManagerOrOwnerMiddleware(), handlers.OpenStockOpname)
+	storeRoutes.Get("/stock-opnames/:id", handlers.GetStockOpname)
+	storeRoutes.Post("/stock-opnames/:id/counts", handlers.CountStockOpname)
+	storeRoutes.Get("/stock-opnames/:id/variance", middleware.ManagerOrOwnerMiddleware(), handlers.GetStockOpnameVariance)
+	storeRoutes.Post("/stock-opnames/:id/finalize", middleware.ManagerOrOwnerMiddleware(), handlers.FinalizeStockOpname)
+	storeRoutes.Post("/stock-opnames/:id/cancel", middleware.ManagerOrOwnerMiddleware(), handlers.CancelStockOpname)
+
+	// Supplier routes (Owner Only)
+	storeRoutes.Get("/suppliers", middleware.OwnerOnlyMiddleware(), handlers.ListSuppliers)
+	storeRoutes.Post("/suppliers", middleware.OwnerOnlyMiddleware(), handlers.CreateSupplier)
+	storeRoutes.Get("/suppliers/:id", middleware.OwnerOnlyMiddleware(), handlers.GetSupplier)
+	storeRoutes.Put("/suppliers/:id", middleware.OwnerOnlyMiddleware(), handlers.UpdateSupplier)
+	storeRoutes.Delete("/suppliers/:id", middleware.OwnerOnlyMiddleware(), handlers.DeleteSupplier)
+
+	// Purchase order routes (Owner Only)
+	storeRoutes.Get("/purchase-orders", middleware.OwnerOnlyMiddleware(), handlers.ListPurchaseOrders)
+	storeRoutes.Post("/purchase-orders", middleware.OwnerOnlyMiddleware(), handlers.CreatePurchaseOrder)
+	storeRoutes.Get("/purchase-orders/:id", middleware.OwnerOnlyMiddleware(), handlers.GetPurchaseOrder)
+	storeRoutes.Put("/purchase-orders/:id", middleware.OwnerOnlyMiddleware(), handlers.UpdatePurchaseOrder)
+	storeRoutes.Post("/purchase-orders/:id/send", middleware.OwnerOnlyMiddleware(), handlers.SendPurchaseOrder)
+	storeRoutes.Post("/purchase-orders/:id/cancel", middleware.OwnerOnlyMiddleware(), handlers.CancelPurchaseOrder)
+	storeRoutes.Post("/purchase-orders/:id/receive", middleware.OwnerOnlyMiddleware(), handlers.ReceivePurchaseOrder)
 
 	// Transaction routes (Allow cashier to list and create)
 	storeRoutes.Get("/transactions", handlers.ListTransactions)
 	storeRoutes.Post("/transactions", handlers.CreateTransaction)
+	storeRoutes.Post("/transactions/hold", handlers.HoldTransaction)
+	storeRoutes.Get("/transactions/held", handlers.ListHeldTransactions)
 	storeRoutes.Get("/transactions/:id", handlers.GetTransaction)
+	storeRoutes.Post("/transactions/:id/resume", handlers.ResumeHeldTransaction)
+	storeRoutes.Put("/transactions/:id/hold", handlers.UpdateHeldTransaction)
+	storeRoutes.Delete("/transactions/:id/hold", handlers.DiscardHeldTransaction)
+	storeRoutes.Post("/transactions/:id/finalize", handlers.FinalizeHeldTransaction)
+	storeRoutes.Post("/transactions/:id/refund", middleware.OwnerOnlyMiddleware(), handlers.RefundTransaction)
+	storeRoutes.Post("/transactions/:id/void", handlers.RequestVoid)
+
+	// Offline sync routes (Allow cashier)
+	storeRoutes.Get("/sync/pull", handlers.SyncPull)
+	storeRoutes.Post("/sync/push", handlers.SyncPush)
+
+	// Void approval routes (Owner or manager)
+	storeRoutes.Get("/voids", middleware.ManagerOrOwnerMiddleware(), handlers.ListVoids)
+	storeRoutes.Post("/voids/:id/approve", middleware.ManagerOrOwnerMiddleware(), handlers.ApproveVoid)
+	storeRoutes.Post("/voids/:id/reject", middleware.ManagerOrOwnerMiddleware(), handlers.RejectVoid)
 
 	// Customer routes (Allow cashier)
 	storeRoutes.Get("/customers", handlers.ListCustomers)
@@ -123,6 +178,39 @@ func main() {
 	storeRoutes.Delete("/customers/:id", middleware.OwnerOnlyMiddleware(), handlers.DeleteCustomer)
 	storeRoutes.Post("/customers/find-or-create", handlers.FindOrCreateCustomerByPhone)
 
+	// Promo routes (Cashiers can list promos to apply them)
+	storeRoutes.Get("/promos", handlers.ListPromos)
+	storeRoutes.Post("/promos", middleware.OwnerOnlyMiddleware(), handlers.CreatePromo)
+	storeRoutes.Get("/promos/:id", handlers.GetPromo)
+	storeRoutes.Put("/promos/:id", middleware.OwnerOnlyMiddleware(), handlers.UpdatePromo)
+	storeRoutes.Delete("/promos/:id", middleware.OwnerOnlyMiddleware(), handlers.DeletePromo)
+
+	// Tax and service charge routes
+	storeRoutes.Get("/tax-rules", handlers.ListTaxRules)
+	storeRoutes.Post("/tax-rules", middleware.OwnerOnlyMiddleware(), handlers.CreateTaxRule)
+	storeRoutes.Put("/tax-rules/:id", middleware.OwnerOnlyMiddleware(), handlers.UpdateTaxRule)
+	storeRoutes.Delete("/tax-rules/:id", middleware.OwnerOnlyMiddleware(), handlers.DeleteTaxRule)
+
+	// Scale barcode routes (Cashiers can list the patterns for offline scanning)
+	storeRoutes.Get("/scale-barcodes", handlers.ListScaleBarcodePatterns)
+	storeRoutes.Post("/scale-barcodes", middleware.OwnerOnlyMiddleware(), handlers.CreateScaleBarcodePattern)
+	storeRoutes.Put("/scale-barcodes/:id", middleware.OwnerOnlyMiddleware(), handlers.UpdateScaleBarcodePattern)
+	storeRoutes.Delete("/scale-barcodes/:id", middleware.OwnerOnlyMiddleware(), handlers.DeleteScaleBarcodePattern)
+
+	// Modifier routes
+	storeRoutes.Get("/modifier-groups", handlers.ListModifierGroups)
+	storeRoutes.Post("/modifier-groups", middleware.OwnerOnlyMiddleware(), handlers.CreateModifierGroup)
+	storeRoutes.Put("/modifier-groups/:id", middleware.OwnerOnlyMiddleware(), handlers.UpdateModifierGroup)
+	storeRoutes.Delete("/modifier-groups/:id", middleware.OwnerOnlyMiddleware(), handlers.DeleteModifierGroup)
+
+	// Shift routes (Cashiers manage their own shifts)
+	storeRoutes.Get("/shifts", middleware.ManagerOrOwnerMiddleware(), handlers.ListShifts)
+	storeRoutes.Post("/shifts/open", handlers.OpenShift)
+	storeRoutes.Get("/shifts/current", handlers.GetCurrentShift)
+	storeRoutes.Post("/shifts/:id/cash", handlers.AddShiftCash)
+	storeRoutes.Post("/shifts/:id/close", handlers.CloseShift)
+	storeRoutes.Get("/shifts/:id/report", handlers.GetShiftReport)
+
 	// Report routes (Owner Only)
 	reportRoutes := storeRoutes.Group("/reports", middleware.OwnerOnlyMiddleware())
 	reportRoutes.Get("/dashboard", handlers.GetDashboardStats)
@@ -131,6 +219,9 @@ func main() {
 	reportRoutes.Get("/monthly", handlers.GetMonthlyReport)
 	reportRoutes.Get("/products", handlers.GetProductReport)
 	reportRoutes.Get("/profit-loss", handlers.GetProfitLossReport)
+	reportRoutes.Get("/promos", handlers.GetPromoReport)
+	reportRoutes.Get("/tax", handlers.GetTaxReport)
+	reportRoutes.Get("/modifiers", handlers.GetModifierReport)
 	reportRoutes.Get("/export", handlers.ExportReport)
 
 	storeRoutes.Post("/reset-database", middleware.OwnerOnlyMiddleware(), handlers.ResetStoreData)
@@ -138,6 +229,7 @@ func main() {
 	// WhatsApp routes
 	storeRoutes.Post("/whatsapp/send-receipt", handlers.SendReceipt)
 	storeRoutes.Post("/whatsapp/send-stock-alert", handlers.SendStockAlert)
+	storeRoutes.Post("/whatsapp/send-expiry-alert", handlers.SendExpiryAlert)
 	storeRoutes.Post("/whatsapp/broadcast", handlers.SendBroadcast)
 	storeRoutes.Get("/whatsapp/logs", handlers.GetWhatsAppLogs)
 
@@ -52,11 +52,40 @@ CREATE TABLE stores (
     logo_url TEXT,
     whatsapp_api_key TEXT,
     whatsapp_provider VARCHAR(20) DEFAULT 'fonnte' CHECK (whatsapp_provider IN ('fonnte', 'wablas')),
+    -- Exclusive tax added to every sale when the store has no active tax_rules
     tax_rate DECIMAL(5,2) DEFAULT 0,
+    negative_stock_policy VARCHAR(10) DEFAULT 'block' CHECK (negative_stock_policy IN ('block', 'allow', 'warn')),
+    -- Invoice numbers look like PREFIX-DATE-0001. The prefix defaults to the
+    -- first three letters of the store name.
+    invoice_prefix VARCHAR(20),
+    invoice_date_format VARCHAR(10) DEFAULT 'YYYYMMDD' CHECK (invoice_date_format IN ('YYYYMMDD', 'YYMMDD', 'YYYYMM', 'YYMM', 'NONE')),
+    invoice_padding INTEGER DEFAULT 4 CHECK (invoice_padding BETWEEN 1 AND 10),
+    invoice_reset VARCHAR(10) DEFAULT 'daily' CHECK (invoice_reset IN ('daily', 'monthly', 'never')),
+    -- Totals are rounded to a multiple of rounding_increment (0 = off), e.g.
+    -- the nearest Rp 100. With rounding_cash_only, only cash sales are rounded.
+    rounding_increment DECIMAL(15,2) DEFAULT 0 CHECK (rounding_increment >= 0),
+    rounding_mode VARCHAR(10) DEFAULT 'nearest' CHECK (rounding_mode IN ('nearest', 'up', 'down')),
+    rounding_cash_only BOOLEAN DEFAULT true,
     currency VARCHAR(10) DEFAULT 'IDR',
     is_active BOOLEAN DEFAULT true,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
-    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    -- A counter that resets must show its period in the number, or the
+    -- numbers would repeat
+    CONSTRAINT stores_invoice_reset_date_format CHECK (
+        invoice_reset = 'never'
+        OR (invoice_reset = 'monthly' AND invoice_date_format <> 'NONE')
+        OR (invoice_reset = 'daily' AND invoice_date_format IN ('YYYYMMDD', 'YYMMDD'))
+    )
+);
+
+-- Last invoice number issued per store and reset period. Purchase order
+-- numbers count per month in the same table, under periods 'PO' || YYYYMM.
+CREATE TABLE invoice_sequences (
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    period VARCHAR(10) NOT NULL,
+    last_number INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (store_id, period)
 );
 
 -- =====================================================
@@ -84,7 +113,8 @@ CREATE TABLE categories (
     icon VARCHAR(50),
     sort_order INTEGER DEFAULT 0,
     is_active BOOLEAN DEFAULT true,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
 );
 
 -- =====================================================
@@ -99,13 +129,27 @@ CREATE TABLE products (
     sku VARCHAR(100),
     description TEXT,
     price DECIMAL(15,2) NOT NULL DEFAULT 0,
+    -- Moving average cost per base unit: stock received at a known cost is
+    -- averaged in, and sales record it as their cost of goods
     cost DECIMAL(15,2) DEFAULT 0,
-    stock INTEGER DEFAULT 0,
-    min_stock INTEGER DEFAULT 5,
+    -- Stock is kept to three decimals so ingredients can be used a fraction
+    -- of a unit at a time
+    stock DECIMAL(15,3) DEFAULT 0,
+    min_stock DECIMAL(15,3) DEFAULT 5,
     unit VARCHAR(20) DEFAULT 'pcs',
+    -- Weighed and measured goods (kg, m, liter) are sold in fractions;
+    -- everything else only in whole units
+    decimal_quantity BOOLEAN DEFAULT false,
     image_url TEXT,
     is_active BOOLEAN DEFAULT true,
     track_stock BOOLEAN DEFAULT true,
+    -- Variants are products of their own with a parent_id, so stock, sales and
+    -- stock movements all work per variant. The parent lists the option axes,
+    -- e.g. [{"name": "Ukuran", "values": ["S", "M", "L"]}], and is not sold
+    -- itself; each variant has its values, e.g. {"Ukuran": "M"}.
+    parent_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    variant_options JSONB,
+    option_values JSONB,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
 );
@@ -113,6 +157,164 @@ CREATE TABLE products (
 -- Create index for barcode lookup
 CREATE INDEX idx_products_barcode ON products(barcode) WHERE barcode IS NOT NULL;
 CREATE INDEX idx_products_store ON products(store_id);
+CREATE INDEX idx_products_parent ON products(parent_id) WHERE parent_id IS NOT NULL;
+
+-- =====================================================
+-- RECIPES TABLE
+-- =====================================================
+-- Ingredients a composite product is made of, per unit sold. Selling the
+-- product deducts its ingredients instead of its own stock, and its cost is
+-- the cost of the ingredients. Ingredients can't have recipes themselves.
+CREATE TABLE recipe_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    ingredient_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    quantity DECIMAL(15,3) NOT NULL CHECK (quantity > 0),
+    UNIQUE (product_id, ingredient_id),
+    CHECK (product_id <> ingredient_id)
+);
+
+CREATE INDEX idx_recipe_items_product ON recipe_items(product_id);
+CREATE INDEX idx_recipe_items_ingredient ON recipe_items(ingredient_id);
+
+-- =====================================================
+-- BUNDLES TABLE
+-- =====================================================
+-- Products a bundle (paket) is made of, per bundle sold. The bundle is sold
+-- as one line at its own price; selling it deducts each component's stock,
+-- or a component's ingredients if it has a recipe. Bundles can't contain
+-- other bundles.
+CREATE TABLE bundle_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    bundle_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    UNIQUE (bundle_id, product_id),
+    CHECK (bundle_id <> product_id)
+);
+
+CREATE INDEX idx_bundle_items_bundle ON bundle_items(bundle_id);
+CREATE INDEX idx_bundle_items_product ON bundle_items(product_id);
+
+-- =====================================================
+-- PRODUCT UNITS TABLE
+-- =====================================================
+-- Other units a product is sold or received in, e.g. "pack" of 6 or "dus"
+-- of 24 when products.unit is "pcs". factor is the number of base units in
+-- one of this unit; stock is always kept in the base unit.
+CREATE TABLE product_units (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    name VARCHAR(20) NOT NULL,
+    factor DECIMAL(15,3) NOT NULL CHECK (factor > 0),
+    barcode VARCHAR(100),
+    price DECIMAL(15,2) NOT NULL DEFAULT 0 CHECK (price >= 0),
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_product_units_product ON product_units(product_id);
+CREATE INDEX idx_product_units_barcode ON product_units(barcode) WHERE barcode IS NOT NULL;
+
+-- =====================================================
+-- SCALE BARCODE PATTERNS TABLE
+-- =====================================================
+-- Layouts of the EAN-13 labels printed by a store's scales: a prefix in the
+-- 20-29 range, a PLU matched against products.sku, and a weight or price in
+-- the value_length digits before the check digit.
+CREATE TABLE scale_barcode_patterns (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(2) NOT NULL CHECK (prefix ~ '^2[0-9]?$'),
+    plu_length INTEGER NOT NULL CHECK (plu_length BETWEEN 1 AND 6),
+    value_type VARCHAR(10) NOT NULL CHECK (value_type IN ('weight', 'price')),
+    value_length INTEGER NOT NULL CHECK (value_length BETWEEN 1 AND 6),
+    value_decimals INTEGER NOT NULL DEFAULT 0 CHECK (value_decimals BETWEEN 0 AND 3),
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    CHECK (LENGTH(prefix) + plu_length + value_length <= 12)
+);
+
+CREATE INDEX idx_scale_barcode_patterns_store ON scale_barcode_patterns(store_id);
+
+-- =====================================================
+-- TAX RULES TABLE
+-- =====================================================
+-- Taxes and service charges applied to sales. Inclusive rules are already
+-- part of the price; exclusive ones are added on top. Exclusive taxes are
+-- charged on the price plus any exclusive service charge, as PB1 is.
+CREATE TABLE tax_rules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    type VARCHAR(20) DEFAULT 'tax' CHECK (type IN ('tax', 'service_charge')),
+    rate DECIMAL(5,2) NOT NULL CHECK (rate >= 0),
+    is_inclusive BOOLEAN DEFAULT false,
+    sort_order INTEGER DEFAULT 0,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_tax_rules_store ON tax_rules(store_id);
+
+-- Products or whole categories a tax rule does not apply to
+CREATE TABLE tax_rule_exemptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tax_rule_id UUID REFERENCES tax_rules(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    category_id UUID REFERENCES categories(id) ON DELETE CASCADE,
+    CHECK ((product_id IS NULL) <> (category_id IS NULL))
+);
+
+CREATE INDEX idx_tax_rule_exemptions_rule ON tax_rule_exemptions(tax_rule_id);
+
+-- =====================================================
+-- MODIFIER GROUPS TABLE
+-- =====================================================
+-- Add-ons and preferences offered with a product, e.g. "Extra shot" or
+-- "Less sugar". A group with min_select above zero is required; a
+-- max_select of 0 means any number can be picked.
+CREATE TABLE modifier_groups (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    min_select INTEGER DEFAULT 0 CHECK (min_select >= 0),
+    max_select INTEGER DEFAULT 0 CHECK (max_select >= 0),
+    sort_order INTEGER DEFAULT 0,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_modifier_groups_store ON modifier_groups(store_id);
+
+CREATE TABLE modifiers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    group_id UUID REFERENCES modifier_groups(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    price DECIMAL(15,2) DEFAULT 0 CHECK (price >= 0),
+    sort_order INTEGER DEFAULT 0,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_modifiers_group ON modifiers(group_id);
+
+-- Products or whole categories a modifier group is offered with. A group on
+-- a parent product is offered with all of its variants.
+CREATE TABLE modifier_group_links (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    group_id UUID REFERENCES modifier_groups(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    category_id UUID REFERENCES categories(id) ON DELETE CASCADE,
+    CHECK ((product_id IS NULL) <> (category_id IS NULL))
+);
+
+CREATE INDEX idx_modifier_group_links_group ON modifier_group_links(group_id);
 
 -- =====================================================
 -- STOCK MOVEMENTS TABLE
@@ -122,9 +324,11 @@ CREATE TABLE stock_movements (
     product_id UUID REFERENCES products(id) ON DELETE CASCADE,
     store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
     type VARCHAR(20) NOT NULL CHECK (type IN ('in', 'out', 'adjustment', 'sale', 'return')),
-    quantity INTEGER NOT NULL,
-    stock_before INTEGER NOT NULL,
-    stock_after INTEGER NOT NULL,
+    quantity DECIMAL(15,3) NOT NULL,
+    stock_before DECIMAL(15,3) NOT NULL,
+    stock_after DECIMAL(15,3) NOT NULL,
+    -- What incoming stock cost per base unit, when known
+    unit_cost DECIMAL(15,2),
     reference_id UUID,
     reference_type VARCHAR(50),
     notes TEXT,
@@ -134,6 +338,131 @@ CREATE TABLE stock_movements (
 
 CREATE INDEX idx_stock_movements_product ON stock_movements(product_id);
 
+-- =====================================================
+-- STOCK BATCHES TABLE
+-- =====================================================
+-- Stock received under a batch number or expiry date, with quantity what is
+-- left of it. Sales, stock out and stocktake shortages take from the batch
+-- that expires first. Stock without a batch (opening stock, returns, stock
+-- in without one) is whatever the batches don't account for, and goes last.
+CREATE TABLE stock_batches (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    batch_number VARCHAR(100),
+    expiry_date DATE,
+    received_quantity DECIMAL(15,3) NOT NULL CHECK (received_quantity > 0),
+    quantity DECIMAL(15,3) NOT NULL CHECK (quantity >= 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    CHECK (batch_number IS NOT NULL OR expiry_date IS NOT NULL)
+);
+
+CREATE INDEX idx_stock_batches_product ON stock_batches(product_id, expiry_date) WHERE quantity > 0;
+CREATE INDEX idx_stock_batches_expiry ON stock_batches(store_id, expiry_date) WHERE quantity > 0;
+
+-- =====================================================
+-- STOCK OPNAME TABLES
+-- =====================================================
+-- A physical stocktake. Opening it snapshots the system stock of every
+-- stocked product (optionally of one category); staff then record what they
+-- count. Finalizing posts an adjustment movement for each counted
+-- difference, referencing the session.
+CREATE TABLE stock_opnames (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    category_id UUID REFERENCES categories(id) ON DELETE SET NULL,
+    status VARCHAR(20) DEFAULT 'open' CHECK (status IN ('open', 'finalized', 'cancelled')),
+    notes TEXT,
+    created_by UUID REFERENCES users(id),
+    closed_by UUID REFERENCES users(id),
+    closed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_stock_opnames_store ON stock_opnames(store_id);
+-- Only one stocktake runs at a time per store
+CREATE UNIQUE INDEX idx_stock_opnames_open ON stock_opnames(store_id) WHERE status = 'open';
+
+-- system_stock is the stock when the session opened; the adjustment is
+-- counted_quantity - system_stock, so sales during the count are kept
+CREATE TABLE stock_opname_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    opname_id UUID REFERENCES stock_opnames(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    product_name VARCHAR(255) NOT NULL,
+    system_stock DECIMAL(15,3) NOT NULL,
+    counted_quantity DECIMAL(15,3) CHECK (counted_quantity >= 0),
+    counted_by UUID REFERENCES users(id),
+    counted_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (opname_id, product_id)
+);
+
+CREATE INDEX idx_stock_opname_items_opname ON stock_opname_items(opname_id);
+
+-- =====================================================
+-- SUPPLIERS TABLE
+-- =====================================================
+CREATE TABLE suppliers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    contact_name VARCHAR(255),
+    phone VARCHAR(20),
+    email VARCHAR(255),
+    address TEXT,
+    notes TEXT,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_suppliers_store ON suppliers(store_id);
+
+-- =====================================================
+-- PURCHASE ORDER TABLES
+-- =====================================================
+-- An order of stock from a supplier. It is edited as a draft, sent, then
+-- received in one or more deliveries; each delivery posts an 'in' stock
+-- movement per line referencing the order.
+CREATE TABLE purchase_orders (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    supplier_id UUID REFERENCES suppliers(id) NOT NULL,
+    po_number VARCHAR(50) NOT NULL,
+    status VARCHAR(20) DEFAULT 'draft' CHECK (status IN ('draft', 'sent', 'partially_received', 'received', 'cancelled')),
+    expected_at TIMESTAMP WITH TIME ZONE,
+    notes TEXT,
+    total DECIMAL(15,2) DEFAULT 0,
+    created_by UUID REFERENCES users(id),
+    sent_at TIMESTAMP WITH TIME ZONE,
+    received_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    UNIQUE (store_id, po_number)
+);
+
+CREATE INDEX idx_purchase_orders_store ON purchase_orders(store_id);
+CREATE INDEX idx_purchase_orders_supplier ON purchase_orders(supplier_id);
+
+-- quantity, received_quantity and unit_cost are in unit_id's unit, or the
+-- product's base unit when it is null; quantity - received_quantity is
+-- still outstanding
+CREATE TABLE purchase_order_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    purchase_order_id UUID REFERENCES purchase_orders(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    unit_id UUID REFERENCES product_units(id),
+    product_name VARCHAR(255) NOT NULL,
+    unit_name VARCHAR(20) NOT NULL,
+    unit_factor DECIMAL(15,3) NOT NULL DEFAULT 1,
+    quantity DECIMAL(15,3) NOT NULL CHECK (quantity > 0),
+    received_quantity DECIMAL(15,3) NOT NULL DEFAULT 0 CHECK (received_quantity >= 0 AND received_quantity <= quantity),
+    unit_cost DECIMAL(15,2) NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_purchase_order_items_order ON purchase_order_items(purchase_order_id);
+
 -- =====================================================
 -- CUSTOMERS TABLE
 -- =====================================================
@@ -156,6 +485,43 @@ CREATE TABLE customers (
 CREATE INDEX idx_customers_phone ON customers(phone) WHERE phone IS NOT NULL;
 CREATE INDEX idx_customers_store ON customers(store_id);
 
+-- =====================================================
+-- SHIFTS TABLE
+-- =====================================================
+CREATE TABLE shifts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    cashier_id UUID REFERENCES users(id),
+    status VARCHAR(20) DEFAULT 'open' CHECK (status IN ('open', 'closed')),
+    opening_cash DECIMAL(15,2) NOT NULL DEFAULT 0,
+    expected_cash DECIMAL(15,2),
+    counted_cash DECIMAL(15,2),
+    cash_difference DECIMAL(15,2),
+    notes TEXT,
+    opened_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    closed_at TIMESTAMP WITH TIME ZONE,
+    closed_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_shifts_store ON shifts(store_id);
+-- A cashier has at most one open shift per store
+CREATE UNIQUE INDEX idx_shifts_open ON shifts(store_id, cashier_id) WHERE status = 'open';
+
+-- Petty cash put into or taken out of the drawer during a shift
+CREATE TABLE shift_cash_movements (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    shift_id UUID REFERENCES shifts(id) ON DELETE CASCADE,
+    type VARCHAR(10) NOT NULL CHECK (type IN ('in', 'out')),
+    amount DECIMAL(15,2) NOT NULL,
+    reason TEXT,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_shift_cash_movements_shift ON shift_cash_movements(shift_id);
+
 -- =====================================================
 -- TRANSACTIONS TABLE
 -- =====================================================
@@ -168,21 +534,59 @@ CREATE TABLE transactions (
     subtotal DECIMAL(15,2) NOT NULL DEFAULT 0,
     discount_amount DECIMAL(15,2) DEFAULT 0,
     discount_percent DECIMAL(5,2) DEFAULT 0,
+    -- Exclusive tax and service charge added on top; inclusive tax is only
+    -- recorded in transaction_taxes
+    service_charge DECIMAL(15,2) DEFAULT 0,
     tax_amount DECIMAL(15,2) DEFAULT 0,
+    -- total = subtotal - discount_amount + service_charge + tax_amount + rounding_adjustment
+    rounding_adjustment DECIMAL(15,2) DEFAULT 0,
     total DECIMAL(15,2) NOT NULL DEFAULT 0,
     payment_amount DECIMAL(15,2) NOT NULL DEFAULT 0,
     change_amount DECIMAL(15,2) DEFAULT 0,
-    payment_type VARCHAR(20) DEFAULT 'cash' CHECK (payment_type IN ('cash', 'qris', 'transfer', 'debit', 'credit')),
+    payment_type VARCHAR(20) DEFAULT 'cash' CHECK (payment_type IN ('cash', 'qris', 'transfer', 'debit', 'credit', 'split')),
     payment_reference VARCHAR(100),
     status VARCHAR(20) DEFAULT 'completed' CHECK (status IN ('pending', 'completed', 'cancelled', 'refunded')),
+    refunded_amount DECIMAL(15,2) DEFAULT 0,
+    promo_id UUID,
+    promo_discount DECIMAL(15,2) DEFAULT 0,
+    shift_id UUID REFERENCES shifts(id) ON DELETE SET NULL,
     notes TEXT,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
-    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+    -- When the sale was paid; a held order keeps the time it was opened in
+    -- created_at and gets this once it is finalized
+    completed_at TIMESTAMP WITH TIME ZONE,
+    -- When an offline terminal says the sale was made. created_at and
+    -- completed_at stay the time it was pushed, which the invoice number and
+    -- shift were taken for, so reports agree with them
+    sold_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    CONSTRAINT transactions_store_invoice_unique UNIQUE (store_id, invoice_number)
 );
 
 CREATE INDEX idx_transactions_store ON transactions(store_id);
 CREATE INDEX idx_transactions_date ON transactions(created_at);
+-- Reports date sales by when they were paid
+CREATE INDEX idx_transactions_completed ON transactions(store_id, (COALESCE(completed_at, created_at)));
 CREATE INDEX idx_transactions_invoice ON transactions(invoice_number);
+CREATE INDEX idx_transactions_shift ON transactions(shift_id) WHERE shift_id IS NOT NULL;
+
+-- =====================================================
+-- IDEMPOTENCY KEYS TABLE
+-- =====================================================
+-- Remembers the response of a transaction request so client retries with the
+-- same Idempotency-Key get the original result instead of a second sale
+CREATE TABLE idempotency_keys (
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+    response_status INTEGER,
+    response_body JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (store_id, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
 
 -- =====================================================
 -- TRANSACTION ITEMS TABLE
@@ -193,16 +597,153 @@ CREATE TABLE transaction_items (
     product_id UUID REFERENCES products(id) ON DELETE SET NULL,
     product_name VARCHAR(255) NOT NULL,
     product_price DECIMAL(15,2) NOT NULL,
-    quantity INTEGER NOT NULL DEFAULT 1,
+    quantity DECIMAL(15,3) NOT NULL DEFAULT 1,
     discount_amount DECIMAL(15,2) DEFAULT 0,
     discount_percent DECIMAL(5,2) DEFAULT 0,
     subtotal DECIMAL(15,2) NOT NULL DEFAULT 0,
     cost DECIMAL(15,2) DEFAULT 0,
+    refunded_quantity DECIMAL(15,3) DEFAULT 0,
+    -- Price of the chosen modifiers per unit:
+    -- subtotal = (product_price + modifier_amount) * quantity - discount_amount
+    modifier_amount DECIMAL(15,2) DEFAULT 0,
+    -- Unit the item was sold in, if not the product's base unit. Prices,
+    -- cost and quantity are in this unit; stock takes quantity * unit_factor.
+    unit_id UUID REFERENCES product_units(id) ON DELETE SET NULL,
+    unit_name VARCHAR(20),
+    unit_factor DECIMAL(15,3) DEFAULT 1,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
 );
 
 CREATE INDEX idx_transaction_items_transaction ON transaction_items(transaction_id);
 
+-- Modifiers chosen for an item, copied so menu changes don't alter past sales.
+-- The price is per unit of the item.
+CREATE TABLE transaction_item_modifiers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_item_id UUID REFERENCES transaction_items(id) ON DELETE CASCADE,
+    modifier_id UUID REFERENCES modifiers(id) ON DELETE SET NULL,
+    group_name VARCHAR(100) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    price DECIMAL(15,2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_transaction_item_modifiers_item ON transaction_item_modifiers(transaction_item_id);
+
+-- Components of a sold bundle, copied so later bundle changes don't alter
+-- past sales. The quantity is per unit of the item.
+CREATE TABLE transaction_item_components (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_item_id UUID REFERENCES transaction_items(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE SET NULL,
+    product_name VARCHAR(255) NOT NULL,
+    quantity INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_transaction_item_components_item ON transaction_item_components(transaction_item_id);
+CREATE INDEX idx_transaction_item_components_product ON transaction_item_components(product_id);
+
+-- Stock a sale line took, per product deducted: bundles and recipes are
+-- expanded as they were when the line was sold, so a refund or void puts
+-- back exactly this even after the bundle or recipe changes. The quantity is
+-- in base units for the whole line.
+CREATE TABLE transaction_item_stock (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_item_id UUID REFERENCES transaction_items(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    quantity DECIMAL(15,3) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_transaction_item_stock_item ON transaction_item_stock(transaction_item_id);
+
+-- =====================================================
+-- TRANSACTION TAXES TABLE
+-- =====================================================
+-- One line per tax or service charge applied to a transaction. The rule's
+-- name and rate are copied so later rule changes don't alter past sales.
+CREATE TABLE transaction_taxes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+    tax_rule_id UUID REFERENCES tax_rules(id) ON DELETE SET NULL,
+    name VARCHAR(100) NOT NULL,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('tax', 'service_charge')),
+    rate DECIMAL(5,2) NOT NULL,
+    is_inclusive BOOLEAN DEFAULT false,
+    taxable_amount DECIMAL(15,2) NOT NULL DEFAULT 0,
+    amount DECIMAL(15,2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_transaction_taxes_transaction ON transaction_taxes(transaction_id);
+
+-- =====================================================
+-- TRANSACTION PAYMENTS TABLE
+-- =====================================================
+CREATE TABLE transaction_payments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+    payment_type VARCHAR(20) NOT NULL CHECK (payment_type IN ('cash', 'qris', 'transfer', 'debit', 'credit')),
+    amount DECIMAL(15,2) NOT NULL DEFAULT 0,
+    reference VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_transaction_payments_transaction ON transaction_payments(transaction_id);
+
+-- =====================================================
+-- REFUNDS TABLE
+-- =====================================================
+CREATE TABLE refunds (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    amount DECIMAL(15,2) NOT NULL DEFAULT 0,
+    cost_amount DECIMAL(15,2) NOT NULL DEFAULT 0,
+    is_full BOOLEAN DEFAULT false,
+    reason TEXT,
+    shift_id UUID REFERENCES shifts(id) ON DELETE SET NULL,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_refunds_transaction ON refunds(transaction_id);
+CREATE INDEX idx_refunds_store ON refunds(store_id);
+
+CREATE TABLE refund_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    refund_id UUID REFERENCES refunds(id) ON DELETE CASCADE,
+    transaction_item_id UUID REFERENCES transaction_items(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE SET NULL,
+    quantity DECIMAL(15,3) NOT NULL,
+    amount DECIMAL(15,2) NOT NULL DEFAULT 0,
+    cost DECIMAL(15,2) DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_refund_items_refund ON refund_items(refund_id);
+
+-- =====================================================
+-- TRANSACTION VOIDS TABLE
+-- =====================================================
+CREATE TABLE transaction_voids (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    reason_code VARCHAR(30) NOT NULL CHECK (reason_code IN ('wrong_item', 'wrong_quantity', 'wrong_payment', 'customer_cancelled', 'duplicate', 'other')),
+    notes TEXT,
+    status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    requested_by UUID REFERENCES users(id),
+    approved_by UUID REFERENCES users(id),
+    decided_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+);
+
+CREATE INDEX idx_transaction_voids_store ON transaction_voids(store_id);
+-- Only one open or approved void per transaction
+CREATE UNIQUE INDEX idx_transaction_voids_active ON transaction_voids(transaction_id) WHERE status IN ('pending', 'approved');
+
 -- =====================================================
 -- WHATSAPP LOGS TABLE
 -- =====================================================
@@ -233,6 +774,9 @@ CREATE TABLE promos (
     code VARCHAR(50),
     type VARCHAR(20) NOT NULL CHECK (type IN ('percentage', 'fixed', 'buy_x_get_y')),
     value DECIMAL(15,2) NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    buy_quantity INTEGER DEFAULT 0,
+    get_quantity INTEGER DEFAULT 0,
     min_purchase DECIMAL(15,2) DEFAULT 0,
     max_discount DECIMAL(15,2),
     start_date TIMESTAMP WITH TIME ZONE,
@@ -240,9 +784,16 @@ CREATE TABLE promos (
     usage_limit INTEGER,
     usage_count INTEGER DEFAULT 0,
     is_active BOOLEAN DEFAULT true,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW()),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT TIMEZONE('utc', NOW())
 );
 
+CREATE UNIQUE INDEX idx_promos_code ON promos(store_id, UPPER(code)) WHERE code IS NOT NULL;
+
+ALTER TABLE transactions
+    ADD CONSTRAINT transactions_promo_id_fkey FOREIGN KEY (promo_id) REFERENCES promos(id) ON DELETE SET NULL;
+CREATE INDEX idx_transactions_promo ON transactions(promo_id) WHERE promo_id IS NOT NULL;
+
 -- =====================================================
 -- AUDIT LOG TABLE
 -- =====================================================
@@ -371,55 +922,203 @@ CREATE POLICY "Store owners can manage customers" ON customers
 CREATE OR REPLACE FUNCTION generate_invoice_number(store_uuid UUID)
 RETURNS TEXT AS $$
 DECLARE
-    prefix TEXT;
-    seq INTEGER;
-    invoice TEXT;
+    st RECORD;
+    v_prefix TEXT;
+    v_period TEXT;
+    v_seq INTEGER;
+    v_number TEXT;
 BEGIN
-    SELECT COALESCE(SUBSTRING(name FROM 1 FOR 3), 'INV') INTO prefix FROM stores WHERE id = store_uuid;
-    prefix := UPPER(prefix);
+    SELECT * INTO st FROM stores WHERE id = store_uuid;
+    v_prefix := UPPER(COALESCE(NULLIF(st.invoice_prefix, ''), SUBSTRING(st.name FROM 1 FOR 3), 'INV'));
+    
+    v_period := CASE st.invoice_reset
+        WHEN 'daily' THEN TO_CHAR(CURRENT_DATE, 'YYYYMMDD')
+        WHEN 'monthly' THEN TO_CHAR(CURRENT_DATE, 'YYYYMM')
+        ELSE 'ALL'
+    END;
+    
+    -- The upsert keeps the sequence row locked until the sale commits, so
+    -- concurrent sales take turns and a rolled back sale gives its number back
+    INSERT INTO invoice_sequences (store_id, period, last_number)
+    VALUES (store_uuid, v_period, 1)
+    ON CONFLICT (store_id, period)
+    DO UPDATE SET last_number = invoice_sequences.last_number + 1
+    RETURNING last_number INTO v_seq;
     
-    SELECT COUNT(*) + 1 INTO seq 
-    FROM transactions 
-    WHERE store_id = store_uuid 
-    AND DATE(created_at) = CURRENT_DATE;
+    v_number := LPAD(v_seq::TEXT, GREATEST(COALESCE(st.invoice_padding, 4), LENGTH(v_seq::TEXT)), '0');
     
-    invoice := prefix || '-' || TO_CHAR(CURRENT_DATE, 'YYYYMMDD') || '-' || LPAD(seq::TEXT, 4, '0');
+    IF st.invoice_date_format = 'NONE' THEN
+        RETURN v_prefix || '-' || v_number;
+    END IF;
     
-    RETURN invoice;
+    RETURN v_prefix || '-' || TO_CHAR(CURRENT_DATE, COALESCE(st.invoice_date_format, 'YYYYMMDD')) || '-' || v_number;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Deduct stock for one sold item and record the movement. A bundle deducts
+-- each of its components, and a product with a recipe each of its
+-- ingredients, instead of itself. The movements keep the sold item's name.
+CREATE OR REPLACE FUNCTION apply_sale_stock(
+    p_transaction_id UUID,
+    p_item_id UUID,
+    p_product_id UUID,
+    p_quantity DECIMAL,
+    p_product_name TEXT
+)
+RETURNS void AS $$
+DECLARE
+    component RECORD;
+    ingredient RECORD;
+BEGIN
+    IF EXISTS (SELECT 1 FROM bundle_items WHERE bundle_id = p_product_id) THEN
+        FOR component IN
+            SELECT product_id, quantity FROM bundle_items
+            WHERE bundle_id = p_product_id
+            ORDER BY product_id
+        LOOP
+            PERFORM apply_sale_stock(p_transaction_id, p_item_id, component.product_id,
+                                     p_quantity * component.quantity, p_product_name);
+        END LOOP;
+    ELSIF EXISTS (SELECT 1 FROM recipe_items WHERE product_id = p_product_id) THEN
+        -- Same id order as the sale locks them in
+        FOR ingredient IN
+            SELECT ingredient_id, quantity FROM recipe_items
+            WHERE product_id = p_product_id
+            ORDER BY ingredient_id
+        LOOP
+            PERFORM deduct_sale_stock(p_transaction_id, p_item_id, ingredient.ingredient_id,
+                                      p_quantity * ingredient.quantity, p_product_name);
+        END LOOP;
+    ELSE
+        PERFORM deduct_sale_stock(p_transaction_id, p_item_id, p_product_id, p_quantity, p_product_name);
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Deduct sold stock of one product and record the movement, and what the
+-- sale line took for returns to put back
+CREATE OR REPLACE FUNCTION deduct_sale_stock(
+    p_transaction_id UUID,
+    p_item_id UUID,
+    p_product_id UUID,
+    p_quantity DECIMAL,
+    p_product_name TEXT
+)
+RETURNS void AS $$
+DECLARE
+    prod RECORD;
+    policy VARCHAR(10);
+BEGIN
+    INSERT INTO transaction_item_stock (transaction_item_id, product_id, quantity)
+    VALUES (p_item_id, p_product_id, p_quantity);
+
+    -- Lock the product row so concurrent sales deduct one after another
+    SELECT * INTO prod FROM products WHERE id = p_product_id FOR UPDATE;
+    
+    IF prod.track_stock THEN
+        SELECT negative_stock_policy INTO policy FROM stores WHERE id = prod.store_id;
+        IF COALESCE(policy, 'block') = 'block' AND prod.stock < p_quantity THEN
+            RAISE EXCEPTION 'Insufficient stock for %', prod.name;
+        END IF;
+        
+        -- Create stock movement
+        INSERT INTO stock_movements (
+            product_id, store_id, type, quantity, 
+            stock_before, stock_after, reference_id, reference_type, notes
+        ) VALUES (
+            p_product_id,
+            (SELECT store_id FROM transactions WHERE id = p_transaction_id),
+            'sale',
+            p_quantity,
+            prod.stock,
+            prod.stock - p_quantity,
+            p_transaction_id,
+            'transaction',
+            'Sale: ' || p_product_name
+        );
+        
+        -- Update product stock
+        UPDATE products 
+        SET stock = prod.stock - p_quantity,
+            updated_at = NOW()
+        WHERE id = p_product_id;
+        
+        PERFORM consume_stock_batches(p_product_id, p_quantity);
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Take quantity of a product from its batches, first expired first out.
+-- Batches without an expiry date go after those with one, oldest first.
+-- Batches already past their expiry date are skipped, so a sale never
+-- claims to have sold expired goods: what the good batches don't cover
+-- comes out of unbatched stock. Only when the product's stock no longer
+-- covers its expired batches either are they drawn down, so the batches
+-- never add up to more than is in stock. Pulling expired stock off the
+-- shelf is a stock out against the batch itself. Callers reduce the
+-- product's stock first and hold its row lock, which keeps its batches
+-- consistent.
+CREATE OR REPLACE FUNCTION consume_stock_batches(
+    p_product_id UUID,
+    p_quantity DECIMAL
+)
+RETURNS void AS $$
+DECLARE
+    batch RECORD;
+    remaining DECIMAL := p_quantity;
+    taken DECIMAL;
+    excess DECIMAL;
+BEGIN
+    FOR batch IN
+        SELECT id, quantity FROM stock_batches
+        WHERE product_id = p_product_id AND quantity > 0
+          AND (expiry_date IS NULL OR expiry_date >= CURRENT_DATE)
+        ORDER BY expiry_date ASC NULLS LAST, created_at ASC
+    LOOP
+        EXIT WHEN remaining <= 0;
+        taken := LEAST(batch.quantity, remaining);
+        UPDATE stock_batches
+        SET quantity = quantity - taken,
+            updated_at = NOW()
+        WHERE id = batch.id;
+        remaining := remaining - taken;
+    END LOOP;
+
+    IF remaining <= 0 THEN
+        RETURN;
+    END IF;
+
+    SELECT COALESCE(SUM(b.quantity), 0) - GREATEST(MAX(p.stock), 0) INTO excess
+    FROM products p
+    LEFT JOIN stock_batches b ON b.product_id = p.id AND b.quantity > 0
+    WHERE p.id = p_product_id;
+    remaining := LEAST(remaining, excess);
+
+    FOR batch IN
+        SELECT id, quantity FROM stock_batches
+        WHERE product_id = p_product_id AND quantity > 0
+          AND expiry_date < CURRENT_DATE
+        ORDER BY expiry_date ASC, created_at ASC
+    LOOP
+        EXIT WHEN remaining <= 0;
+        taken := LEAST(batch.quantity, remaining);
+        UPDATE stock_batches
+        SET quantity = quantity - taken,
+            updated_at = NOW()
+        WHERE id = batch.id;
+        remaining := remaining - taken;
+    END LOOP;
 END;
 $$ LANGUAGE plpgsql;
 
 -- Function to update stock on transaction
 CREATE OR REPLACE FUNCTION update_stock_on_sale()
 RETURNS TRIGGER AS $$
-DECLARE
-    prod RECORD;
 BEGIN
     IF TG_OP = 'INSERT' THEN
-        SELECT * INTO prod FROM products WHERE id = NEW.product_id;
-        
-        IF prod.track_stock THEN
-            -- Create stock movement
-            INSERT INTO stock_movements (
-                product_id, store_id, type, quantity, 
-                stock_before, stock_after, reference_id, reference_type, notes
-            ) VALUES (
-                NEW.product_id,
-                (SELECT store_id FROM transactions WHERE id = NEW.transaction_id),
-                'sale',
-                NEW.quantity,
-                prod.stock,
-                prod.stock - NEW.quantity,
-                NEW.transaction_id,
-                'transaction',
-                'Sale: ' || NEW.product_name
-            );
-            
-            -- Update product stock
-            UPDATE products 
-            SET stock = stock - NEW.quantity,
-                updated_at = NOW()
-            WHERE id = NEW.product_id;
+        -- Held (pending) orders don't touch stock until they are finalized
+        IF (SELECT status FROM transactions WHERE id = NEW.transaction_id) = 'completed' THEN
+            PERFORM apply_sale_stock(NEW.transaction_id, NEW.id, NEW.product_id, NEW.quantity * NEW.unit_factor, NEW.product_name);
         END IF;
     END IF;
     
@@ -433,6 +1132,27 @@ CREATE TRIGGER trigger_update_stock_on_sale
     FOR EACH ROW
     EXECUTE FUNCTION update_stock_on_sale();
 
+-- Function to deduct stock when a held order is finalized
+CREATE OR REPLACE FUNCTION update_stock_on_finalize()
+RETURNS TRIGGER AS $$
+DECLARE
+    item RECORD;
+BEGIN
+    FOR item IN SELECT * FROM transaction_items WHERE transaction_id = NEW.id LOOP
+        PERFORM apply_sale_stock(NEW.id, item.id, item.product_id, item.quantity * item.unit_factor, item.product_name);
+    END LOOP;
+    
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Trigger for stock update on finalize
+CREATE TRIGGER trigger_update_stock_on_finalize
+    AFTER UPDATE OF status ON transactions
+    FOR EACH ROW
+    WHEN (OLD.status = 'pending' AND NEW.status = 'completed')
+    EXECUTE FUNCTION update_stock_on_finalize();
+
 -- Function to update customer stats
 CREATE OR REPLACE FUNCTION update_customer_stats()
 RETURNS TRIGGER AS $$
@@ -458,6 +1178,12 @@ CREATE TRIGGER trigger_update_customer_stats
     WHEN (NEW.status = 'completed')
     EXECUTE FUNCTION update_customer_stats();
 
+CREATE TRIGGER trigger_update_customer_stats_on_finalize
+    AFTER UPDATE OF status ON transactions
+    FOR EACH ROW
+    WHEN (OLD.status = 'pending' AND NEW.status = 'completed')
+    EXECUTE FUNCTION update_customer_stats();
+
 -- Function to check subscription limits
 CREATE OR REPLACE FUNCTION check_transaction_limit()
 RETURNS TRIGGER AS $$
@@ -465,6 +1191,11 @@ DECLARE
     sub RECORD;
     owner_id UUID;
 BEGIN
+    -- Held orders only count once they are finalized
+    IF NEW.status = 'pending' THEN
+        RETURN NEW;
+    END IF;
+    
     SELECT user_id INTO owner_id FROM stores WHERE id = NEW.store_id;
     SELECT * INTO sub FROM subscriptions WHERE user_id = owner_id;
     
@@ -490,6 +1221,12 @@ CREATE TRIGGER trigger_check_transaction_limit
     FOR EACH ROW
     EXECUTE FUNCTION check_transaction_limit();
 
+CREATE TRIGGER trigger_check_transaction_limit_on_finalize
+    BEFORE UPDATE OF status ON transactions
+    FOR EACH ROW
+    WHEN (OLD.status = 'pending' AND NEW.status = 'completed')
+    EXECUTE FUNCTION check_transaction_limit();
+
 -- Function to reset monthly transaction count
 CREATE OR REPLACE FUNCTION reset_monthly_transaction_count()
 RETURNS void AS $$
@@ -509,8 +1246,8 @@ CREATE OR REPLACE FUNCTION get_low_stock_products(store_uuid UUID)
 RETURNS TABLE (
     product_id UUID,
     product_name VARCHAR,
-    current_stock INTEGER,
-    min_stock INTEGER
+    current_stock DECIMAL,
+    min_stock DECIMAL
 ) AS $$
 BEGIN
     RETURN QUERY
@@ -528,36 +1265,95 @@ $$ LANGUAGE plpgsql;
 -- VIEWS FOR REPORTING
 -- =====================================================
 
+-- Sales and refunds as one ledger that every sales report sums, so they all
+-- net refunds the same way. A completed sale is entered at its full value
+-- when it was paid and each refund, negated, when it was given: a refund
+-- lowers the day it was given and never rewrites a day already closed. A
+-- held order counts when it was finalized, the date on its invoice, not
+-- when it was opened.
+CREATE OR REPLACE VIEW sales_entries AS
+SELECT 
+    t.store_id,
+    t.id as transaction_id,
+    COALESCE(t.completed_at, t.created_at) as occurred_at,
+    true as is_sale,
+    t.total as amount,
+    t.discount_amount as discount,
+    t.rounding_adjustment as rounding,
+    COALESCE((
+        SELECT SUM(ti.cost * ti.quantity)
+        FROM transaction_items ti
+        WHERE ti.transaction_id = t.id
+    ), 0) as cost
+FROM transactions t
+WHERE t.status IN ('completed', 'refunded')
+UNION ALL
+SELECT 
+    r.store_id,
+    r.transaction_id,
+    r.created_at,
+    false,
+    -r.amount,
+    0,
+    0,
+    -r.cost_amount
+FROM refunds r;
+
+-- The same ledger per sale line: each line at the quantity sold when it was
+-- sold, and each refunded quantity, negated, when it was refunded
+CREATE OR REPLACE VIEW sale_item_entries AS
+SELECT 
+    t.store_id,
+    t.id as transaction_id,
+    ti.id as transaction_item_id,
+    COALESCE(t.completed_at, t.created_at) as occurred_at,
+    true as is_sale,
+    ti.quantity
+FROM transaction_items ti
+JOIN transactions t ON t.id = ti.transaction_id
+WHERE t.status IN ('completed', 'refunded')
+UNION ALL
+SELECT 
+    r.store_id,
+    r.transaction_id,
+    ri.transaction_item_id,
+    r.created_at,
+    false,
+    -ri.quantity
+FROM refund_items ri
+JOIN refunds r ON r.id = ri.refund_id;
+
 -- Daily sales view
 CREATE OR REPLACE VIEW daily_sales AS
 SELECT 
     store_id,
-    DATE(created_at) as sale_date,
-    COUNT(*) as total_transactions,
-    SUM(total) as total_sales,
-    SUM(discount_amount) as total_discounts,
-    SUM(total - COALESCE((
-        SELECT SUM(ti.cost * ti.quantity) 
-        FROM transaction_items ti 
-        WHERE ti.transaction_id = transactions.id
-    ), 0)) as gross_profit,
-    AVG(total) as average_transaction
-FROM transactions
-WHERE status = 'completed'
-GROUP BY store_id, DATE(created_at);
+    DATE(occurred_at) as sale_date,
+    COUNT(*) FILTER (WHERE is_sale) as total_transactions,
+    SUM(amount) as total_sales,
+    SUM(discount) as total_discounts,
+    COALESCE(-SUM(amount) FILTER (WHERE NOT is_sale), 0) as total_refunds,
+    SUM(amount - cost) as gross_profit,
+    AVG(amount) FILTER (WHERE is_sale) as average_transaction
+FROM sales_entries
+GROUP BY store_id, DATE(occurred_at);
 
 -- Product performance view
+-- Variant sales are rolled up into their parent product
 CREATE OR REPLACE VIEW product_performance AS
 SELECT 
     p.id as product_id,
     p.store_id,
     p.name as product_name,
     p.category_id,
-    COALESCE(SUM(ti.quantity), 0) as total_sold,
-    COALESCE(SUM(ti.subtotal), 0) as total_revenue,
-    COALESCE(SUM((ti.product_price - ti.cost) * ti.quantity), 0) as total_profit,
-    COUNT(DISTINCT ti.transaction_id) as transaction_count
+    COALESCE(SUM(e.quantity * ti.unit_factor), 0) as total_sold,
+    COALESCE(SUM(ti.subtotal * e.quantity / ti.quantity), 0) as total_revenue,
+    COALESCE(SUM((ti.product_price + ti.modifier_amount - ti.cost) * e.quantity), 0) as total_profit,
+    COUNT(DISTINCT e.transaction_id) FILTER (WHERE e.is_sale) as transaction_count
 FROM products p
-LEFT JOIN transaction_items ti ON p.id = ti.product_id
-LEFT JOIN transactions t ON ti.transaction_id = t.id AND t.status = 'completed'
+LEFT JOIN products v ON v.id = p.id OR v.parent_id = p.id
+LEFT JOIN (
+    sale_item_entries e
+    JOIN transaction_items ti ON ti.id = e.transaction_item_id
+) ON v.id = ti.product_id
+WHERE p.parent_id IS NULL
 GROUP BY p.id, p.store_id, p.name, p.category_id;
@@ -79,9 +79,26 @@ export const transactionsAPI = {
     list: (storeId, params) => api.get(`/stores/${storeId}/transactions`, { params }),
     get: (storeId, id) => api.get(`/stores/${storeId}/transactions/${id}`),
     create: (storeId, data) => api.post(`/stores/${storeId}/transactions`, data),
+    hold: (storeId, data) => api.post(`/stores/${storeId}/transactions/hold`, data),
+    listHeld: (storeId) => api.get(`/stores/${storeId}/transactions/held`),
+    resume: (storeId, id) => api.post(`/stores/${storeId}/transactions/${id}/resume`),
+    updateHeld: (storeId, id, data) => api.put(`/stores/${storeId}/transactions/${id}/hold`, data),
+    discardHeld: (storeId, id) => api.delete(`/stores/${storeId}/transactions/${id}/hold`),
+    finalize: (storeId, id, data) => api.post(`/stores/${storeId}/transactions/${id}/finalize`, data),
+    refund: (storeId, id, data) => api.post(`/stores/${storeId}/transactions/${id}/refund`, data),
+    requestVoid: (storeId, id, data) => api.post(`/stores/${storeId}/transactions/${id}/void`, data),
+    listVoids: (storeId, params) => api.get(`/stores/${storeId}/voids`, { params }),
+    approveVoid: (storeId, voidId) => api.post(`/stores/${storeId}/voids/${voidId}/approve`),
+    rejectVoid: (storeId, voidId) => api.post(`/stores/${storeId}/voids/${voidId}/reject`),
     sendReceipt: (storeId, data) => api.post(`/stores/${storeId}/whatsapp/send-receipt`, data),
 }
 
+// Offline sync API
+export const syncAPI = {
+    pull: (storeId, since) => api.get(`/stores/${storeId}/sync/pull`, { params: { since } }),
+    push: (storeId, sales) => api.post(`/stores/${storeId}/sync/push`, { sales }),
+}
+
 // Customers API
 export const customersAPI = {
     list: (storeId, params) => api.get(`/stores/${storeId}/customers`, { params }),
@@ -92,6 +109,25 @@ export const customersAPI = {
     findOrCreate: (storeId, data) => api.post(`/stores/${storeId}/customers/find-or-create`, data),
 }
 
+// Promos API
+export const promosAPI = {
+    list: (storeId, params) => api.get(`/stores/${storeId}/promos`, { params }),
+    get: (storeId, id) => api.get(`/stores/${storeId}/promos/${id}`),
+    create: (storeId, data) => api.post(`/stores/${storeId}/promos`, data),
+    update: (storeId, id, data) => api.put(`/stores/${storeId}/promos/${id}`, data),
+    delete: (storeId, id) => api.delete(`/stores/${storeId}/promos/${id}`),
+}
+
+// Shifts API
+export const shiftsAPI = {
+    list: (storeId, params) => api.get(`/stores/${storeId}/shifts`, { params }),
+    open: (storeId, data) => api.post(`/stores/${storeId}/shifts/open`, data),
+    current: (storeId) => api.get(`/stores/${storeId}/shifts/current`),
+    addCash: (storeId, id, data) => api.post(`/stores/${storeId}/shifts/${id}/cash`, data),
+    close: (storeId, id, data) => api.post(`/stores/${storeId}/shifts/${id}/close`, data),
+    getReport: (storeId, id) => api.get(`/stores/${storeId}/shifts/${id}/report`),
+}
+
 // Reports API
 export const reportsAPI = {
     getDashboard: (storeId, params) => api.get(`/stores/${storeId}/reports/dashboard`, { params }),
@@ -100,6 +136,7 @@ export const reportsAPI = {
     getMonthly: (storeId, params) => api.get(`/stores/${storeId}/reports/monthly`, { params }),
     getProducts: (storeId, params) => api.get(`/stores/${storeId}/reports/products`, { params }),
     getProfitLoss: (storeId, params) => api.get(`/stores/${storeId}/reports/profit-loss`, { params }),
+    getPromos: (storeId, params) => api.get(`/stores/${storeId}/reports/promos`, { params }),
     exportCSV: (storeId, params) => api.get(`/stores/${storeId}/reports/export`, { params, responseType: 'blob' }),
 }
 
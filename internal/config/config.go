@@ -25,6 +25,7 @@ type Config struct {
 	CORSOrigins        string
 	RateLimitMax       int
 	RateLimitWindow    int
+	IdempotencyTTL     time.Duration
 }
 
 var AppConfig *Config
@@ -39,6 +40,7 @@ func Load() error {
 	jwtExpiry, _ := time.ParseDuration(getEnv("JWT_EXPIRY", "24h"))
 	rateLimitMax, _ := strconv.Atoi(getEnv("RATE_LIMIT_MAX", "100"))
 	rateLimitWindow, _ := strconv.Atoi(getEnv("RATE_LIMIT_WINDOW", "60"))
+	idempotencyTTL, _ := time.ParseDuration(getEnv("IDEMPOTENCY_TTL", "24h"))
 
 	// Get DATABASE_URL or construct from Supabase URL
 	databaseURL := getEnv("DATABASE_URL", "")
@@ -67,6 +69,7 @@ func Load() error {
 		CORSOrigins:        getEnv("CORS_ORIGINS", "*"),
 		RateLimitMax:       rateLimitMax,
 		RateLimitWindow:    rateLimitWindow,
+		IdempotencyTTL:     idempotencyTTL,
 	}
 
 	// Validation - require DATABASE_URL for the app to work
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const stockBatchColumns = `
+	b.id, b.product_id, b.store_id, b.batch_number, TO_CHAR(b.expiry_date, 'YYYY-MM-DD'),
+	b.received_quantity, b.quantity, b.created_at, b.updated_at,
+	p.name, p.unit, p.barcode, b.expiry_date - CURRENT_DATE, p.cost`
+
+// queryStockBatches returns the batches matching where, in the order they
+// are sold: first to expire first
+func queryStockBatches(q queryer, where string, args ...interface{}) ([]models.StockBatch, error) {
+	rows, err := q.Query(`
+		SELECT `+stockBatchColumns+`
+		FROM stock_batches b
+		JOIN products p ON p.id = b.product_id
+		WHERE `+where+`
+		ORDER BY b.expiry_date ASC NULLS LAST, b.created_at ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := []models.StockBatch{}
+	for rows.Next() {
+		var b models.StockBatch
+		var cost models.Money
+		if err := rows.Scan(
+			&b.ID, &b.ProductID, &b.StoreID, &b.BatchNumber, &b.ExpiryDate,
+			&b.ReceivedQuantity, &b.Quantity, &b.CreatedAt, &b.UpdatedAt,
+			&b.ProductName, &b.Unit, &b.Barcode, &b.DaysLeft, &cost,
+		); err != nil {
+			return nil, err
+		}
+		b.Value = cost.MulQuantity(b.Quantity)
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+// hasBatch reports whether stock comes in under a batch number or expiry
+// date
+func hasBatch(batchNumber, expiryDate *string) bool {
+	return (batchNumber != nil && *batchNumber != "") || expiryDate != nil
+}
+
+// addStockBatch records quantity of a product received under a batch number
+// or expiry date. More of a batch already in stock joins it.
+func addStockBatch(tx *sql.Tx, storeID, productID uuid.UUID, batchNumber, expiryDate *string, quantity models.Quantity) error {
+	if !hasBatch(batchNumber, expiryDate) {
+		return nil
+	}
+	if batchNumber != nil && *batchNumber == "" {
+		batchNumber = nil
+	}
+
+	result, err := tx.Exec(`
+		UPDATE stock_batches SET
+			quantity = quantity + $4,
+			received_quantity = received_quantity + $4,
+			updated_at = NOW()
+		WHERE product_id = $1
+		  AND batch_number IS NOT DISTINCT FROM $2
+		  AND expiry_date IS NOT DISTINCT FROM $3::date
+	`, productID, batchNumber, expiryDate, quantity)
+	if err != nil {
+		return err
+	}
+	if joined, _ := result.RowsAffected(); joined > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO stock_batches (product_id, store_id, batch_number, expiry_date, received_quantity, quantity)
+		VALUES ($1, $2, $3, $4::date, $5, $5)
+	`, productID, storeID, batchNumber, expiryDate, quantity)
+	return err
+}
+
+// consumeStockBatches takes quantity of a product from its batches, first
+// expired first out, as sales do. Expired batches are passed over; see
+// consume_stock_batches. The product's stock must already be reduced.
+func consumeStockBatches(tx *sql.Tx, productID uuid.UUID, quantity models.Quantity) error {
+	_, err := tx.Exec(`SELECT consume_stock_batches($1, $2)`, productID, quantity)
+	return err
+}
+
+// takeFromBatch takes quantity of a product from one batch
+func takeFromBatch(tx *sql.Tx, productID, batchID uuid.UUID, quantity models.Quantity) error {
+	var left models.Quantity
+	err := tx.QueryRow(`
+		SELECT quantity FROM stock_batches WHERE id = $1 AND product_id = $2
+	`, batchID, productID).Scan(&left)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("Batch not found: %s", batchID)
+	}
+	if err != nil {
+		return err
+	}
+	if left < quantity {
+		return fmt.Errorf("Only %s left in the batch", left)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE stock_batches SET quantity = quantity - $2, updated_at = NOW()
+		WHERE id = $1
+	`, batchID, quantity)
+	return err
+}
+
+// ListStockBatches returns the batches in stock, of one product or all
+func ListStockBatches(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	where := `b.store_id = $1 AND b.quantity > 0`
+	args := []interface{}{storeID}
+	if productID, err := uuid.Parse(c.Query("product_id")); err == nil {
+		where += ` AND b.product_id = $2`
+		args = append(args, productID)
+	}
+
+	batches, err := queryStockBatches(database.DB, where, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock batches",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    batches,
+	})
+}
+
+// expiringStockBatches returns the batches in stock that expire within days,
+// along with those already expired
+func expiringStockBatches(q queryer, storeID uuid.UUID, days int) ([]models.StockBatch, error) {
+	return queryStockBatches(q, `
+		b.store_id = $1 AND b.quantity > 0 AND p.is_active = true
+		AND b.expiry_date <= CURRENT_DATE + $2::integer
+	`, storeID, days)
+}
+
+// GetExpiringStock returns stock that expires within the given number of
+// days (30 by default), or already has, so it can be discounted or pulled
+func GetExpiringStock(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	days, err := strconv.Atoi(c.Query("days", "30"))
+	if err != nil || days < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "days must be a whole number of 0 or more",
+		})
+	}
+
+	batches, err := expiringStockBatches(database.DB, storeID, days)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch expiring stock",
+		})
+	}
+
+	expired := 0
+	var value models.Money
+	for _, b := range batches {
+		if *b.DaysLeft < 0 {
+			expired++
+		}
+		value += b.Value
+	}
+
+	return c.JSON(fiber.Map{
+		"success":       true,
+		"data":          batches,
+		"count":         len(batches),
+		"expired_count": expired,
+		"total_value":   value,
+	})
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// loadBundles returns the components of the given bundles keyed by bundle
+// id. Component costs are rolled up from recipes for composite products.
+// Products that aren't bundles are left out.
+func loadBundles(q queryer, productIDs []uuid.UUID) (map[uuid.UUID][]models.BundleItem, error) {
+	bundles := map[uuid.UUID][]models.BundleItem{}
+	if len(productIDs) == 0 {
+		return bundles, nil
+	}
+	ids := make([]string, 0, len(productIDs))
+	for _, id := range productIDs {
+		ids = append(ids, id.String())
+	}
+
+	rows, err := q.Query(`
+		SELECT b.bundle_id, b.product_id, b.quantity, p.name, p.cost
+		FROM bundle_items b
+		JOIN products p ON p.id = b.product_id
+		WHERE b.bundle_id = ANY($1::uuid[])
+		ORDER BY p.name ASC
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	var bundleIDs []uuid.UUID
+	unitCosts := map[uuid.UUID]models.Money{}
+	var componentIDs []uuid.UUID
+	for rows.Next() {
+		var bundleID uuid.UUID
+		var item models.BundleItem
+		var unitCost models.Money
+		if err := rows.Scan(&bundleID, &item.ProductID, &item.Quantity, &item.ProductName, &unitCost); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if _, ok := bundles[bundleID]; !ok {
+			bundleIDs = append(bundleIDs, bundleID)
+		}
+		if _, ok := unitCosts[item.ProductID]; !ok {
+			componentIDs = append(componentIDs, item.ProductID)
+		}
+		unitCosts[item.ProductID] = unitCost
+		bundles[bundleID] = append(bundles[bundleID], item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	recipes, err := loadRecipes(q, componentIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, bundleID := range bundleIDs {
+		items := bundles[bundleID]
+		for i := range items {
+			unitCost := unitCosts[items[i].ProductID]
+			if recipe, ok := recipes[items[i].ProductID]; ok {
+				unitCost = recipeCost(recipe)
+			}
+			items[i].Cost = unitCost.Mul(items[i].Quantity)
+		}
+	}
+	return bundles, nil
+}
+
+// bundleCost is the cost of one bundle
+func bundleCost(items []models.BundleItem) models.Money {
+	var cost models.Money
+	for _, item := range items {
+		cost += item.Cost
+	}
+	return cost
+}
+
+// GetProductBundle returns the components of a bundle and their cost
+func GetProductBundle(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+
+	var exists bool
+	database.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND store_id = $2)
+	`, productUUID, storeID).Scan(&exists)
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+
+	bundles, err := loadBundles(database.DB, []uuid.UUID{productUUID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch bundle",
+		})
+	}
+
+	items := bundles[productUUID]
+	if items == nil {
+		items = []models.BundleItem{}
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": models.Bundle{
+			ProductID: productUUID,
+			Items:     items,
+			Cost:      bundleCost(items),
+		},
+	})
+}
+
+// SetProductBundle replaces the components of a bundle. A bundle keeps no
+// stock of its own; selling it deducts its components.
+func SetProductBundle(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+
+	var req models.SetBundleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var productName string
+	var hasVariants, hasRecipe, isComponent bool
+	err = tx.QueryRow(`
+		SELECT name, variant_options IS NOT NULL,
+		       EXISTS(SELECT 1 FROM recipe_items WHERE product_id = products.id OR ingredient_id = products.id),
+		       EXISTS(SELECT 1 FROM bundle_items WHERE product_id = products.id)
+		FROM products
+		WHERE id = $1 AND store_id = $2 AND is_active = true
+		FOR UPDATE
+	`, productUUID, storeID).Scan(&productName, &hasVariants, &hasRecipe, &isComponent)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product",
+		})
+	}
+	if len(req.Items) > 0 {
+		if hasVariants {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Bundles of " + productName + " are set per variant",
+			})
+		}
+		if hasRecipe {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   productName + " is part of a recipe",
+			})
+		}
+		if isComponent {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   productName + " is part of another bundle",
+			})
+		}
+	}
+
+	if err := setBundleItems(tx, storeID, productUUID, req.Items); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	// Touch the product so terminals pick up the change on their next sync
+	if _, err := tx.Exec(`
+		UPDATE products
+		SET track_stock = CASE WHEN $2 THEN false ELSE track_stock END, updated_at = NOW()
+		WHERE id = $1
+	`, productUUID, len(req.Items) > 0); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update product",
+		})
+	}
+
+	bundles, err := loadBundles(tx, []uuid.UUID{productUUID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch bundle",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to save bundle",
+		})
+	}
+
+	items := bundles[productUUID]
+	if items == nil {
+		items = []models.BundleItem{}
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": models.Bundle{
+			ProductID: productUUID,
+			Items:     items,
+			Cost:      bundleCost(items),
+		},
+	})
+}
+
+// setBundleItems replaces the components of a bundle. Components must be
+// active products of the store that are sold on their own: not the bundle
+// itself, not a parent with variants and not a bundle.
+func setBundleItems(tx *sql.Tx, storeID, bundleID uuid.UUID, items []models.BundleItemRequest) error {
+	if _, err := tx.Exec(`DELETE FROM bundle_items WHERE bundle_id = $1`, bundleID); err != nil {
+		return err
+	}
+
+	seen := map[uuid.UUID]bool{}
+	for _, item := range items {
+		if seen[item.ProductID] {
+			return fmt.Errorf("A product is listed twice")
+		}
+		seen[item.ProductID] = true
+		if item.ProductID == bundleID {
+			return fmt.Errorf("A bundle can't contain itself")
+		}
+
+		var name string
+		var hasVariants, isBundle bool
+		err := tx.QueryRow(`
+			SELECT name, variant_options IS NOT NULL,
+			       EXISTS(SELECT 1 FROM bundle_items WHERE bundle_id = products.id)
+			FROM products
+			WHERE id = $1 AND store_id = $2 AND is_active = true
+		`, item.ProductID, storeID).Scan(&name, &hasVariants, &isBundle)
+		if err != nil {
+			return fmt.Errorf("Product not found: %s", item.ProductID)
+		}
+		if hasVariants {
+			return fmt.Errorf("Choose a variant of %s", name)
+		}
+		if isBundle {
+			return fmt.Errorf("%s is a bundle itself", name)
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO bundle_items (bundle_id, product_id, quantity)
+			VALUES ($1, $2, $3)
+		`, bundleID, item.ProductID, item.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertItemComponents records the components of a sold bundle
+func insertItemComponents(tx *sql.Tx, itemID uuid.UUID, components []models.TransactionItemComponent) ([]models.TransactionItemComponent, error) {
+	var result []models.TransactionItemComponent
+	for _, comp := range components {
+		comp.TransactionItemID = itemID
+		if err := tx.QueryRow(`
+			INSERT INTO transaction_item_components (transaction_item_id, product_id, product_name, quantity)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+		`, itemID, comp.ProductID, comp.ProductName, comp.Quantity).Scan(&comp.ID); err != nil {
+			return nil, err
+		}
+		result = append(result, comp)
+	}
+	return result, nil
+}
+
+// loadItemComponents returns the bundle components of a transaction's items,
+// keyed by transaction item
+func loadItemComponents(q queryer, transactionID uuid.UUID) map[uuid.UUID][]models.TransactionItemComponent {
+	components := map[uuid.UUID][]models.TransactionItemComponent{}
+	rows, err := q.Query(`
+		SELECT c.id, c.transaction_item_id, c.product_id, c.product_name, c.quantity
+		FROM transaction_item_components c
+		JOIN transaction_items ti ON c.transaction_item_id = ti.id
+		WHERE ti.transaction_id = $1
+		ORDER BY c.created_at ASC
+	`, transactionID)
+	if err != nil {
+		return components
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var comp models.TransactionItemComponent
+		rows.Scan(&comp.ID, &comp.TransactionItemID, &comp.ProductID, &comp.ProductName, &comp.Quantity)
+		components[comp.TransactionItemID] = append(components[comp.TransactionItemID], comp)
+	}
+	return components
+}
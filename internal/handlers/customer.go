@@ -129,11 +129,11 @@ func GetCustomer(c *fiber.Ctx) error {
 	defer rows.Close()
 
 	type RecentTransaction struct {
-		ID            uuid.UUID `json:"id"`
-		InvoiceNumber string    `json:"invoice_number"`
-		Total         float64   `json:"total"`
-		PaymentType   string    `json:"payment_type"`
-		CreatedAt     string    `json:"created_at"`
+		ID            uuid.UUID    `json:"id"`
+		InvoiceNumber string       `json:"invoice_number"`
+		Total         models.Money `json:"total"`
+		PaymentType   string       `json:"payment_type"`
+		CreatedAt     string       `json:"created_at"`
 	}
 	var recentTx []RecentTransaction
 	for rows.Next() {
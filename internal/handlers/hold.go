@@ -0,0 +1,631 @@
+package handlers
+
+import (
+	"database/sql"
+	"strings"
+
+	"kasirku/internal/database"
+	"kasirku/internal/middleware"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// HoldTransaction parks a cart as a pending transaction. Held orders don't
+// deduct stock or count toward the plan limit until they are finalized.
+func HoldTransaction(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	var req models.HoldTransactionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	sale, err := priceSale(tx, storeID, holdToSaleRequest(req), false)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	// Held orders get a placeholder number; the real invoice number is only
+	// issued on finalize so discarded holds don't use one up
+	holdNumber := "HOLD-" + strings.ToUpper(uuid.New().String()[:8])
+
+	var transaction models.Transaction
+	err = tx.QueryRow(`
+		INSERT INTO transactions (
+			store_id, customer_id, cashier_id, invoice_number,
+			subtotal, discount_amount, discount_percent, tax_amount, rounding_adjustment, total, notes,
+			promo_id, promo_discount, service_charge, status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, 'pending')
+		RETURNING id, store_id, customer_id, cashier_id, invoice_number,
+		          subtotal, discount_amount, discount_percent, service_charge, tax_amount, rounding_adjustment, total,
+		          promo_id, promo_discount, notes, status, created_at, updated_at
+	`, storeID, req.CustomerID, userID, holdNumber,
+		sale.Subtotal, sale.Discount, req.DiscountPercent, sale.Tax, sale.Rounding, sale.Total, req.Notes,
+		sale.PromoID, sale.PromoDiscount, sale.ServiceCharge).Scan(
+		&transaction.ID, &transaction.StoreID, &transaction.CustomerID, &transaction.CashierID,
+		&transaction.InvoiceNumber, &transaction.Subtotal, &transaction.DiscountAmount,
+		&transaction.DiscountPercent, &transaction.ServiceCharge, &transaction.TaxAmount, &transaction.RoundingAdjustment, &transaction.Total,
+		&transaction.PromoID, &transaction.PromoDiscount,
+		&transaction.Notes, &transaction.Status, &transaction.CreatedAt, &transaction.UpdatedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to hold transaction: " + err.Error(),
+		})
+	}
+
+	transaction.Items, err = insertSaleItems(tx, transaction.ID, sale.Lines)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create transaction item: " + err.Error(),
+		})
+	}
+
+	transaction.Taxes, err = insertSaleTaxes(tx, transaction.ID, sale.Taxes)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to record taxes: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to hold transaction",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    transaction,
+		"message": "Transaction held successfully",
+	})
+}
+
+// ListHeldTransactions returns the held (pending) orders of a store
+func ListHeldTransactions(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	rows, err := database.DB.Query(`
+		SELECT t.id, t.store_id, t.customer_id, t.cashier_id, t.invoice_number,
+		       t.subtotal, t.discount_amount, t.discount_percent, t.service_charge, t.tax_amount, t.total,
+		       t.status, t.notes, t.created_at, t.updated_at,
+		       c.name as customer_name, u.full_name as cashier_name
+		FROM transactions t
+		LEFT JOIN customers c ON t.customer_id = c.id
+		LEFT JOIN users u ON t.cashier_id = u.id
+		WHERE t.store_id = $1 AND t.status = 'pending'
+		ORDER BY t.created_at ASC
+	`, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch held transactions",
+		})
+	}
+	defer rows.Close()
+
+	var transactions []models.Transaction
+	for rows.Next() {
+		var t models.Transaction
+		rows.Scan(
+			&t.ID, &t.StoreID, &t.CustomerID, &t.CashierID, &t.InvoiceNumber,
+			&t.Subtotal, &t.DiscountAmount, &t.DiscountPercent, &t.ServiceCharge, &t.TaxAmount, &t.Total,
+			&t.Status, &t.Notes, &t.CreatedAt, &t.UpdatedAt,
+			&t.CustomerName, &t.CashierName,
+		)
+		transactions = append(transactions, t)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    transactions,
+		"count":   len(transactions),
+	})
+}
+
+// ResumeHeldTransaction returns a held order with its items so the POS can
+// load it back into the cart
+func ResumeHeldTransaction(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	transactionID := c.Params("id")
+
+	txUUID, err := uuid.Parse(transactionID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid transaction ID",
+		})
+	}
+
+	var t models.Transaction
+	err = database.DB.QueryRow(`
+		SELECT t.id, t.store_id, t.customer_id, t.cashier_id, t.invoice_number,
+		       t.subtotal, t.discount_amount, t.discount_percent, t.service_charge, t.tax_amount, t.total,
+		       t.status, t.notes, t.created_at, t.updated_at, c.name as customer_name
+		FROM transactions t
+		LEFT JOIN customers c ON t.customer_id = c.id
+		WHERE t.id = $1 AND t.store_id = $2 AND t.status = 'pending'
+	`, txUUID, storeID).Scan(
+		&t.ID, &t.StoreID, &t.CustomerID, &t.CashierID, &t.InvoiceNumber,
+		&t.Subtotal, &t.DiscountAmount, &t.DiscountPercent, &t.ServiceCharge, &t.TaxAmount, &t.Total,
+		&t.Status, &t.Notes, &t.CreatedAt, &t.UpdatedAt, &t.CustomerName,
+	)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Held transaction not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch held transaction",
+		})
+	}
+
+	t.Items = loadTransactionItems(txUUID)
+	t.Taxes = loadTransactionTaxes(txUUID)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    t,
+	})
+}
+
+// UpdateHeldTransaction replaces the cart of a held order
+func UpdateHeldTransaction(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	transactionID := c.Params("id")
+
+	txUUID, err := uuid.Parse(transactionID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid transaction ID",
+		})
+	}
+
+	var req models.HoldTransactionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	if err := lockHeldTransaction(tx, storeID, txUUID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Held transaction not found",
+		})
+	}
+
+	sale, err := priceSale(tx, storeID, holdToSaleRequest(req), false)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	var transaction models.Transaction
+	err = tx.QueryRow(`
+		UPDATE transactions SET
+			customer_id = $3,
+			subtotal = $4,
+			discount_amount = $5,
+			discount_percent = $6,
+			tax_amount = $7,
+			total = $8,
+			notes = $9,
+			promo_id = $10,
+			promo_discount = $11,
+			rounding_adjustment = $12,
+			service_charge = $13,
+			updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+		RETURNING id, store_id, customer_id, cashier_id, invoice_number,
+		          subtotal, discount_amount, discount_percent, service_charge, tax_amount, rounding_adjustment, total,
+		          promo_id, promo_discount, notes, status, created_at, updated_at
+	`, txUUID, storeID, req.CustomerID, sale.Subtotal, sale.Discount, req.DiscountPercent,
+		sale.Tax, sale.Total, req.Notes, sale.PromoID, sale.PromoDiscount, sale.Rounding, sale.ServiceCharge).Scan(
+		&transaction.ID, &transaction.StoreID, &transaction.CustomerID, &transaction.CashierID,
+		&transaction.InvoiceNumber, &transaction.Subtotal, &transaction.DiscountAmount,
+		&transaction.DiscountPercent, &transaction.ServiceCharge, &transaction.TaxAmount, &transaction.RoundingAdjustment, &transaction.Total,
+		&transaction.PromoID, &transaction.PromoDiscount, &transaction.Notes, &transaction.Status, &transaction.CreatedAt, &transaction.UpdatedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update held transaction",
+		})
+	}
+
+	if err := clearSaleLines(tx, txUUID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update held transaction",
+		})
+	}
+
+	transaction.Items, err = insertSaleItems(tx, txUUID, sale.Lines)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create transaction item: " + err.Error(),
+		})
+	}
+
+	transaction.Taxes, err = insertSaleTaxes(tx, txUUID, sale.Taxes)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to record taxes: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update held transaction",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    transaction,
+	})
+}
+
+// FinalizeHeldTransaction takes payment for a held order and completes it.
+// Prices and stock are checked again at this point, then the status change
+// fires the stock and plan-limit triggers.
+func FinalizeHeldTransaction(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+	transactionID := c.Params("id")
+
+	txUUID, err := uuid.Parse(transactionID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid transaction ID",
+		})
+	}
+
+	var payReq models.FinalizeTransactionRequest
+	if err := c.BodyParser(&payReq); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(payReq); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	if err := lockHeldTransaction(tx, storeID, txUUID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Held transaction not found",
+		})
+	}
+
+	// Rebuild the sale request from what was held
+	req := models.CreateTransactionRequest{
+		PaymentAmount: payReq.PaymentAmount,
+		PaymentType:   payReq.PaymentType,
+		PaymentRef:    payReq.PaymentRef,
+		Payments:      payReq.Payments,
+	}
+	// discount_amount includes the promo discount, which is worked out again
+	// from the promo code
+	err = tx.QueryRow(`
+		SELECT t.customer_id, t.discount_amount - t.promo_discount, t.discount_percent, t.notes, p.code
+		FROM transactions t
+		LEFT JOIN promos p ON t.promo_id = p.id
+		WHERE t.id = $1
+	`, txUUID).Scan(&req.CustomerID, &req.DiscountAmount, &req.DiscountPercent, &req.Notes, &req.PromoCode)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch held transaction",
+		})
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, product_id, quantity, discount_amount, discount_percent, unit_id
+		FROM transaction_items
+		WHERE transaction_id = $1 AND product_id IS NOT NULL
+		ORDER BY created_at ASC
+	`, txUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch held items",
+		})
+	}
+	var itemIDs []uuid.UUID
+	for rows.Next() {
+		var itemID uuid.UUID
+		var item models.CreateTransactionItemRequest
+		rows.Scan(&itemID, &item.ProductID, &item.Quantity, &item.DiscountAmount, &item.DiscountPercent, &item.UnitID)
+		itemIDs = append(itemIDs, itemID)
+		req.Items = append(req.Items, item)
+	}
+	rows.Close()
+
+	// Modifiers removed from the menu since the order was held are dropped,
+	// and pricing again reports any required choice that is now missing
+	heldModifiers := loadItemModifiers(tx, txUUID)
+	for i, itemID := range itemIDs {
+		for _, m := range heldModifiers[itemID] {
+			if m.ModifierID != nil {
+				req.Items[i].ModifierIDs = append(req.Items[i].ModifierIDs, *m.ModifierID)
+			}
+		}
+	}
+
+	if len(req.Items) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Held transaction has no items",
+		})
+	}
+
+	sale, err := priceSale(tx, storeID, req, true)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	sale.applyRounding(paidInCash(req))
+
+	payments, paymentAmount, changeAmount, paymentType, err := settlePayments(req, sale.Total)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	paymentRef := req.PaymentRef
+	if len(payments) == 1 && payments[0].Reference != nil {
+		paymentRef = payments[0].Reference
+	}
+
+	if sale.PromoID != nil {
+		if err := claimPromo(tx, *sale.PromoID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	// Replace the held lines with freshly priced ones while still pending,
+	// so the finalize trigger deducts stock for exactly these lines
+	if err := clearSaleLines(tx, txUUID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to finalize transaction",
+		})
+	}
+	items, err := insertSaleItems(tx, txUUID, sale.Lines)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create transaction item: " + err.Error(),
+		})
+	}
+	taxes, err := insertSaleTaxes(tx, txUUID, sale.Taxes)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to record taxes: " + err.Error(),
+		})
+	}
+
+	invoiceNumber, err := nextInvoiceNumber(tx, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to generate invoice number: " + err.Error(),
+		})
+	}
+
+	var transaction models.Transaction
+	err = tx.QueryRow(`
+		UPDATE transactions SET
+			cashier_id = $3,
+			invoice_number = $4,
+			subtotal = $5,
+			discount_amount = $6,
+			tax_amount = $7,
+			total = $8,
+			payment_amount = $9,
+			change_amount = $10,
+			payment_type = $11,
+			payment_reference = $12,
+			promo_id = $13,
+			promo_discount = $14,
+			shift_id = $15,
+			rounding_adjustment = $16,
+			service_charge = $17,
+			status = 'completed',
+			completed_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+		RETURNING id, store_id, customer_id, cashier_id, invoice_number,
+		          subtotal, discount_amount, discount_percent, service_charge, tax_amount, rounding_adjustment, total,
+		          payment_amount, change_amount, payment_type, promo_id, promo_discount,
+		          shift_id, status, created_at, completed_at
+	`, txUUID, storeID, userID, invoiceNumber, sale.Subtotal, sale.Discount, sale.Tax, sale.Total,
+		paymentAmount, changeAmount, paymentType, paymentRef, sale.PromoID, sale.PromoDiscount,
+		activeShiftID(tx, storeID, userID), sale.Rounding, sale.ServiceCharge).Scan(
+		&transaction.ID, &transaction.StoreID, &transaction.CustomerID, &transaction.CashierID,
+		&transaction.InvoiceNumber, &transaction.Subtotal, &transaction.DiscountAmount,
+		&transaction.DiscountPercent, &transaction.ServiceCharge, &transaction.TaxAmount, &transaction.RoundingAdjustment, &transaction.Total,
+		&transaction.PaymentAmount, &transaction.ChangeAmount, &transaction.PaymentType,
+		&transaction.PromoID, &transaction.PromoDiscount, &transaction.ShiftID,
+		&transaction.Status, &transaction.CreatedAt, &transaction.CompletedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to finalize transaction: " + err.Error(),
+		})
+	}
+	transaction.Items = items
+	transaction.Taxes = taxes
+	transaction.StockWarnings = sale.StockWarnings
+
+	transaction.Payments, err = insertPayments(tx, txUUID, payments)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to record payment: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to complete transaction",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    transaction,
+		"message": "Transaction completed successfully",
+	})
+}
+
+// DiscardHeldTransaction deletes a held order that will not be paid
+func DiscardHeldTransaction(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	transactionID := c.Params("id")
+
+	txUUID, err := uuid.Parse(transactionID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid transaction ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		DELETE FROM transactions
+		WHERE id = $1 AND store_id = $2 AND status = 'pending'
+	`, txUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to discard held transaction",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Held transaction not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Held transaction discarded",
+	})
+}
+
+// lockHeldTransaction locks a pending transaction for update
+func lockHeldTransaction(tx *sql.Tx, storeID, transactionID uuid.UUID) error {
+	var id uuid.UUID
+	return tx.QueryRow(`
+		SELECT id FROM transactions
+		WHERE id = $1 AND store_id = $2 AND status = 'pending'
+		FOR UPDATE
+	`, transactionID, storeID).Scan(&id)
+}
+
+// clearSaleLines deletes the item and tax lines of a held order before it is
+// priced again
+func clearSaleLines(tx *sql.Tx, transactionID uuid.UUID) error {
+	if _, err := tx.Exec(`DELETE FROM transaction_items WHERE transaction_id = $1`, transactionID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM transaction_taxes WHERE transaction_id = $1`, transactionID)
+	return err
+}
+
+// holdToSaleRequest converts a hold request into a sale request for pricing
+func holdToSaleRequest(req models.HoldTransactionRequest) models.CreateTransactionRequest {
+	return models.CreateTransactionRequest{
+		CustomerID:      req.CustomerID,
+		Items:           req.Items,
+		DiscountAmount:  req.DiscountAmount,
+		DiscountPercent: req.DiscountPercent,
+		PromoCode:       req.PromoCode,
+		Notes:           req.Notes,
+	}
+}
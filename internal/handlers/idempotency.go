@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"kasirku/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// claimIdempotencyKey reserves an idempotency key inside the sale transaction.
+// If the key was already used and has not expired, the stored response is
+// returned and the caller must replay it instead of creating a new sale.
+// A request still in flight with the same key holds the row lock, so a
+// concurrent retry waits here until the first one commits or rolls back.
+func claimIdempotencyKey(tx *sql.Tx, storeID uuid.UUID, key string) (replayStatus int, replayBody []byte, err error) {
+	// Expired keys are cleared as new ones come in
+	if _, err := tx.Exec(`
+		DELETE FROM idempotency_keys
+		WHERE store_id = $1 AND expires_at < NOW()
+	`, storeID); err != nil {
+		return 0, nil, err
+	}
+
+	ttl := time.Duration(0)
+	if config.AppConfig != nil {
+		ttl = config.AppConfig.IdempotencyTTL
+	}
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO idempotency_keys (store_id, key, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (store_id, key) DO NOTHING
+	`, storeID, key, time.Now().Add(ttl))
+	if err != nil {
+		return 0, nil, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 1 {
+		return 0, nil, nil
+	}
+
+	var status sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT response_status, response_body FROM idempotency_keys
+		WHERE store_id = $1 AND key = $2
+	`, storeID, key).Scan(&status, &replayBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !status.Valid {
+		return fiber.StatusConflict, marshalResponse(fiber.Map{
+			"success": false,
+			"error":   "A request with this Idempotency-Key is still being processed",
+		}), nil
+	}
+	return int(status.Int64), replayBody, nil
+}
+
+// saveIdempotentResponse stores the response sent for an idempotency key
+func saveIdempotentResponse(tx *sql.Tx, storeID uuid.UUID, key string, transactionID uuid.UUID, status int, body []byte) error {
+	_, err := tx.Exec(`
+		UPDATE idempotency_keys SET transaction_id = $3, response_status = $4, response_body = $5
+		WHERE store_id = $1 AND key = $2
+	`, storeID, key, transactionID, status, string(body))
+	return err
+}
+
+// replayIdempotentResponse sends a stored response back to a retrying client
+func replayIdempotentResponse(c *fiber.Ctx, status int, body []byte) error {
+	c.Set("Idempotent-Replayed", "true")
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Status(status).Send(body)
+}
+
+// idempotencyKey returns the Idempotency-Key header, falling back to a
+// client-generated transaction ID
+func idempotencyKey(c *fiber.Ctx, clientTransactionID *uuid.UUID) string {
+	if key := c.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	if clientTransactionID != nil {
+		return clientTransactionID.String()
+	}
+	return ""
+}
+
+// marshalResponse encodes a response body so it can be both stored and sent
+func marshalResponse(body fiber.Map) []byte {
+	data, _ := json.Marshal(body)
+	return data
+}
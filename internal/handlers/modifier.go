@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const modifierGroupColumns = `id, store_id, name, min_select, max_select, sort_order, is_active, created_at, updated_at`
+
+// queryModifierGroups returns the modifier groups matching where, with their
+// modifiers and the products and categories they are attached to
+func queryModifierGroups(q queryer, where string, args ...interface{}) ([]models.ModifierGroup, error) {
+	rows, err := q.Query(`
+		SELECT `+modifierGroupColumns+` FROM modifier_groups
+		WHERE `+where+`
+		ORDER BY sort_order ASC, name ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	groups := []models.ModifierGroup{}
+	index := map[uuid.UUID]int{}
+	var ids []string
+	for rows.Next() {
+		g := models.ModifierGroup{
+			Modifiers:   []models.Modifier{},
+			ProductIDs:  []uuid.UUID{},
+			CategoryIDs: []uuid.UUID{},
+		}
+		if err := rows.Scan(
+			&g.ID, &g.StoreID, &g.Name, &g.MinSelect, &g.MaxSelect,
+			&g.SortOrder, &g.IsActive, &g.CreatedAt, &g.UpdatedAt,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[g.ID] = len(groups)
+		ids = append(ids, g.ID.String())
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return groups, nil
+	}
+
+	modifierRows, err := q.Query(`
+		SELECT id, group_id, name, price, sort_order, is_active FROM modifiers
+		WHERE group_id = ANY($1::uuid[])
+		ORDER BY sort_order ASC, name ASC
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	for modifierRows.Next() {
+		var m models.Modifier
+		modifierRows.Scan(&m.ID, &m.GroupID, &m.Name, &m.Price, &m.SortOrder, &m.IsActive)
+		g := &groups[index[m.GroupID]]
+		g.Modifiers = append(g.Modifiers, m)
+	}
+	modifierRows.Close()
+
+	linkRows, err := q.Query(`
+		SELECT group_id, product_id, category_id FROM modifier_group_links
+		WHERE group_id = ANY($1::uuid[])
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer linkRows.Close()
+	for linkRows.Next() {
+		var groupID uuid.UUID
+		var productID, categoryID *uuid.UUID
+		linkRows.Scan(&groupID, &productID, &categoryID)
+		g := &groups[index[groupID]]
+		if productID != nil {
+			g.ProductIDs = append(g.ProductIDs, *productID)
+		}
+		if categoryID != nil {
+			g.CategoryIDs = append(g.CategoryIDs, *categoryID)
+		}
+	}
+	return groups, nil
+}
+
+// ListModifierGroups returns the modifier groups of a store
+func ListModifierGroups(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	where := `store_id = $1`
+	if c.QueryBool("active", false) {
+		where += ` AND is_active = true`
+	}
+
+	groups, err := queryModifierGroups(database.DB, where, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch modifier groups",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    groups,
+	})
+}
+
+// CreateModifierGroup creates a modifier group with its modifiers
+func CreateModifierGroup(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	var req models.CreateModifierGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	if req.MaxSelect > 0 && req.MinSelect > req.MaxSelect {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "min_select can't be more than max_select",
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var groupID uuid.UUID
+	err = tx.QueryRow(`
+		INSERT INTO modifier_groups (store_id, name, min_select, max_select, sort_order)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, storeID, req.Name, req.MinSelect, req.MaxSelect, req.SortOrder).Scan(&groupID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create modifier group: " + err.Error(),
+		})
+	}
+
+	if err := setModifiers(tx, groupID, req.Modifiers); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	if err := setModifierGroupLinks(tx, storeID, groupID, &req.ProductIDs, &req.CategoryIDs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	groups, err := queryModifierGroups(tx, `id = $1`, groupID)
+	if err != nil || len(groups) == 0 {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch modifier group",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create modifier group",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    groups[0],
+	})
+}
+
+// UpdateModifierGroup updates a modifier group
+func UpdateModifierGroup(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	groupUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid modifier group ID",
+		})
+	}
+
+	var req models.UpdateModifierGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var minSelect, maxSelect int
+	err = tx.QueryRow(`
+		UPDATE modifier_groups SET
+			name = COALESCE($3, name),
+			min_select = COALESCE($4, min_select),
+			max_select = COALESCE($5, max_select),
+			sort_order = COALESCE($6, sort_order),
+			is_active = COALESCE($7, is_active),
+			updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+		RETURNING min_select, max_select
+	`, groupUUID, storeID, req.Name, req.MinSelect, req.MaxSelect, req.SortOrder, req.IsActive).Scan(&minSelect, &maxSelect)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Modifier group not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update modifier group",
+		})
+	}
+	if maxSelect > 0 && minSelect > maxSelect {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "min_select can't be more than max_select",
+		})
+	}
+
+	if req.Modifiers != nil {
+		if err := setModifiers(tx, groupUUID, *req.Modifiers); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	if err := setModifierGroupLinks(tx, storeID, groupUUID, req.ProductIDs, req.CategoryIDs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	groups, err := queryModifierGroups(tx, `id = $1`, groupUUID)
+	if err != nil || len(groups) == 0 {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch modifier group",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update modifier group",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    groups[0],
+	})
+}
+
+// DeleteModifierGroup deactivates a modifier group. Past transactions keep
+// their copy of the chosen modifiers either way.
+func DeleteModifierGroup(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	groupUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid modifier group ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE modifier_groups SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+	`, groupUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete modifier group",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Modifier group not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Modifier group deleted successfully",
+	})
+}
+
+// setModifiers replaces the modifiers of a group. Modifiers sent with an ID
+// are updated, the others are added, and any left out are deactivated so
+// past sales can still point at them.
+func setModifiers(tx *sql.Tx, groupID uuid.UUID, modifiers []models.ModifierRequest) error {
+	keep := []string{}
+	for _, m := range modifiers {
+		if m.ID != nil {
+			result, err := tx.Exec(`
+				UPDATE modifiers SET name = $3, price = $4, sort_order = $5, is_active = true
+				WHERE id = $1 AND group_id = $2
+			`, m.ID, groupID, m.Name, m.Price, m.SortOrder)
+			if err != nil {
+				return err
+			}
+			if updated, _ := result.RowsAffected(); updated == 0 {
+				return fmt.Errorf("Modifier not found: %s", m.ID)
+			}
+			keep = append(keep, m.ID.String())
+			continue
+		}
+
+		var id uuid.UUID
+		if err := tx.QueryRow(`
+			INSERT INTO modifiers (group_id, name, price, sort_order)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+		`, groupID, m.Name, m.Price, m.SortOrder).Scan(&id); err != nil {
+			return err
+		}
+		keep = append(keep, id.String())
+	}
+
+	_, err := tx.Exec(`
+		UPDATE modifiers SET is_active = false
+		WHERE group_id = $1 AND NOT (id = ANY($2::uuid[]))
+	`, groupID, pq.Array(keep))
+	return err
+}
+
+// setModifierGroupLinks replaces the products and categories a group is
+// attached to. A nil list leaves that kind of link as it is.
+func setModifierGroupLinks(tx *sql.Tx, storeID, groupID uuid.UUID, productIDs, categoryIDs *[]uuid.UUID) error {
+	replace := func(column, table string, ids *[]uuid.UUID) error {
+		if ids == nil {
+			return nil
+		}
+		if _, err := tx.Exec(`
+			DELETE FROM modifier_group_links WHERE group_id = $1 AND `+column+` IS NOT NULL
+		`, groupID); err != nil {
+			return err
+		}
+		if len(*ids) == 0 {
+			return nil
+		}
+
+		unique := map[uuid.UUID]bool{}
+		var idStrings []string
+		for _, id := range *ids {
+			if !unique[id] {
+				unique[id] = true
+				idStrings = append(idStrings, id.String())
+			}
+		}
+		result, err := tx.Exec(`
+			INSERT INTO modifier_group_links (group_id, `+column+`)
+			SELECT $1, id FROM `+table+`
+			WHERE store_id = $2 AND id = ANY($3::uuid[])
+		`, groupID, storeID, pq.Array(idStrings))
+		if err != nil {
+			return err
+		}
+		if inserted, _ := result.RowsAffected(); int(inserted) != len(idStrings) {
+			return fmt.Errorf("Some %s were not found", table)
+		}
+		return nil
+	}
+
+	if err := replace("product_id", "products", productIDs); err != nil {
+		return err
+	}
+	return replace("category_id", "categories", categoryIDs)
+}
+
+// productModifierGroups returns the active modifier groups offered with a
+// product: those attached to it, to its parent if it is a variant, or to its
+// category
+func productModifierGroups(tx *sql.Tx, storeID, productID uuid.UUID, parentID, categoryID *uuid.UUID) ([]models.ModifierGroup, error) {
+	productIDs := []string{productID.String()}
+	if parentID != nil {
+		productIDs = append(productIDs, parentID.String())
+	}
+	return queryModifierGroups(tx, `store_id = $1 AND is_active = true AND id IN (
+		SELECT group_id FROM modifier_group_links
+		WHERE product_id = ANY($2::uuid[]) OR category_id = $3
+	)`, storeID, pq.Array(productIDs), categoryID)
+}
+
+// pickModifiers checks the modifiers chosen for an item against the groups
+// offered with it and returns them with their price per unit
+func pickModifiers(groups []models.ModifierGroup, chosen []uuid.UUID, productName string) ([]models.TransactionItemModifier, models.Money, error) {
+	type option struct {
+		group    *models.ModifierGroup
+		modifier models.Modifier
+	}
+	options := map[uuid.UUID]option{}
+	for i := range groups {
+		for _, m := range groups[i].Modifiers {
+			if m.IsActive {
+				options[m.ID] = option{group: &groups[i], modifier: m}
+			}
+		}
+	}
+
+	var picked []models.TransactionItemModifier
+	var amount models.Money
+	counts := map[uuid.UUID]int{}
+	seen := map[uuid.UUID]bool{}
+	for _, id := range chosen {
+		opt, ok := options[id]
+		if !ok {
+			return nil, 0, fmt.Errorf("Modifier %s is not available for %s", id, productName)
+		}
+		if seen[id] {
+			return nil, 0, fmt.Errorf("%s is chosen twice for %s", opt.modifier.Name, productName)
+		}
+		seen[id] = true
+		counts[opt.group.ID]++
+
+		modifierID := opt.modifier.ID
+		picked = append(picked, models.TransactionItemModifier{
+			ModifierID: &modifierID,
+			GroupName:  opt.group.Name,
+			Name:       opt.modifier.Name,
+			Price:      opt.modifier.Price,
+		})
+		amount += opt.modifier.Price
+	}
+
+	for _, g := range groups {
+		n := counts[g.ID]
+		if n < g.MinSelect {
+			return nil, 0, fmt.Errorf("Choose at least %d from %s for %s", g.MinSelect, g.Name, productName)
+		}
+		if g.MaxSelect > 0 && n > g.MaxSelect {
+			return nil, 0, fmt.Errorf("Choose at most %d from %s for %s", g.MaxSelect, g.Name, productName)
+		}
+	}
+	return picked, amount, nil
+}
+
+// insertItemModifiers writes the modifiers chosen for a transaction item
+func insertItemModifiers(tx *sql.Tx, itemID uuid.UUID, modifiers []models.TransactionItemModifier) ([]models.TransactionItemModifier, error) {
+	var result []models.TransactionItemModifier
+	for _, m := range modifiers {
+		m.TransactionItemID = itemID
+		if err := tx.QueryRow(`
+			INSERT INTO transaction_item_modifiers (transaction_item_id, modifier_id, group_name, name, price)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`, itemID, m.ModifierID, m.GroupName, m.Name, m.Price).Scan(&m.ID); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// loadItemModifiers returns the chosen modifiers of a transaction's items,
+// keyed by transaction item
+func loadItemModifiers(q queryer, transactionID uuid.UUID) map[uuid.UUID][]models.TransactionItemModifier {
+	modifiers := map[uuid.UUID][]models.TransactionItemModifier{}
+	rows, err := q.Query(`
+		SELECT m.id, m.transaction_item_id, m.modifier_id, m.group_name, m.name, m.price
+		FROM transaction_item_modifiers m
+		JOIN transaction_items ti ON m.transaction_item_id = ti.id
+		WHERE ti.transaction_id = $1
+		ORDER BY m.created_at ASC
+	`, transactionID)
+	if err != nil {
+		return modifiers
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m models.TransactionItemModifier
+		rows.Scan(&m.ID, &m.TransactionItemID, &m.ModifierID, &m.GroupName, &m.Name, &m.Price)
+		modifiers[m.TransactionItemID] = append(modifiers[m.TransactionItemID], m)
+	}
+	return modifiers
+}
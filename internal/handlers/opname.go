@@ -0,0 +1,628 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"kasirku/internal/database"
+	"kasirku/internal/middleware"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const stockOpnameColumns = `
+	o.id, o.store_id, o.category_id, o.status, o.notes, o.created_by, o.closed_by, o.closed_at, o.created_at,
+	(SELECT COUNT(*) FROM stock_opname_items WHERE opname_id = o.id),
+	(SELECT COUNT(*) FROM stock_opname_items WHERE opname_id = o.id AND counted_quantity IS NOT NULL)`
+
+// queryStockOpnames returns the stocktakes matching where, newest first
+func queryStockOpnames(q queryer, where string, args ...interface{}) ([]models.StockOpname, error) {
+	rows, err := q.Query(`
+		SELECT `+stockOpnameColumns+`
+		FROM stock_opnames o
+		WHERE `+where+`
+		ORDER BY o.created_at DESC
+		LIMIT 100
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	opnames := []models.StockOpname{}
+	for rows.Next() {
+		var o models.StockOpname
+		if err := rows.Scan(
+			&o.ID, &o.StoreID, &o.CategoryID, &o.Status, &o.Notes, &o.CreatedBy, &o.ClosedBy,
+			&o.ClosedAt, &o.CreatedAt, &o.ItemCount, &o.CountedCount,
+		); err != nil {
+			return nil, err
+		}
+		opnames = append(opnames, o)
+	}
+	return opnames, nil
+}
+
+// getStockOpname returns one stocktake of the store, or nil if there is none
+func getStockOpname(q queryer, storeID, opnameID uuid.UUID) (*models.StockOpname, error) {
+	opnames, err := queryStockOpnames(q, `o.id = $1 AND o.store_id = $2`, opnameID, storeID)
+	if err != nil || len(opnames) == 0 {
+		return nil, err
+	}
+	return &opnames[0], nil
+}
+
+// loadStockOpnameItems returns the products of a stocktake with their
+// variance, valued at today's cost
+func loadStockOpnameItems(q queryer, opnameID uuid.UUID) ([]models.StockOpnameItem, error) {
+	rows, err := q.Query(`
+		SELECT i.id, i.opname_id, i.product_id, i.product_name, i.system_stock, i.counted_quantity,
+		       i.counted_by, i.counted_at, p.barcode, p.unit, p.cost
+		FROM stock_opname_items i
+		JOIN products p ON p.id = i.product_id
+		WHERE i.opname_id = $1
+		ORDER BY i.product_name ASC
+	`, opnameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.StockOpnameItem{}
+	for rows.Next() {
+		var item models.StockOpnameItem
+		if err := rows.Scan(
+			&item.ID, &item.OpnameID, &item.ProductID, &item.ProductName, &item.SystemStock,
+			&item.CountedQuantity, &item.CountedBy, &item.CountedAt, &item.Barcode, &item.Unit, &item.Cost,
+		); err != nil {
+			return nil, err
+		}
+		if item.CountedQuantity != nil {
+			variance := *item.CountedQuantity - item.SystemStock
+			value := item.Cost.MulQuantity(variance)
+			item.Variance = &variance
+			item.VarianceValue = &value
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// opnameProduct finds the product a count is for, and the unit its quantity
+// is in. A scanned barcode can be a product's or one of its units'.
+func opnameProduct(q queryer, storeID uuid.UUID, count models.StockOpnameCountItem) (uuid.UUID, *models.ProductUnit, error) {
+	if count.ProductID != nil {
+		unit, err := saleUnit(q, *count.ProductID, count.UnitID)
+		return *count.ProductID, unit, err
+	}
+	if count.Barcode == nil || *count.Barcode == "" {
+		return uuid.Nil, nil, fmt.Errorf("Give a product_id or a barcode for each count")
+	}
+
+	units, err := queryProductUnits(q, `
+		barcode = $1 AND is_active = true
+		AND product_id IN (SELECT id FROM products WHERE store_id = $2 AND is_active = true)
+	`, *count.Barcode, storeID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if len(units) > 0 {
+		return units[0].ProductID, &units[0], nil
+	}
+
+	var productID uuid.UUID
+	err = q.QueryRow(`
+		SELECT id FROM products WHERE barcode = $1 AND store_id = $2 AND is_active = true
+	`, *count.Barcode, storeID).Scan(&productID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, nil, fmt.Errorf("No product with barcode %s", *count.Barcode)
+	}
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	unit, err := saleUnit(q, productID, count.UnitID)
+	return productID, unit, err
+}
+
+// ListStockOpnames returns the stocktakes of a store
+func ListStockOpnames(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	where := `o.store_id = $1`
+	args := []interface{}{storeID}
+	if status := c.Query("status"); status != "" {
+		where += ` AND o.status = $2`
+		args = append(args, status)
+	}
+
+	opnames, err := queryStockOpnames(database.DB, where, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opnames",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    opnames,
+	})
+}
+
+// OpenStockOpname starts a stocktake, snapshotting the stock of every product
+// that holds its own stock. Variant parents, bundles and composite products
+// take theirs from other products, so only those are counted.
+func OpenStockOpname(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	var req models.OpenStockOpnameRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var opnameID uuid.UUID
+	err = tx.QueryRow(`
+		INSERT INTO stock_opnames (store_id, category_id, notes, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, storeID, req.CategoryID, req.Notes, userID).Scan(&opnameID)
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success": false,
+			"error":   "A stock opname is already open",
+		})
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO stock_opname_items (opname_id, product_id, product_name, system_stock)
+		SELECT $1, p.id, p.name, p.stock
+		FROM products p
+		WHERE p.store_id = $2 AND p.is_active = true AND p.track_stock = true
+		  AND p.variant_options IS NULL
+		  AND NOT EXISTS(SELECT 1 FROM recipe_items WHERE product_id = p.id)
+		  AND NOT EXISTS(SELECT 1 FROM bundle_items WHERE bundle_id = p.id)
+		  AND ($3::uuid IS NULL OR p.category_id = $3)
+	`, opnameID, storeID, req.CategoryID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to snapshot stock",
+		})
+	}
+	if snapshotted, _ := result.RowsAffected(); snapshotted == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "No stocked products to count",
+		})
+	}
+
+	opname, err := getStockOpname(tx, storeID, opnameID)
+	if err != nil || opname == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to open stock opname",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    opname,
+	})
+}
+
+// GetStockOpname returns a stocktake with its products and counts
+func GetStockOpname(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	opnameUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid stock opname ID",
+		})
+	}
+
+	opname, err := getStockOpname(database.DB, storeID, opnameUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname",
+		})
+	}
+	if opname == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock opname not found",
+		})
+	}
+
+	if opname.Items, err = loadStockOpnameItems(database.DB, opnameUUID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname items",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    opname,
+	})
+}
+
+// CountStockOpname records counted quantities, by product or scanned barcode
+func CountStockOpname(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	opnameUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid stock opname ID",
+		})
+	}
+
+	var req models.StockOpnameCountRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	// A shared lock lets staff count side by side while keeping the session
+	// from being finalized under them
+	var status string
+	err = tx.QueryRow(`
+		SELECT status FROM stock_opnames WHERE id = $1 AND store_id = $2
+		FOR SHARE
+	`, opnameUUID, storeID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock opname not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname",
+		})
+	}
+	if status != "open" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock opname is " + status,
+		})
+	}
+
+	var counted []models.StockOpnameItem
+	for _, count := range req.Items {
+		productID, unit, err := opnameProduct(tx, storeID, count)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		var itemID uuid.UUID
+		var productName string
+		var decimalQuantity bool
+		err = tx.QueryRow(`
+			SELECT i.id, i.product_name, p.decimal_quantity
+			FROM stock_opname_items i
+			JOIN products p ON p.id = i.product_id
+			WHERE i.opname_id = $1 AND i.product_id = $2
+		`, opnameUUID, productID).Scan(&itemID, &productName, &decimalQuantity)
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   fmt.Sprintf("Product %s is not part of this stock opname", productID),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to fetch stock opname item",
+			})
+		}
+		if err := checkQuantity(productName, decimalQuantity, count.Quantity); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		item := models.StockOpnameItem{ID: itemID, OpnameID: opnameUUID, ProductID: productID, ProductName: productName}
+		err = tx.QueryRow(`
+			UPDATE stock_opname_items SET
+				counted_quantity = CASE WHEN $3::boolean THEN COALESCE(counted_quantity, 0) + $2::numeric ELSE $2::numeric END,
+				counted_by = $4,
+				counted_at = NOW()
+			WHERE id = $1
+			RETURNING system_stock, counted_quantity, counted_by, counted_at
+		`, itemID, baseQuantity(count.Quantity, unit), count.Add, userID).Scan(
+			&item.SystemStock, &item.CountedQuantity, &item.CountedBy, &item.CountedAt,
+		)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to record count",
+			})
+		}
+		variance := *item.CountedQuantity - item.SystemStock
+		item.Variance = &variance
+		counted = append(counted, item)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to record counts",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    counted,
+	})
+}
+
+// GetStockOpnameVariance reports the counted differences of a stocktake and
+// what they are worth at cost, with the products still to be counted
+func GetStockOpnameVariance(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	opnameUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid stock opname ID",
+		})
+	}
+
+	opname, err := getStockOpname(database.DB, storeID, opnameUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname",
+		})
+	}
+	if opname == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock opname not found",
+		})
+	}
+
+	items, err := loadStockOpnameItems(database.DB, opnameUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname items",
+		})
+	}
+
+	report := models.StockOpnameVarianceReport{
+		Opname:     *opname,
+		TotalItems: len(items),
+		Items:      []models.StockOpnameItem{},
+		Uncounted:  []models.StockOpnameItem{},
+	}
+	for _, item := range items {
+		if item.CountedQuantity == nil {
+			report.Uncounted = append(report.Uncounted, item)
+			continue
+		}
+		report.CountedItems++
+		if *item.Variance == 0 {
+			continue
+		}
+		report.ItemsWithVariance++
+		if *item.VarianceValue < 0 {
+			report.ShortageValue -= *item.VarianceValue
+		} else {
+			report.SurplusValue += *item.VarianceValue
+		}
+		report.Items = append(report.Items, item)
+	}
+	report.NetValue = report.SurplusValue - report.ShortageValue
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// FinalizeStockOpname posts an adjustment movement for every counted
+// difference and closes the stocktake, all in one transaction. The
+// difference is applied to the stock as it is now, so sales made during the
+// count are not undone. Products that weren't counted are left alone.
+func FinalizeStockOpname(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	opnameUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid stock opname ID",
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(`
+		SELECT status FROM stock_opnames WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, opnameUUID, storeID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock opname not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname",
+		})
+	}
+	if status != "open" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock opname is " + status,
+		})
+	}
+
+	items, err := loadStockOpnameItems(tx, opnameUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname items",
+		})
+	}
+	var productIDs []uuid.UUID
+	for _, item := range items {
+		if item.Variance != nil && *item.Variance != 0 {
+			productIDs = append(productIDs, item.ProductID)
+		}
+	}
+	if err := lockProducts(tx, storeID, productIDs); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to lock products",
+		})
+	}
+
+	adjusted := 0
+	for _, item := range items {
+		if item.Variance == nil || *item.Variance == 0 {
+			continue
+		}
+		notes := fmt.Sprintf("Stock opname: counted %s, expected %s", *item.CountedQuantity, item.SystemStock)
+		if err := restockProduct(tx, storeID, item.ProductID, *item.Variance, nil, "adjustment", opnameUUID, "stock_opname", notes, userID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to adjust stock of " + item.ProductName,
+			})
+		}
+		adjusted++
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE stock_opnames SET status = 'finalized', closed_by = $2, closed_at = NOW()
+		WHERE id = $1
+	`, opnameUUID, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to finalize stock opname",
+		})
+	}
+
+	opname, err := getStockOpname(tx, storeID, opnameUUID)
+	if err != nil || opname == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch stock opname",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to complete operation",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    opname,
+		"message": fmt.Sprintf("Stock opname finalized, %d products adjusted", adjusted),
+	})
+}
+
+// CancelStockOpname abandons an open stocktake without touching stock
+func CancelStockOpname(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	opnameUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid stock opname ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE stock_opnames SET status = 'cancelled', closed_by = $3, closed_at = NOW()
+		WHERE id = $1 AND store_id = $2 AND status = 'open'
+	`, opnameUUID, storeID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to cancel stock opname",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "No open stock opname found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Stock opname cancelled",
+	})
+}
@@ -1,18 +1,24 @@
 package handlers
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
+	"time"
 
 	"kasirku/internal/database"
 	"kasirku/internal/models"
+	"kasirku/internal/services"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
 
-// ListProducts returns all products for a store
+// ListProducts returns the products of a store with the variants of parent
+// products nested under them. Searching also matches variant barcodes and SKUs.
 func ListProducts(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 
@@ -35,12 +41,13 @@ func ListProducts(c *fiber.Ctx) error {
 	query := `
 		SELECT p.id, p.store_id, p.category_id, p.name, p.barcode, p.sku, p.description,
 		       p.price, p.cost, p.stock, p.min_stock, p.unit, p.image_url, p.is_active,
-		       p.track_stock, p.created_at, p.updated_at, c.name as category_name
+		       p.track_stock, p.decimal_quantity, p.parent_id, p.variant_options, p.option_values,
+		       p.created_at, p.updated_at, c.name as category_name
 		FROM products p
 		LEFT JOIN categories c ON p.category_id = c.id
-		WHERE p.store_id = $1
+		WHERE p.store_id = $1 AND p.parent_id IS NULL
 	`
-	countQuery := `SELECT COUNT(*) FROM products WHERE store_id = $1`
+	countQuery := `SELECT COUNT(*) FROM products WHERE store_id = $1 AND parent_id IS NULL`
 	args := []interface{}{storeID}
 	argCount := 1
 
@@ -54,8 +61,11 @@ func ListProducts(c *fiber.Ctx) error {
 	if search != "" {
 		argCount++
 		searchArg := "%" + search + "%"
-		query += fmt.Sprintf(" AND (p.name ILIKE $%d OR p.barcode ILIKE $%d OR p.sku ILIKE $%d)", argCount, argCount, argCount)
-		countQuery += fmt.Sprintf(" AND (name ILIKE $%d OR barcode ILIKE $%d OR sku ILIKE $%d)", argCount, argCount, argCount)
+		// A scanned variant barcode or SKU finds its parent
+		query += fmt.Sprintf(` AND (p.name ILIKE $%[1]d OR p.barcode ILIKE $%[1]d OR p.sku ILIKE $%[1]d OR EXISTS (
+			SELECT 1 FROM products v WHERE v.parent_id = p.id AND (v.barcode ILIKE $%[1]d OR v.sku ILIKE $%[1]d)))`, argCount)
+		countQuery += fmt.Sprintf(` AND (name ILIKE $%[1]d OR barcode ILIKE $%[1]d OR sku ILIKE $%[1]d OR EXISTS (
+			SELECT 1 FROM products v WHERE v.parent_id = products.id AND (v.barcode ILIKE $%[1]d OR v.sku ILIKE $%[1]d)))`, argCount)
 		args = append(args, searchArg)
 	}
 
@@ -92,13 +102,28 @@ func ListProducts(c *fiber.Ctx) error {
 		err := rows.Scan(
 			&p.ID, &p.StoreID, &p.CategoryID, &p.Name, &p.Barcode, &p.SKU, &p.Description,
 			&p.Price, &p.Cost, &p.Stock, &p.MinStock, &p.Unit, &p.ImageURL, &p.IsActive,
-			&p.TrackStock, &p.CreatedAt, &p.UpdatedAt, &p.CategoryName,
+			&p.TrackStock, &p.DecimalQuantity, &p.ParentID, &p.VariantOptions, &p.OptionValues,
+			&p.CreatedAt, &p.UpdatedAt, &p.CategoryName,
 		)
 		if err != nil {
 			continue
 		}
 		products = append(products, p)
 	}
+	rows.Close()
+
+	if err := attachVariants(database.DB, products, activeOnly); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product variants",
+		})
+	}
+	if err := attachUnits(database.DB, products); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product units",
+		})
+	}
 
 	totalPages := (total + perPage - 1) / perPage
 
@@ -114,7 +139,7 @@ func ListProducts(c *fiber.Ctx) error {
 	})
 }
 
-// GetProduct returns a specific product
+// GetProduct returns a specific product, with its variants if it has any
 func GetProduct(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 	productID := c.Params("id")
@@ -131,14 +156,16 @@ func GetProduct(c *fiber.Ctx) error {
 	err = database.DB.QueryRow(`
 		SELECT p.id, p.store_id, p.category_id, p.name, p.barcode, p.sku, p.description,
 		       p.price, p.cost, p.stock, p.min_stock, p.unit, p.image_url, p.is_active,
-		       p.track_stock, p.created_at, p.updated_at, c.name as category_name
+		       p.track_stock, p.decimal_quantity, p.parent_id, p.variant_options, p.option_values,
+		       p.created_at, p.updated_at, c.name as category_name
 		FROM products p
 		LEFT JOIN categories c ON p.category_id = c.id
 		WHERE p.id = $1 AND p.store_id = $2
 	`, productUUID, storeID).Scan(
 		&p.ID, &p.StoreID, &p.CategoryID, &p.Name, &p.Barcode, &p.SKU, &p.Description,
 		&p.Price, &p.Cost, &p.Stock, &p.MinStock, &p.Unit, &p.ImageURL, &p.IsActive,
-		&p.TrackStock, &p.CreatedAt, &p.UpdatedAt, &p.CategoryName,
+		&p.TrackStock, &p.DecimalQuantity, &p.ParentID, &p.VariantOptions, &p.OptionValues,
+		&p.CreatedAt, &p.UpdatedAt, &p.CategoryName,
 	)
 	if err == sql.ErrNoRows {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -153,29 +180,86 @@ func GetProduct(c *fiber.Ctx) error {
 		})
 	}
 
+	products := []models.Product{p}
+	if err := attachVariants(database.DB, products, false); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product variants",
+		})
+	}
+	if err := attachUnits(database.DB, products); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product units",
+		})
+	}
+
 	return c.JSON(fiber.Map{
 		"success": true,
-		"data":    p,
+		"data":    products[0],
 	})
 }
 
-// GetProductByBarcode finds a product by barcode
+// GetProductByBarcode finds a product by barcode. Variants have barcodes of
+// their own, so scanning one returns the variant that is sold. A parent's
+// barcode returns the parent with its active variants to choose from. A
+// unit's barcode, such as the one on a dus, returns the product along with
+// the unit it is sold in. A label printed by a scale returns the product of
+// its PLU along with the weighed quantity and, for priced labels, the price.
 func GetProductByBarcode(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 	barcode := c.Params("code")
 
+	var unit *models.ProductUnit
+	units, err := queryProductUnits(database.DB, `
+		barcode = $1 AND is_active = true
+		AND product_id IN (SELECT id FROM products WHERE store_id = $2 AND is_active = true)
+	`, barcode, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product",
+		})
+	}
+	productWhere, lookup := `p.barcode = $1`, interface{}(barcode)
+	var scale *models.ScaleBarcode
+	if len(units) > 0 {
+		unit = &units[0]
+		productWhere, lookup = `p.id = $1`, unit.ProductID
+	} else {
+		// A product's own barcode wins over a scale label that looks the same
+		var exists bool
+		database.DB.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM products WHERE barcode = $1 AND store_id = $2 AND is_active = true)
+		`, barcode, storeID).Scan(&exists)
+		if !exists {
+			if scale, err = parseScaleBarcode(database.DB, storeID, barcode); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   err.Error(),
+				})
+			}
+		}
+		if scale != nil {
+			// Scales drop leading zeros of the SKU into the fixed-width PLU
+			productWhere, lookup = `LTRIM(p.sku, '0') = LTRIM($1, '0')`, scale.PLU
+		}
+	}
+
 	var p models.Product
-	err := database.DB.QueryRow(`
+	err = database.DB.QueryRow(`
 		SELECT p.id, p.store_id, p.category_id, p.name, p.barcode, p.sku, p.description,
 		       p.price, p.cost, p.stock, p.min_stock, p.unit, p.image_url, p.is_active,
-		       p.track_stock, p.created_at, p.updated_at, c.name as category_name
+		       p.track_stock, p.decimal_quantity, p.parent_id, p.variant_options, p.option_values,
+		       p.created_at, p.updated_at, c.name as category_name
 		FROM products p
 		LEFT JOIN categories c ON p.category_id = c.id
-		WHERE p.barcode = $1 AND p.store_id = $2 AND p.is_active = true
-	`, barcode, storeID).Scan(
+		WHERE `+productWhere+` AND p.store_id = $2 AND p.is_active = true
+	`, lookup, storeID).Scan(
 		&p.ID, &p.StoreID, &p.CategoryID, &p.Name, &p.Barcode, &p.SKU, &p.Description,
 		&p.Price, &p.Cost, &p.Stock, &p.MinStock, &p.Unit, &p.ImageURL, &p.IsActive,
-		&p.TrackStock, &p.CreatedAt, &p.UpdatedAt, &p.CategoryName,
+		&p.TrackStock, &p.DecimalQuantity, &p.ParentID, &p.VariantOptions, &p.OptionValues,
+		&p.CreatedAt, &p.UpdatedAt, &p.CategoryName,
 	)
 	if err == sql.ErrNoRows {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -190,13 +274,55 @@ func GetProductByBarcode(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(fiber.Map{
+	products := []models.Product{p}
+	if err := attachVariants(database.DB, products, true); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product variants",
+		})
+	}
+	if err := attachUnits(database.DB, products); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product units",
+		})
+	}
+
+	// A scale label is ready to add to the cart: the quantity is weighed or
+	// worked back from the printed price
+	if scale != nil {
+		if scale.Price != nil {
+			if p.Price <= 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   p.Name + " has no price to work out the weight from",
+				})
+			}
+			scale.Quantity = models.QuantityFor(*scale.Price, p.Price)
+		}
+		if err := checkQuantity(p.Name, p.DecimalQuantity, scale.Quantity); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	response := fiber.Map{
 		"success": true,
-		"data":    p,
-	})
+		"data":    products[0],
+	}
+	if unit != nil {
+		response["unit"] = unit
+	}
+	if scale != nil {
+		response["scale"] = scale
+	}
+	return c.JSON(response)
 }
 
-// CreateProduct creates a new product
+// CreateProduct creates a new product. A product created with variant
+// options is a parent: it holds no stock and is sold through its variants.
 func CreateProduct(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 
@@ -224,20 +350,41 @@ func CreateProduct(c *fiber.Ctx) error {
 	if req.TrackStock != nil {
 		trackStock = *req.TrackStock
 	}
+	stock := req.Stock
+	if len(req.VariantOptions) > 0 {
+		if err := checkVariantOptions(req.VariantOptions); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		trackStock = false
+		stock = 0
+	}
+	if err := checkQuantity(req.Name, req.DecimalQuantity, stock); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
 
 	var p models.Product
 	err := database.DB.QueryRow(`
 		INSERT INTO products (store_id, category_id, name, barcode, sku, description,
-		                      price, cost, stock, min_stock, unit, image_url, track_stock)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		                      price, cost, stock, min_stock, unit, image_url, track_stock, variant_options,
+		                      decimal_quantity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 		RETURNING id, store_id, category_id, name, barcode, sku, description,
 		          price, cost, stock, min_stock, unit, image_url, is_active,
-		          track_stock, created_at, updated_at
+		          track_stock, decimal_quantity, parent_id, variant_options, option_values,
+		          created_at, updated_at
 	`, storeID, req.CategoryID, req.Name, req.Barcode, req.SKU, req.Description,
-		req.Price, req.Cost, req.Stock, req.MinStock, unit, req.ImageURL, trackStock).Scan(
+		req.Price, req.Cost, stock, req.MinStock, unit, req.ImageURL, trackStock, req.VariantOptions,
+		req.DecimalQuantity).Scan(
 		&p.ID, &p.StoreID, &p.CategoryID, &p.Name, &p.Barcode, &p.SKU, &p.Description,
 		&p.Price, &p.Cost, &p.Stock, &p.MinStock, &p.Unit, &p.ImageURL, &p.IsActive,
-		&p.TrackStock, &p.CreatedAt, &p.UpdatedAt,
+		&p.TrackStock, &p.DecimalQuantity, &p.ParentID, &p.VariantOptions, &p.OptionValues,
+		&p.CreatedAt, &p.UpdatedAt,
 	)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -252,7 +399,9 @@ func CreateProduct(c *fiber.Ctx) error {
 	})
 }
 
-// UpdateProduct updates a product
+// UpdateProduct updates a product. A parent's category is copied to its
+// variants, and its variant options can only change in ways that keep every
+// active variant valid.
 func UpdateProduct(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 	productID := c.Params("id")
@@ -273,8 +422,81 @@ func UpdateProduct(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	if req.VariantOptions != nil {
+		if err := checkVariantOptions(*req.VariantOptions); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		var isVariant bool
+		tx.QueryRow(`
+			SELECT parent_id IS NOT NULL FROM products WHERE id = $1 AND store_id = $2
+		`, productUUID, storeID).Scan(&isVariant)
+		if isVariant && len(*req.VariantOptions) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "A variant can't have variants of its own",
+			})
+		}
+
+		variants, err := queryVariants(tx, `parent_id = $1 AND is_active = true`, productUUID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to fetch product variants",
+			})
+		}
+		for _, v := range variants {
+			if err := req.VariantOptions.Check(v.OptionValues); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   fmt.Sprintf("Variant %s no longer fits the options: %s", v.Name, err),
+				})
+			}
+		}
+	}
+
+	// Whole-unit products can't hold a fraction of stock or be sold in a
+	// unit holding one
+	if req.DecimalQuantity != nil && !*req.DecimalQuantity {
+		var name string
+		var stock models.Quantity
+		var fractionalUnit bool
+		err := tx.QueryRow(`
+			SELECT name, stock,
+			       EXISTS(SELECT 1 FROM product_units
+			              WHERE product_id = products.id AND is_active = true AND factor <> TRUNC(factor))
+			FROM products WHERE id = $1 AND store_id = $2
+			FOR UPDATE
+		`, productUUID, storeID).Scan(&name, &stock, &fractionalUnit)
+		if err == nil && (!stock.IsWhole() || fractionalUnit) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   fmt.Sprintf("%s has a fractional stock or unit, so it can't be limited to whole units", name),
+			})
+		}
+	}
+
 	var p models.Product
-	err = database.DB.QueryRow(`
+	err = tx.QueryRow(`
 		UPDATE products SET
 			name = COALESCE($3, name),
 			category_id = COALESCE($4, category_id),
@@ -287,18 +509,23 @@ func UpdateProduct(c *fiber.Ctx) error {
 			unit = COALESCE($11, unit),
 			image_url = COALESCE($12, image_url),
 			is_active = COALESCE($13, is_active),
-			track_stock = COALESCE($14, track_stock),
+			track_stock = CASE WHEN $16::jsonb IS NOT NULL THEN false ELSE COALESCE($14, track_stock) END,
+			variant_options = CASE WHEN $15 THEN $16::jsonb ELSE variant_options END,
+			decimal_quantity = COALESCE($17, decimal_quantity),
 			updated_at = NOW()
 		WHERE id = $1 AND store_id = $2
 		RETURNING id, store_id, category_id, name, barcode, sku, description,
 		          price, cost, stock, min_stock, unit, image_url, is_active,
-		          track_stock, created_at, updated_at
+		          track_stock, decimal_quantity, parent_id, variant_options, option_values,
+		          created_at, updated_at
 	`, productUUID, storeID, req.Name, req.CategoryID, req.Barcode, req.SKU,
 		req.Description, req.Price, req.Cost, req.MinStock, req.Unit,
-		req.ImageURL, req.IsActive, req.TrackStock).Scan(
+		req.ImageURL, req.IsActive, req.TrackStock, req.VariantOptions != nil, req.VariantOptions,
+		req.DecimalQuantity).Scan(
 		&p.ID, &p.StoreID, &p.CategoryID, &p.Name, &p.Barcode, &p.SKU, &p.Description,
 		&p.Price, &p.Cost, &p.Stock, &p.MinStock, &p.Unit, &p.ImageURL, &p.IsActive,
-		&p.TrackStock, &p.CreatedAt, &p.UpdatedAt,
+		&p.TrackStock, &p.DecimalQuantity, &p.ParentID, &p.VariantOptions, &p.OptionValues,
+		&p.CreatedAt, &p.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -313,13 +540,32 @@ func UpdateProduct(c *fiber.Ctx) error {
 		})
 	}
 
+	if req.CategoryID != nil {
+		if _, err := tx.Exec(`
+			UPDATE products SET category_id = $2, updated_at = NOW()
+			WHERE parent_id = $1
+		`, productUUID, req.CategoryID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to update product variants",
+			})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update product",
+		})
+	}
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    p,
 	})
 }
 
-// DeleteProduct soft-deletes a product
+// DeleteProduct soft-deletes a product along with its variants
 func DeleteProduct(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 	productID := c.Params("id")
@@ -334,7 +580,7 @@ func DeleteProduct(c *fiber.Ctx) error {
 
 	result, err := database.DB.Exec(`
 		UPDATE products SET is_active = false, updated_at = NOW()
-		WHERE id = $1 AND store_id = $2
+		WHERE (id = $1 OR parent_id = $1) AND store_id = $2
 	`, productUUID, storeID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -357,21 +603,148 @@ func DeleteProduct(c *fiber.Ctx) error {
 	})
 }
 
-// GenerateBarcode generates a unique barcode for a product
+// GenerateBarcode generates an EAN-13 barcode for a product that no product
+// or unit of the store uses yet
 func GenerateBarcode(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 
-	// Generate a unique barcode (store prefix + timestamp + random)
-	var count int
-	database.DB.QueryRow(`SELECT COUNT(*) FROM products WHERE store_id = $1`, storeID).Scan(&count)
+	// In-store codes use the 20-29 range, minus the prefixes of the store's
+	// scale labels. A scale prefix such as 215 rules out all of 21, since
+	// some of its codes would read as scale labels.
+	patterns, err := queryScaleBarcodePatterns(database.DB, `store_id = $1 AND is_active = true`, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch scale barcode patterns",
+		})
+	}
+	prefix := ""
+	for p := 29; p >= 20 && prefix == ""; p-- {
+		prefix = strconv.Itoa(p)
+		for _, pattern := range patterns {
+			if strings.HasPrefix(prefix, pattern.Prefix) || strings.HasPrefix(pattern.Prefix, prefix) {
+				prefix = ""
+				break
+			}
+		}
+	}
+	if prefix == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "All in-store barcode prefixes are used by scale barcodes",
+		})
+	}
 
-	// Generate EAN-13 like barcode
-	barcode := fmt.Sprintf("899%09d", count+1)
+	for attempt := 0; attempt < 10; attempt++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(10_000_000_000))
+		if err != nil {
+			break
+		}
+		digits := fmt.Sprintf("%s%010d", prefix, n.Int64())
+		barcode := digits + string(services.EAN13CheckDigit(digits))
+
+		var taken bool
+		database.DB.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM products WHERE store_id = $1 AND barcode = $2)
+			    OR EXISTS(
+			        SELECT 1 FROM product_units u JOIN products p ON p.id = u.product_id
+			        WHERE p.store_id = $1 AND u.barcode = $2
+			    )
+		`, storeID, barcode).Scan(&taken)
+		if !taken {
+			return c.JSON(fiber.Map{
+				"success": true,
+				"data": fiber.Map{
+					"barcode": barcode,
+				},
+			})
+		}
+	}
 
-	return c.JSON(fiber.Map{
-		"success": true,
-		"data": fiber.Map{
-			"barcode": barcode,
-		},
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"success": false,
+		"error":   "Failed to generate a unique barcode",
 	})
 }
+
+// PrintProductLabels renders a PDF of barcode labels with the name and price
+// of each product, or of a unit of it, ready for sticker paper or a thermal
+// label printer
+func PrintProductLabels(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	var req models.PrintLabelsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	layout := req.Layout
+	if layout == "" {
+		layout = "a4"
+	}
+
+	var labels []services.Label
+	for _, item := range req.Items {
+		var label services.Label
+		var barcode *string
+		err := database.DB.QueryRow(`
+			SELECT name, barcode, price FROM products
+			WHERE id = $1 AND store_id = $2 AND is_active = true
+		`, item.ProductID, storeID).Scan(&label.Name, &barcode, &label.Price)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success": false,
+				"error":   fmt.Sprintf("Product not found: %s", item.ProductID),
+			})
+		}
+		if item.UnitID != nil {
+			unit, err := saleUnit(database.DB, item.ProductID, item.UnitID)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   err.Error(),
+				})
+			}
+			label.Name += " (" + unit.Name + ")"
+			label.Price = unit.Price
+			barcode = unit.Barcode
+		}
+		if barcode == nil || *barcode == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   label.Name + " has no barcode",
+			})
+		}
+		label.Barcode = *barcode
+
+		copies := item.Copies
+		if copies == 0 {
+			copies = 1
+		}
+		for i := 0; i < copies; i++ {
+			labels = append(labels, label)
+		}
+	}
+
+	pdf, err := services.RenderLabelsPDF(labels, services.LabelLayouts[layout])
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to render labels: " + err.Error(),
+		})
+	}
+
+	c.Set("Content-Type", "application/pdf")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=labels_%s.pdf", time.Now().Format("20060102_150405")))
+	return c.Send(pdf)
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const promoColumns = `id, store_id, name, code, type, value, product_id, buy_quantity, get_quantity,
+	min_purchase, max_discount, start_date, end_date, usage_limit, usage_count,
+	is_active, created_at, updated_at`
+
+func scanPromo(row interface{ Scan(...interface{}) error }, p *models.Promo) error {
+	return row.Scan(
+		&p.ID, &p.StoreID, &p.Name, &p.Code, &p.Type, &p.Value, &p.ProductID,
+		&p.BuyQuantity, &p.GetQuantity, &p.MinPurchase, &p.MaxDiscount,
+		&p.StartDate, &p.EndDate, &p.UsageLimit, &p.UsageCount,
+		&p.IsActive, &p.CreatedAt, &p.UpdatedAt,
+	)
+}
+
+// ListPromos returns the promos of a store
+func ListPromos(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	activeOnly := c.QueryBool("active", false)
+
+	query := `SELECT ` + promoColumns + ` FROM promos WHERE store_id = $1`
+	if activeOnly {
+		query += ` AND is_active = true
+			AND (start_date IS NULL OR start_date <= NOW())
+			AND (end_date IS NULL OR end_date >= NOW())
+			AND (usage_limit IS NULL OR usage_count < usage_limit)`
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := database.DB.Query(query, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch promos",
+		})
+	}
+	defer rows.Close()
+
+	var promos []models.Promo
+	for rows.Next() {
+		var p models.Promo
+		scanPromo(rows, &p)
+		promos = append(promos, p)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    promos,
+	})
+}
+
+// GetPromo returns a specific promo
+func GetPromo(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	promoUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid promo ID",
+		})
+	}
+
+	var p models.Promo
+	err = scanPromo(database.DB.QueryRow(`
+		SELECT `+promoColumns+` FROM promos WHERE id = $1 AND store_id = $2
+	`, promoUUID, storeID), &p)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Promo not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch promo",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    p,
+	})
+}
+
+// CreatePromo creates a new promo
+func CreatePromo(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	var req models.CreatePromoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	if req.Type == "buy_x_get_y" && (req.BuyQuantity < 1 || req.GetQuantity < 1) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "buy_quantity and get_quantity are required for buy_x_get_y promos",
+		})
+	}
+	if req.Type == "percentage" && req.Value > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Percentage cannot be more than 100",
+		})
+	}
+
+	var p models.Promo
+	err := scanPromo(database.DB.QueryRow(`
+		INSERT INTO promos (
+			store_id, name, code, type, value, product_id, buy_quantity, get_quantity,
+			min_purchase, max_discount, start_date, end_date, usage_limit
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING `+promoColumns,
+		storeID, req.Name, normalizePromoCode(req.Code), req.Type, req.Value, req.ProductID,
+		req.BuyQuantity, req.GetQuantity, req.MinPurchase, req.MaxDiscount,
+		req.StartDate, req.EndDate, req.UsageLimit,
+	), &p)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create promo: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    p,
+	})
+}
+
+// UpdatePromo updates a promo
+func UpdatePromo(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	promoUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid promo ID",
+		})
+	}
+
+	var req models.UpdatePromoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	var p models.Promo
+	err = scanPromo(database.DB.QueryRow(`
+		UPDATE promos SET
+			name = COALESCE($3, name),
+			code = COALESCE($4, code),
+			value = COALESCE($5, value),
+			product_id = COALESCE($6, product_id),
+			buy_quantity = COALESCE($7, buy_quantity),
+			get_quantity = COALESCE($8, get_quantity),
+			min_purchase = COALESCE($9, min_purchase),
+			max_discount = COALESCE($10, max_discount),
+			start_date = COALESCE($11, start_date),
+			end_date = COALESCE($12, end_date),
+			usage_limit = COALESCE($13, usage_limit),
+			is_active = COALESCE($14, is_active),
+			updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+		RETURNING `+promoColumns,
+		promoUUID, storeID, req.Name, normalizePromoCode(req.Code), req.Value, req.ProductID,
+		req.BuyQuantity, req.GetQuantity, req.MinPurchase, req.MaxDiscount,
+		req.StartDate, req.EndDate, req.UsageLimit, req.IsActive,
+	), &p)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Promo not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update promo: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    p,
+	})
+}
+
+// DeletePromo deactivates a promo. Promos are kept so past transactions
+// still show which promo they used.
+func DeletePromo(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	promoUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid promo ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE promos SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+	`, promoUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete promo",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Promo not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Promo deleted successfully",
+	})
+}
+
+// normalizePromoCode stores codes trimmed and upper-cased so lookups are
+// case-insensitive
+func normalizePromoCode(code *string) *string {
+	if code == nil {
+		return nil
+	}
+	normalized := strings.ToUpper(strings.TrimSpace(*code))
+	return &normalized
+}
+
+// applyPromo validates a promo code against a priced sale and adds the promo
+// discount to it. Usage is only counted later by claimPromo.
+func applyPromo(tx *sql.Tx, storeID uuid.UUID, code string, s *sale) error {
+	var p models.Promo
+	err := scanPromo(tx.QueryRow(`
+		SELECT `+promoColumns+` FROM promos
+		WHERE store_id = $1 AND UPPER(code) = $2
+	`, storeID, *normalizePromoCode(&code)), &p)
+	if err != nil {
+		return fmt.Errorf("Promo code not found: %s", code)
+	}
+
+	now := time.Now()
+	if !p.IsActive {
+		return fmt.Errorf("Promo %s is not active", code)
+	}
+	if p.StartDate != nil && now.Before(*p.StartDate) {
+		return fmt.Errorf("Promo %s has not started yet", code)
+	}
+	if p.EndDate != nil && now.After(*p.EndDate) {
+		return fmt.Errorf("Promo %s has expired", code)
+	}
+	if p.UsageLimit != nil && p.UsageCount >= *p.UsageLimit {
+		return fmt.Errorf("Promo %s has reached its usage limit", code)
+	}
+	if s.Subtotal < p.MinPurchase {
+		return fmt.Errorf("Minimum purchase for promo %s is %.0f", code, p.MinPurchase.Float64())
+	}
+
+	var discount models.Money
+	switch p.Type {
+	case "percentage":
+		discount = s.Subtotal.Percent(p.Value)
+	case "fixed":
+		discount = models.MoneyFromFloat(p.Value)
+	case "buy_x_get_y":
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+			return fmt.Errorf("Promo %s is not configured correctly", code)
+		}
+		for _, line := range s.Lines {
+			if p.ProductID != nil && line.ProductID != *p.ProductID {
+				continue
+			}
+			// Only whole sets earn free units, so 2.5 kg on a buy 1 get 1
+			// gets 1 kg free
+			sets := line.Quantity / models.NewQuantity(int64(p.BuyQuantity+p.GetQuantity))
+			free := models.NewQuantity(int64(p.GetQuantity)) * sets
+			discount += line.ProductPrice.MulQuantity(free)
+		}
+		if discount == 0 {
+			return fmt.Errorf("Cart does not qualify for promo %s", code)
+		}
+	}
+
+	if p.MaxDiscount != nil && *p.MaxDiscount > 0 && discount > *p.MaxDiscount {
+		discount = *p.MaxDiscount
+	}
+	// Never discount below zero
+	if remaining := s.Subtotal - s.Discount; discount > remaining {
+		discount = remaining
+	}
+
+	s.PromoID = &p.ID
+	s.PromoDiscount = discount
+	s.Discount += s.PromoDiscount
+	return nil
+}
+
+// claimPromo counts one use of a promo. The conditional update keeps
+// concurrent sales from going past the usage limit.
+func claimPromo(tx *sql.Tx, promoID uuid.UUID) error {
+	result, err := tx.Exec(`
+		UPDATE promos SET usage_count = usage_count + 1, updated_at = NOW()
+		WHERE id = $1 AND (usage_limit IS NULL OR usage_count < usage_limit)
+	`, promoID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("Promo has reached its usage limit")
+	}
+	return nil
+}
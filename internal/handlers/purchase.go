@@ -0,0 +1,767 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"kasirku/internal/database"
+	"kasirku/internal/middleware"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const purchaseOrderColumns = `
+	po.id, po.store_id, po.supplier_id, po.po_number, po.status, po.expected_at, po.notes, po.total,
+	po.created_by, po.sent_at, po.received_at, po.created_at, po.updated_at, s.name`
+
+// queryPurchaseOrders returns the purchase orders matching where, newest
+// first
+func queryPurchaseOrders(q queryer, where string, args ...interface{}) ([]models.PurchaseOrder, error) {
+	rows, err := q.Query(`
+		SELECT `+purchaseOrderColumns+`
+		FROM purchase_orders po
+		JOIN suppliers s ON s.id = po.supplier_id
+		WHERE `+where+`
+		ORDER BY po.created_at DESC
+		LIMIT 100
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []models.PurchaseOrder{}
+	for rows.Next() {
+		var po models.PurchaseOrder
+		if err := rows.Scan(
+			&po.ID, &po.StoreID, &po.SupplierID, &po.PONumber, &po.Status, &po.ExpectedAt, &po.Notes, &po.Total,
+			&po.CreatedBy, &po.SentAt, &po.ReceivedAt, &po.CreatedAt, &po.UpdatedAt, &po.SupplierName,
+		); err != nil {
+			return nil, err
+		}
+		orders = append(orders, po)
+	}
+	return orders, rows.Err()
+}
+
+// getPurchaseOrder returns one purchase order of the store with its lines,
+// or nil if there is none
+func getPurchaseOrder(q queryer, storeID, orderID uuid.UUID) (*models.PurchaseOrder, error) {
+	orders, err := queryPurchaseOrders(q, `po.id = $1 AND po.store_id = $2`, orderID, storeID)
+	if err != nil || len(orders) == 0 {
+		return nil, err
+	}
+	po := &orders[0]
+	if po.Items, err = loadPurchaseOrderItems(q, orderID); err != nil {
+		return nil, err
+	}
+	return po, nil
+}
+
+// loadPurchaseOrderItems returns the lines of a purchase order with what is
+// still outstanding on each
+func loadPurchaseOrderItems(q queryer, orderID uuid.UUID) ([]models.PurchaseOrderItem, error) {
+	rows, err := q.Query(`
+		SELECT id, purchase_order_id, product_id, unit_id, product_name, unit_name, unit_factor,
+		       quantity, received_quantity, unit_cost
+		FROM purchase_order_items
+		WHERE purchase_order_id = $1
+		ORDER BY product_name ASC, id ASC
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.PurchaseOrderItem{}
+	for rows.Next() {
+		var item models.PurchaseOrderItem
+		if err := rows.Scan(
+			&item.ID, &item.PurchaseOrderID, &item.ProductID, &item.UnitID, &item.ProductName, &item.UnitName,
+			&item.UnitFactor, &item.Quantity, &item.ReceivedQuantity, &item.UnitCost,
+		); err != nil {
+			return nil, err
+		}
+		item.Subtotal = item.UnitCost.MulQuantity(item.Quantity)
+		item.Outstanding = item.Quantity - item.ReceivedQuantity
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// checkSupplier rejects a supplier that isn't an active one of the store
+func checkSupplier(q queryer, storeID, supplierID uuid.UUID) error {
+	var exists bool
+	if err := q.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM suppliers WHERE id = $1 AND store_id = $2 AND is_active = true)
+	`, supplierID, storeID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Supplier not found")
+	}
+	return nil
+}
+
+// purchaseOrderLines turns requested lines into order items, snapshotting
+// the product name and the unit, and returns them with the order total.
+// Only products that hold their own stock can be ordered.
+func purchaseOrderLines(q queryer, storeID uuid.UUID, reqs []models.PurchaseOrderItemRequest) ([]models.PurchaseOrderItem, models.Money, error) {
+	var items []models.PurchaseOrderItem
+	var total models.Money
+	for _, req := range reqs {
+		var name, baseUnit string
+		var hasVariants, hasRecipe, isBundle, decimalQuantity bool
+		err := q.QueryRow(`
+			SELECT name, unit, variant_options IS NOT NULL,
+			       EXISTS(SELECT 1 FROM recipe_items WHERE product_id = products.id),
+			       EXISTS(SELECT 1 FROM bundle_items WHERE bundle_id = products.id),
+			       decimal_quantity
+			FROM products
+			WHERE id = $1 AND store_id = $2 AND is_active = true
+		`, req.ProductID, storeID).Scan(&name, &baseUnit, &hasVariants, &hasRecipe, &isBundle, &decimalQuantity)
+		if err == sql.ErrNoRows {
+			return nil, 0, fmt.Errorf("Product not found: %s", req.ProductID)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		switch {
+		case hasVariants:
+			return nil, 0, fmt.Errorf("Stock of %s is kept per variant", name)
+		case hasRecipe:
+			return nil, 0, fmt.Errorf("Stock of %s comes from its recipe ingredients", name)
+		case isBundle:
+			return nil, 0, fmt.Errorf("Stock of %s comes from its bundle items", name)
+		}
+
+		unit, err := saleUnit(q, req.ProductID, req.UnitID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := checkQuantity(name, decimalQuantity, req.Quantity); err != nil {
+			return nil, 0, err
+		}
+
+		item := models.PurchaseOrderItem{
+			ProductID:   req.ProductID,
+			UnitID:      req.UnitID,
+			ProductName: name,
+			UnitName:    baseUnit,
+			UnitFactor:  models.NewQuantity(1),
+			Quantity:    req.Quantity,
+			UnitCost:    req.UnitCost,
+			Subtotal:    req.UnitCost.MulQuantity(req.Quantity),
+			Outstanding: req.Quantity,
+		}
+		if unit != nil {
+			item.UnitName = unit.Name
+			item.UnitFactor = unit.Factor
+		}
+		items = append(items, item)
+		total += item.Subtotal
+	}
+	return items, total, nil
+}
+
+// insertPurchaseOrderItems saves the lines of a purchase order
+func insertPurchaseOrderItems(tx *sql.Tx, orderID uuid.UUID, items []models.PurchaseOrderItem) error {
+	for _, item := range items {
+		if _, err := tx.Exec(`
+			INSERT INTO purchase_order_items (purchase_order_id, product_id, unit_id, product_name, unit_name,
+			                                  unit_factor, quantity, unit_cost)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, orderID, item.ProductID, item.UnitID, item.ProductName, item.UnitName,
+			item.UnitFactor, item.Quantity, item.UnitCost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextPurchaseOrderNumber takes the next number of the month's purchase
+// order sequence, e.g. PO-202401-0007
+func nextPurchaseOrderNumber(tx *sql.Tx, storeID uuid.UUID) (string, error) {
+	var month string
+	var seq int
+	err := tx.QueryRow(`
+		INSERT INTO invoice_sequences (store_id, period, last_number)
+		VALUES ($1, 'PO' || TO_CHAR(CURRENT_DATE, 'YYYYMM'), 1)
+		ON CONFLICT (store_id, period)
+		DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING SUBSTRING(period FROM 3), last_number
+	`, storeID).Scan(&month, &seq)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("PO-%s-%04d", month, seq), nil
+}
+
+// ListPurchaseOrders returns the purchase orders of a store
+func ListPurchaseOrders(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	where := `po.store_id = $1`
+	args := []interface{}{storeID}
+	if status := c.Query("status"); status != "" {
+		args = append(args, status)
+		where += fmt.Sprintf(` AND po.status = $%d`, len(args))
+	}
+	if supplierID, err := uuid.Parse(c.Query("supplier_id")); err == nil {
+		args = append(args, supplierID)
+		where += fmt.Sprintf(` AND po.supplier_id = $%d`, len(args))
+	}
+
+	orders, err := queryPurchaseOrders(database.DB, where, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase orders",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    orders,
+	})
+}
+
+// GetPurchaseOrder returns a purchase order with its lines
+func GetPurchaseOrder(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	orderUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid purchase order ID",
+		})
+	}
+
+	po, err := getPurchaseOrder(database.DB, storeID, orderUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase order",
+		})
+	}
+	if po == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Purchase order not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    po,
+	})
+}
+
+// CreatePurchaseOrder drafts a purchase order
+func CreatePurchaseOrder(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	var req models.CreatePurchaseOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	if err := checkSupplier(tx, storeID, req.SupplierID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	items, total, err := purchaseOrderLines(tx, storeID, req.Items)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	poNumber, err := nextPurchaseOrderNumber(tx, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to generate purchase order number",
+		})
+	}
+
+	var orderID uuid.UUID
+	err = tx.QueryRow(`
+		INSERT INTO purchase_orders (store_id, supplier_id, po_number, expected_at, notes, total, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, storeID, req.SupplierID, poNumber, req.ExpectedAt, req.Notes, total, userID).Scan(&orderID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create purchase order",
+		})
+	}
+	if err := insertPurchaseOrderItems(tx, orderID, items); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create purchase order items",
+		})
+	}
+
+	po, err := getPurchaseOrder(tx, storeID, orderID)
+	if err != nil || po == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase order",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create purchase order",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    po,
+	})
+}
+
+// UpdatePurchaseOrder changes a purchase order while it is still a draft
+func UpdatePurchaseOrder(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	orderUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid purchase order ID",
+		})
+	}
+
+	var req models.UpdatePurchaseOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(`
+		SELECT status FROM purchase_orders WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, orderUUID, storeID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Purchase order not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase order",
+		})
+	}
+	if status != "draft" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Only draft purchase orders can be changed",
+		})
+	}
+
+	if req.SupplierID != nil {
+		if err := checkSupplier(tx, storeID, *req.SupplierID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	var total *models.Money
+	if req.Items != nil {
+		items, itemsTotal, err := purchaseOrderLines(tx, storeID, req.Items)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		if _, err := tx.Exec(`DELETE FROM purchase_order_items WHERE purchase_order_id = $1`, orderUUID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to update purchase order items",
+			})
+		}
+		if err := insertPurchaseOrderItems(tx, orderUUID, items); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to update purchase order items",
+			})
+		}
+		total = &itemsTotal
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE purchase_orders SET
+			supplier_id = COALESCE($2, supplier_id),
+			expected_at = COALESCE($3, expected_at),
+			notes = COALESCE($4, notes),
+			total = COALESCE($5, total),
+			updated_at = NOW()
+		WHERE id = $1
+	`, orderUUID, req.SupplierID, req.ExpectedAt, req.Notes, total); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update purchase order",
+		})
+	}
+
+	po, err := getPurchaseOrder(tx, storeID, orderUUID)
+	if err != nil || po == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase order",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update purchase order",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    po,
+	})
+}
+
+// SendPurchaseOrder marks a draft purchase order as sent to the supplier,
+// after which its lines can no longer be changed
+func SendPurchaseOrder(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	orderUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid purchase order ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE purchase_orders SET status = 'sent', sent_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND store_id = $2 AND status = 'draft'
+	`, orderUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to send purchase order",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "No draft purchase order found",
+		})
+	}
+
+	po, err := getPurchaseOrder(database.DB, storeID, orderUUID)
+	if err != nil || po == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase order",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    po,
+		"message": "Purchase order sent",
+	})
+}
+
+// CancelPurchaseOrder cancels a purchase order before anything has been
+// received against it
+func CancelPurchaseOrder(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	orderUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid purchase order ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE purchase_orders SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1 AND store_id = $2 AND status IN ('draft', 'sent')
+	`, orderUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to cancel purchase order",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "No draft or sent purchase order found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Purchase order cancelled",
+	})
+}
+
+// ReceivePurchaseOrder books in a delivery: each line received posts an 'in'
+// stock movement referencing the order and averages what was paid into the
+// product's cost. Whatever has not been delivered yet stays
+// outstanding on the order, which is received once nothing is left.
+func ReceivePurchaseOrder(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	orderUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid purchase order ID",
+		})
+	}
+
+	var req models.ReceivePurchaseOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var poNumber, status string
+	err = tx.QueryRow(`
+		SELECT po_number, status FROM purchase_orders WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, orderUUID, storeID).Scan(&poNumber, &status)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Purchase order not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase order",
+		})
+	}
+	// Goods only arrive against an order that has been sent to the supplier
+	if status != "sent" && status != "partially_received" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Only sent purchase orders can be received",
+		})
+	}
+
+	items, err := loadPurchaseOrderItems(tx, orderUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase order items",
+		})
+	}
+	byID := map[uuid.UUID]*models.PurchaseOrderItem{}
+	for i := range items {
+		byID[items[i].ID] = &items[i]
+	}
+
+	// Check every line before touching stock, counting a line given twice
+	// against its outstanding quantity once
+	receiving := map[uuid.UUID]models.Quantity{}
+	var productIDs []uuid.UUID
+	for _, line := range req.Items {
+		item, ok := byID[line.ItemID]
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   fmt.Sprintf("Item %s is not part of this purchase order", line.ItemID),
+			})
+		}
+		var decimalQuantity, trackStock bool
+		tx.QueryRow(`
+			SELECT decimal_quantity, track_stock FROM products WHERE id = $1
+		`, item.ProductID).Scan(&decimalQuantity, &trackStock)
+		if err := checkQuantity(item.ProductName, decimalQuantity, line.Quantity); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		if hasBatch(line.BatchNumber, line.ExpiryDate) && !trackStock {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Stock of " + item.ProductName + " is not tracked, so it has no batches",
+			})
+		}
+		receiving[item.ID] += line.Quantity
+		if receiving[item.ID] > item.Outstanding {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   fmt.Sprintf("Only %s %s of %s is outstanding", item.Outstanding, item.UnitName, item.ProductName),
+			})
+		}
+		productIDs = append(productIDs, item.ProductID)
+	}
+	if err := lockProducts(tx, storeID, productIDs); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to lock products",
+		})
+	}
+
+	for _, line := range req.Items {
+		item := byID[line.ItemID]
+		unitCost := item.UnitCost
+		if line.UnitCost != nil {
+			unitCost = *line.UnitCost
+		}
+
+		notes := fmt.Sprintf("%s: %s %s", poNumber, line.Quantity, item.UnitName)
+		if req.Notes != nil && *req.Notes != "" {
+			notes += ": " + *req.Notes
+		}
+		quantity := line.Quantity.MulQuantity(item.UnitFactor)
+		cost := baseUnitCost(unitCost, item.UnitFactor)
+		if err := restockProduct(tx, storeID, item.ProductID, quantity, &cost, "in", orderUUID, "purchase_order", notes, userID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to add stock of " + item.ProductName,
+			})
+		}
+		if err := addStockBatch(tx, storeID, item.ProductID, line.BatchNumber, line.ExpiryDate, quantity); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to record batch of " + item.ProductName,
+			})
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE purchase_order_items SET received_quantity = received_quantity + $2 WHERE id = $1
+		`, item.ID, line.Quantity); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to record received quantity",
+			})
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE purchase_orders SET
+			status = CASE WHEN complete THEN 'received' ELSE 'partially_received' END,
+			received_at = CASE WHEN complete THEN NOW() ELSE received_at END,
+			updated_at = NOW()
+		FROM (
+			SELECT BOOL_AND(received_quantity >= quantity) AS complete
+			FROM purchase_order_items WHERE purchase_order_id = $1
+		) lines
+		WHERE id = $1
+	`, orderUUID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update purchase order",
+		})
+	}
+
+	po, err := getPurchaseOrder(tx, storeID, orderUUID)
+	if err != nil || po == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase order",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to complete operation",
+		})
+	}
+
+	message := "Purchase order received"
+	if po.Status == "partially_received" {
+		message = "Purchase order partially received"
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    po,
+		"message": message,
+	})
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// loadRecipes returns the recipes of the given products keyed by product id,
+// with each ingredient's current cost, stock and unit. Products without a
+// recipe are left out.
+func loadRecipes(q queryer, productIDs []uuid.UUID) (map[uuid.UUID][]models.RecipeItem, error) {
+	recipes := map[uuid.UUID][]models.RecipeItem{}
+	if len(productIDs) == 0 {
+		return recipes, nil
+	}
+	ids := make([]string, 0, len(productIDs))
+	for _, id := range productIDs {
+		ids = append(ids, id.String())
+	}
+
+	rows, err := q.Query(`
+		SELECT r.product_id, r.ingredient_id, r.quantity,
+		       p.name, p.unit, p.cost, p.stock, p.track_stock
+		FROM recipe_items r
+		JOIN products p ON p.id = r.ingredient_id
+		WHERE r.product_id = ANY($1::uuid[])
+		ORDER BY p.name ASC
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID uuid.UUID
+		var item models.RecipeItem
+		var unitCost models.Money
+		if err := rows.Scan(
+			&productID, &item.IngredientID, &item.Quantity,
+			&item.IngredientName, &item.Unit, &unitCost, &item.Stock, &item.TrackStock,
+		); err != nil {
+			return nil, err
+		}
+		item.Cost = unitCost.MulQuantity(item.Quantity)
+		recipes[productID] = append(recipes[productID], item)
+	}
+	return recipes, rows.Err()
+}
+
+// recipeCost is the cost of one unit of a composite product
+func recipeCost(items []models.RecipeItem) models.Money {
+	var cost models.Money
+	for _, item := range items {
+		cost += item.Cost
+	}
+	return cost
+}
+
+// GetProductRecipe returns the ingredients of a product and their cost
+func GetProductRecipe(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+
+	var exists bool
+	database.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND store_id = $2)
+	`, productUUID, storeID).Scan(&exists)
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+
+	recipes, err := loadRecipes(database.DB, []uuid.UUID{productUUID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch recipe",
+		})
+	}
+
+	items := recipes[productUUID]
+	if items == nil {
+		items = []models.RecipeItem{}
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": models.Recipe{
+			ProductID: productUUID,
+			Items:     items,
+			Cost:      recipeCost(items),
+		},
+	})
+}
+
+// SetProductRecipe replaces the ingredients of a product. Once a product has
+// a recipe its own stock is no longer tracked; sales deduct the ingredients.
+func SetProductRecipe(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+
+	var req models.SetRecipeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var productName string
+	var hasVariants, isIngredient, isBundle bool
+	err = tx.QueryRow(`
+		SELECT name, variant_options IS NOT NULL,
+		       EXISTS(SELECT 1 FROM recipe_items WHERE ingredient_id = products.id),
+		       EXISTS(SELECT 1 FROM bundle_items WHERE bundle_id = products.id)
+		FROM products
+		WHERE id = $1 AND store_id = $2 AND is_active = true
+		FOR UPDATE
+	`, productUUID, storeID).Scan(&productName, &hasVariants, &isIngredient, &isBundle)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product",
+		})
+	}
+	if len(req.Items) > 0 {
+		if hasVariants {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Recipes of " + productName + " are set per variant",
+			})
+		}
+		if isIngredient {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   productName + " is an ingredient of another recipe",
+			})
+		}
+		if isBundle {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   productName + " is a bundle",
+			})
+		}
+	}
+
+	if err := setRecipeItems(tx, storeID, productUUID, req.Items); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	// Touch the product so terminals pick up the change on their next sync
+	if _, err := tx.Exec(`
+		UPDATE products
+		SET track_stock = CASE WHEN $2 THEN false ELSE track_stock END, updated_at = NOW()
+		WHERE id = $1
+	`, productUUID, len(req.Items) > 0); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update product",
+		})
+	}
+
+	recipes, err := loadRecipes(tx, []uuid.UUID{productUUID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch recipe",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to save recipe",
+		})
+	}
+
+	items := recipes[productUUID]
+	if items == nil {
+		items = []models.RecipeItem{}
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": models.Recipe{
+			ProductID: productUUID,
+			Items:     items,
+			Cost:      recipeCost(items),
+		},
+	})
+}
+
+// setRecipeItems replaces the recipe of a product. Ingredients must be
+// active products of the store that are sold as they are: not the product
+// itself, not a parent with variants, not a bundle and without a recipe of
+// their own.
+func setRecipeItems(tx *sql.Tx, storeID, productID uuid.UUID, items []models.RecipeItemRequest) error {
+	if _, err := tx.Exec(`DELETE FROM recipe_items WHERE product_id = $1`, productID); err != nil {
+		return err
+	}
+
+	seen := map[uuid.UUID]bool{}
+	for _, item := range items {
+		if seen[item.IngredientID] {
+			return fmt.Errorf("An ingredient is listed twice")
+		}
+		seen[item.IngredientID] = true
+		if item.IngredientID == productID {
+			return fmt.Errorf("A product can't be an ingredient of itself")
+		}
+
+		var name string
+		var hasVariants, hasRecipe, isBundle bool
+		err := tx.QueryRow(`
+			SELECT name, variant_options IS NOT NULL,
+			       EXISTS(SELECT 1 FROM recipe_items WHERE product_id = products.id),
+			       EXISTS(SELECT 1 FROM bundle_items WHERE bundle_id = products.id)
+			FROM products
+			WHERE id = $1 AND store_id = $2 AND is_active = true
+		`, item.IngredientID, storeID).Scan(&name, &hasVariants, &hasRecipe, &isBundle)
+		if err != nil {
+			return fmt.Errorf("Ingredient not found: %s", item.IngredientID)
+		}
+		if hasVariants {
+			return fmt.Errorf("Choose a variant of %s", name)
+		}
+		if hasRecipe {
+			return fmt.Errorf("%s has a recipe of its own", name)
+		}
+		if isBundle {
+			return fmt.Errorf("%s is a bundle", name)
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO recipe_items (product_id, ingredient_id, quantity)
+			VALUES ($1, $2, $3)
+		`, productID, item.IngredientID, item.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
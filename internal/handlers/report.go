@@ -12,8 +12,21 @@ import (
 	"kasirku/internal/models"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
+// netSalesColumns sums sales_entries e into the figures of a sales report.
+// Sales count when they were made and refunds when they were given, so
+// every report nets refunds the same way and a refund never changes the
+// figures of a period already closed.
+const netSalesColumns = `
+	COUNT(*) FILTER (WHERE e.is_sale) as total_transactions,
+	COALESCE(SUM(e.amount), 0) as total_sales,
+	COALESCE(SUM(e.discount), 0) as total_discounts,
+	COALESCE(-SUM(e.amount) FILTER (WHERE NOT e.is_sale), 0) as total_refunds,
+	COALESCE(SUM(e.amount - e.cost), 0) as gross_profit,
+	COALESCE(AVG(e.amount) FILTER (WHERE e.is_sale), 0) as average_transaction`
+
 // GetDailyReport returns daily sales report
 func GetDailyReport(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
@@ -22,22 +35,15 @@ func GetDailyReport(c *fiber.Ctx) error {
 
 	var report models.DailySalesReport
 	err := database.DB.QueryRow(`
-		SELECT 
-			$2::text as date,
-			COALESCE(COUNT(*), 0) as total_transactions,
-			COALESCE(SUM(total), 0) as total_sales,
-			COALESCE(SUM(discount_amount), 0) as total_discounts,
-			COALESCE(SUM(total) - SUM(
-				COALESCE((SELECT SUM(cost * quantity) FROM transaction_items WHERE transaction_id = t.id), 0)
-			), 0) as gross_profit,
-			COALESCE(AVG(total), 0) as average_transaction
-		FROM transactions t
-		WHERE store_id = $1 
-		AND DATE(created_at AT TIME ZONE $3) = $2::date 
-		AND status = 'completed'
+		SELECT
+			$2::text as date,`+netSalesColumns+`,
+			COALESCE(SUM(e.rounding), 0) as total_rounding
+		FROM sales_entries e
+		WHERE e.store_id = $1
+		AND DATE(e.occurred_at AT TIME ZONE $3) = $2::date
 	`, storeID, date, timezone).Scan(
-		&report.Date, &report.TotalTransactions, &report.TotalSales,
-		&report.TotalDiscounts, &report.GrossProfit, &report.AverageTransaction,
+		&report.Date, &report.TotalTransactions, &report.TotalSales, &report.TotalDiscounts,
+		&report.TotalRefunds, &report.GrossProfit, &report.AverageTransaction, &report.TotalRounding,
 	)
 	if err != nil && err != sql.ErrNoRows {
 		log.Printf("Error fetching daily report summary for store %s: %v", storeID, err)
@@ -49,15 +55,14 @@ func GetDailyReport(c *fiber.Ctx) error {
 
 	// Get hourly breakdown
 	rows, err := database.DB.Query(`
-		SELECT 
-			EXTRACT(HOUR FROM created_at AT TIME ZONE $3)::int as hour,
-			COUNT(*) as transactions,
-			COALESCE(SUM(total), 0) as sales
-		FROM transactions
-		WHERE store_id = $1 
-		AND DATE(created_at AT TIME ZONE $3) = $2::date 
-		AND status = 'completed'
-		GROUP BY EXTRACT(HOUR FROM created_at AT TIME ZONE $3)
+		SELECT
+			EXTRACT(HOUR FROM e.occurred_at AT TIME ZONE $3)::int as hour,
+			COUNT(*) FILTER (WHERE e.is_sale) as transactions,
+			COALESCE(SUM(e.amount), 0) as sales
+		FROM sales_entries e
+		WHERE e.store_id = $1
+		AND DATE(e.occurred_at AT TIME ZONE $3) = $2::date
+		GROUP BY 1
 		ORDER BY hour
 	`, storeID, date, timezone)
 	if err != nil {
@@ -67,9 +72,9 @@ func GetDailyReport(c *fiber.Ctx) error {
 	}
 
 	type HourlyStat struct {
-		Hour         int     `json:"hour"`
-		Transactions int     `json:"transactions"`
-		Sales        float64 `json:"sales"`
+		Hour         int          `json:"hour"`
+		Transactions int          `json:"transactions"`
+		Sales        models.Money `json:"sales"`
 	}
 	var hourlyStats []HourlyStat
 	if rows != nil {
@@ -80,17 +85,23 @@ func GetDailyReport(c *fiber.Ctx) error {
 		}
 	}
 
-	// Get payment type breakdown
+	// Get payment type breakdown from the payment lines. Change is handed
+	// back from the cash line and each entry, a sale or a refund of it, is
+	// spread across the lines of the sale pro rata.
 	paymentRows, err := database.DB.Query(`
-		SELECT 
-			payment_type,
-			COUNT(*) as count,
-			COALESCE(SUM(total), 0) as amount
-		FROM transactions
-		WHERE store_id = $1 
-		AND DATE(created_at AT TIME ZONE $3) = $2::date 
-		AND status = 'completed'
-		GROUP BY payment_type
+		SELECT
+			tp.payment_type,
+			COUNT(DISTINCT e.transaction_id) FILTER (WHERE e.is_sale) as count,
+			COALESCE(ROUND(SUM(
+				(CASE WHEN tp.payment_type = 'cash' THEN tp.amount - t.change_amount ELSE tp.amount END)
+				* (CASE WHEN t.total <> 0 THEN e.amount / t.total WHEN e.is_sale THEN 1 ELSE 0 END)
+			), 2), 0) as amount
+		FROM sales_entries e
+		JOIN transactions t ON e.transaction_id = t.id
+		JOIN transaction_payments tp ON tp.transaction_id = t.id
+		WHERE e.store_id = $1
+		AND DATE(e.occurred_at AT TIME ZONE $3) = $2::date
+		GROUP BY tp.payment_type
 	`, storeID, date, timezone)
 	if err != nil {
 		log.Printf("Error fetching daily report payment stats for store %s: %v", storeID, err)
@@ -99,9 +110,9 @@ func GetDailyReport(c *fiber.Ctx) error {
 	}
 
 	type PaymentStat struct {
-		Type   string  `json:"type"`
-		Count  int     `json:"count"`
-		Amount float64 `json:"amount"`
+		Type   string       `json:"type"`
+		Count  int          `json:"count"`
+		Amount models.Money `json:"amount"`
 	}
 	var paymentStats []PaymentStat
 	if paymentRows != nil {
@@ -129,17 +140,12 @@ func GetWeeklyReport(c *fiber.Ctx) error {
 	timezone := c.Query("timezone", "Asia/Makassar")
 
 	rows, err := database.DB.Query(`
-		SELECT 
-			DATE_TRUNC('week', created_at AT TIME ZONE $3)::date as week_start,
-			COUNT(*) as total_transactions,
-			COALESCE(SUM(total), 0) as total_sales,
-			COALESCE(SUM(discount_amount), 0) as total_discounts,
-			COALESCE(AVG(total), 0) as average_transaction
-		FROM transactions
-		WHERE store_id = $1 
-		AND created_at AT TIME ZONE $3 >= (NOW() AT TIME ZONE $3) - ($2 || ' weeks')::interval
-		AND status = 'completed'
-		GROUP BY DATE_TRUNC('week', created_at AT TIME ZONE $3)
+		SELECT
+			DATE_TRUNC('week', e.occurred_at AT TIME ZONE $3)::date as week_start,`+netSalesColumns+`
+		FROM sales_entries e
+		WHERE e.store_id = $1
+		AND e.occurred_at AT TIME ZONE $3 >= (NOW() AT TIME ZONE $3) - ($2 || ' weeks')::interval
+		GROUP BY 1
 		ORDER BY week_start DESC
 	`, storeID, weeksBack, timezone)
 	if err != nil {
@@ -151,17 +157,19 @@ func GetWeeklyReport(c *fiber.Ctx) error {
 	defer rows.Close()
 
 	type WeeklyData struct {
-		WeekStart          string  `json:"week_start"`
-		TotalTransactions  int     `json:"total_transactions"`
-		TotalSales         float64 `json:"total_sales"`
-		TotalDiscounts     float64 `json:"total_discounts"`
-		AverageTransaction float64 `json:"average_transaction"`
+		WeekStart          string       `json:"week_start"`
+		TotalTransactions  int          `json:"total_transactions"`
+		TotalSales         models.Money `json:"total_sales"`
+		TotalDiscounts     models.Money `json:"total_discounts"`
+		TotalRefunds       models.Money `json:"total_refunds"`
+		GrossProfit        models.Money `json:"gross_profit"`
+		AverageTransaction models.Money `json:"average_transaction"`
 	}
 	var weeklyData []WeeklyData
 	for rows.Next() {
 		var data WeeklyData
 		rows.Scan(&data.WeekStart, &data.TotalTransactions, &data.TotalSales,
-			&data.TotalDiscounts, &data.AverageTransaction)
+			&data.TotalDiscounts, &data.TotalRefunds, &data.GrossProfit, &data.AverageTransaction)
 		weeklyData = append(weeklyData, data)
 	}
 
@@ -178,17 +186,12 @@ func GetMonthlyReport(c *fiber.Ctx) error {
 	timezone := c.Query("timezone", "Asia/Makassar")
 
 	rows, err := database.DB.Query(`
-		SELECT 
-			TO_CHAR(DATE_TRUNC('month', created_at AT TIME ZONE $3), 'YYYY-MM') as month,
-			COUNT(*) as total_transactions,
-			COALESCE(SUM(total), 0) as total_sales,
-			COALESCE(SUM(discount_amount), 0) as total_discounts,
-			COALESCE(AVG(total), 0) as average_transaction
-		FROM transactions
-		WHERE store_id = $1 
-		AND created_at AT TIME ZONE $3 >= (NOW() AT TIME ZONE $3) - ($2 || ' months')::interval
-		AND status = 'completed'
-		GROUP BY DATE_TRUNC('month', created_at AT TIME ZONE $3)
+		SELECT
+			TO_CHAR(DATE_TRUNC('month', e.occurred_at AT TIME ZONE $3), 'YYYY-MM') as month,`+netSalesColumns+`
+		FROM sales_entries e
+		WHERE e.store_id = $1
+		AND e.occurred_at AT TIME ZONE $3 >= (NOW() AT TIME ZONE $3) - ($2 || ' months')::interval
+		GROUP BY 1
 		ORDER BY month DESC
 	`, storeID, monthsBack, timezone)
 	if err != nil {
@@ -200,17 +203,19 @@ func GetMonthlyReport(c *fiber.Ctx) error {
 	defer rows.Close()
 
 	type MonthlyData struct {
-		Month              string  `json:"month"`
-		TotalTransactions  int     `json:"total_transactions"`
-		TotalSales         float64 `json:"total_sales"`
-		TotalDiscounts     float64 `json:"total_discounts"`
-		AverageTransaction float64 `json:"average_transaction"`
+		Month              string       `json:"month"`
+		TotalTransactions  int          `json:"total_transactions"`
+		TotalSales         models.Money `json:"total_sales"`
+		TotalDiscounts     models.Money `json:"total_discounts"`
+		TotalRefunds       models.Money `json:"total_refunds"`
+		GrossProfit        models.Money `json:"gross_profit"`
+		AverageTransaction models.Money `json:"average_transaction"`
 	}
 	var monthlyData []MonthlyData
 	for rows.Next() {
 		var data MonthlyData
 		rows.Scan(&data.Month, &data.TotalTransactions, &data.TotalSales,
-			&data.TotalDiscounts, &data.AverageTransaction)
+			&data.TotalDiscounts, &data.TotalRefunds, &data.GrossProfit, &data.AverageTransaction)
 		monthlyData = append(monthlyData, data)
 	}
 
@@ -220,7 +225,8 @@ func GetMonthlyReport(c *fiber.Ctx) error {
 	})
 }
 
-// GetProductReport returns best selling products report
+// GetProductReport returns best selling products report. Sales of variants
+// are rolled up into their parent product.
 func GetProductReport(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 	limit := c.QueryInt("limit", 20)
@@ -232,27 +238,30 @@ func GetProductReport(c *fiber.Ctx) error {
 		SELECT 
 			p.id as product_id,
 			p.name as product_name,
-			COALESCE(SUM(ti.quantity), 0) as total_sold,
-			COALESCE(SUM(ti.subtotal), 0) as total_revenue,
-			COALESCE(SUM((ti.product_price - ti.cost) * ti.quantity), 0) as total_profit,
-			COUNT(DISTINCT ti.transaction_id) as transaction_count
+			COALESCE(SUM(e.quantity * ti.unit_factor), 0) as total_sold,
+			COALESCE(SUM(ti.subtotal * e.quantity / ti.quantity), 0) as total_revenue,
+			COALESCE(SUM((ti.product_price + ti.modifier_amount - ti.cost) * e.quantity), 0) as total_profit,
+			COUNT(DISTINCT e.transaction_id) FILTER (WHERE e.is_sale) as transaction_count
 		FROM products p
-		LEFT JOIN transaction_items ti ON p.id = ti.product_id
-		LEFT JOIN transactions t ON ti.transaction_id = t.id AND t.status = 'completed'
-		WHERE p.store_id = $1 AND p.is_active = true
+		LEFT JOIN products v ON v.id = p.id OR v.parent_id = p.id
+		LEFT JOIN (
+			sale_item_entries e
+			JOIN transaction_items ti ON ti.id = e.transaction_item_id
+		) ON v.id = ti.product_id
+		WHERE p.store_id = $1 AND p.is_active = true AND p.parent_id IS NULL
 	`
 	args := []interface{}{storeID}
 	argCount := 1
 
 	if dateFrom != "" {
 		argCount++
-		query += fmt.Sprintf(" AND DATE(t.created_at AT TIME ZONE $%d) >= $%d::date", argCount, argCount+1)
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) >= $%d::date", argCount, argCount+1)
 		args = append(args, timezone, dateFrom)
 		argCount++
 	}
 	if dateTo != "" {
 		argCount++
-		query += fmt.Sprintf(" AND DATE(t.created_at AT TIME ZONE $%d) <= $%d::date", argCount, argCount+1)
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) <= $%d::date", argCount, argCount+1)
 		args = append(args, timezone, dateTo)
 		argCount++
 	}
@@ -270,12 +279,58 @@ func GetProductReport(c *fiber.Ctx) error {
 	defer rows.Close()
 
 	var products []models.ProductReport
+	index := map[uuid.UUID]int{}
 	for rows.Next() {
 		var p models.ProductReport
 		rows.Scan(&p.ProductID, &p.ProductName, &p.TotalSold, &p.TotalRevenue,
 			&p.TotalProfit, &p.TransactionCount)
+		index[p.ProductID] = len(products)
 		products = append(products, p)
 	}
+	rows.Close()
+
+	// Add what bundles took of each product, as recorded when they were sold
+	usageQuery := `
+		SELECT COALESCE(v.parent_id, v.id),
+		       SUM(tic.quantity * e.quantity * ti.unit_factor)
+		FROM sale_item_entries e
+		JOIN transaction_items ti ON ti.id = e.transaction_item_id
+		JOIN transaction_item_components tic ON tic.transaction_item_id = ti.id
+		JOIN products v ON v.id = tic.product_id
+		WHERE e.store_id = $1
+	`
+	usageArgs := []interface{}{storeID}
+	argCount = 1
+	if dateFrom != "" {
+		argCount++
+		usageQuery += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) >= $%d::date", argCount, argCount+1)
+		usageArgs = append(usageArgs, timezone, dateFrom)
+		argCount++
+	}
+	if dateTo != "" {
+		argCount++
+		usageQuery += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) <= $%d::date", argCount, argCount+1)
+		usageArgs = append(usageArgs, timezone, dateTo)
+		argCount++
+	}
+	usageQuery += ` GROUP BY COALESCE(v.parent_id, v.id)`
+
+	usageRows, err := database.DB.Query(usageQuery, usageArgs...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product report",
+		})
+	}
+	defer usageRows.Close()
+	for usageRows.Next() {
+		var productID uuid.UUID
+		var quantity models.Quantity
+		usageRows.Scan(&productID, &quantity)
+		if i, ok := index[productID]; ok {
+			products[i].SoldInBundles = quantity
+		}
+	}
 
 	return c.JSON(fiber.Map{
 		"success": true,
@@ -283,6 +338,192 @@ func GetProductReport(c *fiber.Ctx) error {
 	})
 }
 
+// GetPromoReport returns the discounts given per promo
+func GetPromoReport(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	dateFrom := c.Query("date_from", "")
+	dateTo := c.Query("date_to", "")
+	timezone := c.Query("timezone", "Asia/Makassar")
+
+	query := `
+		SELECT 
+			p.id, p.name, p.code, p.type,
+			COUNT(*) FILTER (WHERE e.is_sale) as transaction_count,
+			COALESCE(SUM(t.promo_discount) FILTER (WHERE e.is_sale), 0) as total_discount,
+			COALESCE(SUM(e.amount), 0) as total_sales
+		FROM promos p
+		JOIN transactions t ON t.promo_id = p.id
+		JOIN sales_entries e ON e.transaction_id = t.id
+		WHERE p.store_id = $1
+	`
+	args := []interface{}{storeID}
+	argCount := 1
+
+	if dateFrom != "" {
+		argCount++
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) >= $%d::date", argCount, argCount+1)
+		args = append(args, timezone, dateFrom)
+		argCount++
+	}
+	if dateTo != "" {
+		argCount++
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) <= $%d::date", argCount, argCount+1)
+		args = append(args, timezone, dateTo)
+		argCount++
+	}
+
+	query += ` GROUP BY p.id, p.name, p.code, p.type ORDER BY total_discount DESC`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch promo report",
+		})
+	}
+	defer rows.Close()
+
+	var promos []models.PromoReport
+	for rows.Next() {
+		var p models.PromoReport
+		rows.Scan(&p.PromoID, &p.Name, &p.Code, &p.Type, &p.TransactionCount,
+			&p.TotalDiscount, &p.TotalSales)
+		promos = append(promos, p)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    promos,
+	})
+}
+
+// GetModifierReport returns the revenue from each modifier, at list price
+// before discounts and net of refunded quantities
+func GetModifierReport(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	dateFrom := c.Query("date_from", "")
+	dateTo := c.Query("date_to", "")
+	timezone := c.Query("timezone", "Asia/Makassar")
+
+	query := `
+		SELECT 
+			m.group_name, m.name,
+			COALESCE(SUM(e.quantity), 0) as total_sold,
+			COALESCE(SUM(m.price * e.quantity), 0) as total_revenue,
+			COUNT(DISTINCT e.transaction_id) FILTER (WHERE e.is_sale) as transaction_count
+		FROM sale_item_entries e
+		JOIN transaction_item_modifiers m ON m.transaction_item_id = e.transaction_item_id
+		WHERE e.store_id = $1
+	`
+	args := []interface{}{storeID}
+	argCount := 1
+
+	if dateFrom != "" {
+		argCount++
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) >= $%d::date", argCount, argCount+1)
+		args = append(args, timezone, dateFrom)
+		argCount++
+	}
+	if dateTo != "" {
+		argCount++
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) <= $%d::date", argCount, argCount+1)
+		args = append(args, timezone, dateTo)
+		argCount++
+	}
+
+	query += ` GROUP BY m.group_name, m.name ORDER BY total_revenue DESC, total_sold DESC`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch modifier report",
+		})
+	}
+	defer rows.Close()
+
+	var reports []models.ModifierReport
+	for rows.Next() {
+		var m models.ModifierReport
+		rows.Scan(&m.GroupName, &m.Name, &m.TotalSold, &m.TotalRevenue, &m.TransactionCount)
+		reports = append(reports, m)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    reports,
+	})
+}
+
+// GetTaxReport returns the tax and service charge collected per rate and
+// period. Inclusive tax is reported as well; refunds are taken off pro rata
+// in the period they were given.
+func GetTaxReport(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	period := c.Query("period", "monthly") // daily, monthly
+	dateFrom := c.Query("date_from", "")
+	dateTo := c.Query("date_to", "")
+	timezone := c.Query("timezone", "Asia/Makassar")
+
+	trunc, dateFormat := "month", "YYYY-MM"
+	if period == "daily" {
+		trunc, dateFormat = "day", "YYYY-MM-DD"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT 
+			TO_CHAR(DATE_TRUNC('%s', e.occurred_at AT TIME ZONE $2), '%s') as period,
+			tt.name, tt.type, tt.rate, tt.is_inclusive,
+			COUNT(DISTINCT e.transaction_id) FILTER (WHERE e.is_sale) as transaction_count,
+			COALESCE(ROUND(SUM(tt.taxable_amount
+				* (CASE WHEN t.total <> 0 THEN e.amount / t.total WHEN e.is_sale THEN 1 ELSE 0 END)), 2), 0) as taxable_amount,
+			COALESCE(ROUND(SUM(tt.amount
+				* (CASE WHEN t.total <> 0 THEN e.amount / t.total WHEN e.is_sale THEN 1 ELSE 0 END)), 2), 0) as tax_amount
+		FROM sales_entries e
+		JOIN transactions t ON e.transaction_id = t.id
+		JOIN transaction_taxes tt ON tt.transaction_id = t.id
+		WHERE e.store_id = $1
+	`, trunc, dateFormat)
+	args := []interface{}{storeID, timezone}
+	argCount := 2
+
+	if dateFrom != "" {
+		argCount++
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $2) >= $%d::date", argCount)
+		args = append(args, dateFrom)
+	}
+	if dateTo != "" {
+		argCount++
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $2) <= $%d::date", argCount)
+		args = append(args, dateTo)
+	}
+
+	query += ` GROUP BY 1, tt.name, tt.type, tt.rate, tt.is_inclusive ORDER BY period DESC, tt.type, tt.name, tt.rate`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("Error fetching tax report for store %s: %v", storeID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch tax report",
+		})
+	}
+	defer rows.Close()
+
+	var reports []models.TaxReport
+	for rows.Next() {
+		var r models.TaxReport
+		rows.Scan(&r.Period, &r.Name, &r.Type, &r.Rate, &r.IsInclusive, &r.TransactionCount,
+			&r.TaxableAmount, &r.TaxAmount)
+		reports = append(reports, r)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    reports,
+	})
+}
+
 // GetProfitLossReport returns profit and loss report
 func GetProfitLossReport(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
@@ -303,23 +544,20 @@ func GetProfitLossReport(c *fiber.Ctx) error {
 		interval = "months"
 	}
 
+	// Refunds take their revenue and the cost of the goods returned off the
+	// period they were given
 	query := fmt.Sprintf(`
-		SELECT 
-			TO_CHAR(DATE_TRUNC('%s', t.created_at AT TIME ZONE $3), '%s') as period,
-			COALESCE(SUM(t.total), 0) as total_revenue,
-			COALESCE(SUM(
-				(SELECT SUM(cost * quantity) FROM transaction_items WHERE transaction_id = t.id)
-			), 0) as total_cost,
-			COALESCE(SUM(t.total) - SUM(
-				COALESCE((SELECT SUM(cost * quantity) FROM transaction_items WHERE transaction_id = t.id), 0)
-			), 0) as gross_profit
-		FROM transactions t
-		WHERE t.store_id = $1 
-		AND t.created_at AT TIME ZONE $3 >= NOW() AT TIME ZONE $3 - ($2 || ' %s')::interval
-		AND t.status = 'completed'
-		GROUP BY DATE_TRUNC('%s', t.created_at AT TIME ZONE $3)
+		SELECT
+			TO_CHAR(DATE_TRUNC('%[1]s', e.occurred_at AT TIME ZONE $3), '%[2]s') as period,
+			COALESCE(SUM(e.amount), 0) as total_revenue,
+			COALESCE(SUM(e.cost), 0) as total_cost,
+			COALESCE(SUM(e.amount - e.cost), 0) as gross_profit
+		FROM sales_entries e
+		WHERE e.store_id = $1
+		AND e.occurred_at AT TIME ZONE $3 >= NOW() AT TIME ZONE $3 - ($2 || ' %[3]s')::interval
+		GROUP BY DATE_TRUNC('%[1]s', e.occurred_at AT TIME ZONE $3)
 		ORDER BY period DESC
-	`, interval[:len(interval)-1], dateFormat, interval, interval[:len(interval)-1])
+	`, interval[:len(interval)-1], dateFormat, interval)
 
 	rows, err := database.DB.Query(query, storeID, periodsBack, timezone)
 	if err != nil {
@@ -335,7 +573,7 @@ func GetProfitLossReport(c *fiber.Ctx) error {
 		var r models.ProfitLossReport
 		rows.Scan(&r.Period, &r.TotalRevenue, &r.TotalCost, &r.GrossProfit)
 		if r.TotalRevenue > 0 {
-			r.Margin = (r.GrossProfit / r.TotalRevenue) * 100
+			r.Margin = r.GrossProfit.Float64() / r.TotalRevenue.Float64() * 100
 		}
 		reports = append(reports, r)
 	}
@@ -351,17 +589,18 @@ func GetDashboardStats(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 
 	type DashboardStats struct {
-		TodaySales          float64 `json:"today_sales"`
-		TodayTransactions   int     `json:"today_transactions"`
-		TodayProfit         float64 `json:"today_profit"`
-		WeekSales           float64 `json:"week_sales"`
-		WeekTransactions    int     `json:"week_transactions"`
-		MonthSales          float64 `json:"month_sales"`
-		MonthTransactions   int     `json:"month_transactions"`
-		TotalProducts       int     `json:"total_products"`
-		TotalCustomers      int     `json:"total_customers"`
-		LowStockCount       int     `json:"low_stock_count"`
-		PendingTransactions int     `json:"pending_transactions"`
+		TodaySales          models.Money `json:"today_sales"`
+		TodayTransactions   int          `json:"today_transactions"`
+		TodayProfit         models.Money `json:"today_profit"`
+		WeekSales           models.Money `json:"week_sales"`
+		WeekTransactions    int          `json:"week_transactions"`
+		MonthSales          models.Money `json:"month_sales"`
+		MonthTransactions   int          `json:"month_transactions"`
+		TotalProducts       int          `json:"total_products"`
+		TotalCustomers      int          `json:"total_customers"`
+		LowStockCount       int          `json:"low_stock_count"`
+		ExpiringStockCount  int          `json:"expiring_stock_count"`
+		PendingTransactions int          `json:"pending_transactions"`
 	}
 
 	var stats DashboardStats
@@ -370,45 +609,49 @@ func GetDashboardStats(c *fiber.Ctx) error {
 	date := c.Query("date", time.Now().Format("2006-01-02"))
 	timezone := c.Query("timezone", "Asia/Makassar")
 
-	// Today's stats
+	// Today's stats, netted like the daily report
 	database.DB.QueryRow(`
-		SELECT 
-			COALESCE(SUM(total), 0),
-			COUNT(*),
-			COALESCE(SUM(total) - SUM(
-				COALESCE((SELECT SUM(cost * quantity) FROM transaction_items WHERE transaction_id = t.id), 0)
-			), 0)
-		FROM transactions t
-		WHERE store_id = $1 
-		AND DATE(created_at AT TIME ZONE $3) = $2::date 
-		AND status = 'completed'
+		SELECT
+			COALESCE(SUM(e.amount), 0),
+			COUNT(*) FILTER (WHERE e.is_sale),
+			COALESCE(SUM(e.amount - e.cost), 0)
+		FROM sales_entries e
+		WHERE e.store_id = $1
+		AND DATE(e.occurred_at AT TIME ZONE $3) = $2::date
 	`, storeID, date, timezone).Scan(&stats.TodaySales, &stats.TodayTransactions, &stats.TodayProfit)
 
 	// Week stats
 	database.DB.QueryRow(`
-		SELECT COALESCE(SUM(total), 0), COUNT(*)
-		FROM transactions
-		WHERE store_id = $1 
-		AND created_at AT TIME ZONE $2 >= (NOW() AT TIME ZONE $2) - INTERVAL '7 days' 
-		AND status = 'completed'
+		SELECT COALESCE(SUM(e.amount), 0), COUNT(*) FILTER (WHERE e.is_sale)
+		FROM sales_entries e
+		WHERE e.store_id = $1
+		AND e.occurred_at AT TIME ZONE $2 >= (NOW() AT TIME ZONE $2) - INTERVAL '7 days'
 	`, storeID, timezone).Scan(&stats.WeekSales, &stats.WeekTransactions)
 
 	// Month stats
 	database.DB.QueryRow(`
-		SELECT COALESCE(SUM(total), 0), COUNT(*)
-		FROM transactions
-		WHERE store_id = $1 
-		AND created_at AT TIME ZONE $2 >= (NOW() AT TIME ZONE $2) - INTERVAL '30 days' 
-		AND status = 'completed'
+		SELECT COALESCE(SUM(e.amount), 0), COUNT(*) FILTER (WHERE e.is_sale)
+		FROM sales_entries e
+		WHERE e.store_id = $1
+		AND e.occurred_at AT TIME ZONE $2 >= (NOW() AT TIME ZONE $2) - INTERVAL '30 days'
 	`, storeID, timezone).Scan(&stats.MonthSales, &stats.MonthTransactions)
 
 	// Counts
-	database.DB.QueryRow(`SELECT COUNT(*) FROM products WHERE store_id = $1 AND is_active = true`, storeID).Scan(&stats.TotalProducts)
+	database.DB.QueryRow(`SELECT COUNT(*) FROM products WHERE store_id = $1 AND is_active = true AND parent_id IS NULL`, storeID).Scan(&stats.TotalProducts)
 	database.DB.QueryRow(`SELECT COUNT(*) FROM customers WHERE store_id = $1 AND is_active = true`, storeID).Scan(&stats.TotalCustomers)
 	database.DB.QueryRow(`
 		SELECT COUNT(*) FROM products 
 		WHERE store_id = $1 AND is_active = true AND track_stock = true AND stock <= min_stock
 	`, storeID).Scan(&stats.LowStockCount)
+	database.DB.QueryRow(`
+		SELECT COUNT(*) FROM stock_batches b
+		JOIN products p ON p.id = b.product_id
+		WHERE b.store_id = $1 AND b.quantity > 0 AND p.is_active = true
+		AND b.expiry_date <= CURRENT_DATE + 30
+	`, storeID).Scan(&stats.ExpiringStockCount)
+	database.DB.QueryRow(`
+		SELECT COUNT(*) FROM transactions WHERE store_id = $1 AND status = 'pending'
+	`, storeID).Scan(&stats.PendingTransactions)
 
 	return c.JSON(fiber.Map{
 		"success": true,
@@ -416,7 +659,9 @@ func GetDashboardStats(c *fiber.Ctx) error {
 	})
 }
 
-// ExportReport generates a CSV export of transactions
+// ExportReport generates a CSV export of transactions. Each sale is a row on
+// the date it was made and each refund a row of its own on the date it was
+// given, so the columns add up to the other reports for the same dates.
 func ExportReport(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 	dateFrom := c.Query("date_from", "")
@@ -424,32 +669,31 @@ func ExportReport(c *fiber.Ctx) error {
 	timezone := c.Query("timezone", "Asia/Makassar")
 
 	query := `
-		SELECT t.invoice_number, t.created_at AT TIME ZONE $2, 
-		       COALESCE(c.name, 'Pelanggan Umum'), t.payment_type,
-		       t.subtotal, t.discount_amount, t.tax_amount, t.total,
-		       COALESCE(SUM(ti.cost * ti.quantity), 0) as total_cost,
-		       t.total - COALESCE(SUM(ti.cost * ti.quantity), 0) as profit
-		FROM transactions t
+		SELECT t.invoice_number, e.occurred_at AT TIME ZONE $2,
+		       COALESCE(c.name, 'Pelanggan Umum'), t.payment_type, e.is_sale,
+		       t.subtotal, t.discount_amount, t.service_charge, t.tax_amount, t.total,
+		       e.amount, e.cost
+		FROM sales_entries e
+		JOIN transactions t ON e.transaction_id = t.id
 		LEFT JOIN customers c ON t.customer_id = c.id
-		LEFT JOIN transaction_items ti ON t.id = ti.transaction_id
-		WHERE t.store_id = $1 AND t.status = 'completed'
+		WHERE e.store_id = $1
 	`
 	args := []interface{}{storeID, timezone}
 	argCount := 2
 
 	if dateFrom != "" {
 		argCount++
-		query += fmt.Sprintf(" AND DATE(t.created_at AT TIME ZONE $%d) >= $%d", 2, argCount)
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) >= $%d", 2, argCount)
 		args = append(args, dateFrom)
 	}
 
 	if dateTo != "" {
 		argCount++
-		query += fmt.Sprintf(" AND DATE(t.created_at AT TIME ZONE $%d) <= $%d", 2, argCount)
+		query += fmt.Sprintf(" AND DATE(e.occurred_at AT TIME ZONE $%d) <= $%d", 2, argCount)
 		args = append(args, dateTo)
 	}
 
-	query += " GROUP BY t.id, c.name ORDER BY t.created_at DESC"
+	query += " ORDER BY e.occurred_at DESC"
 
 	rows, err := database.DB.Query(query, args...)
 	if err != nil {
@@ -466,25 +710,37 @@ func ExportReport(c *fiber.Ctx) error {
 	w := csv.NewWriter(b)
 
 	// Header
-	w.Write([]string{"Invoice", "Tanggal", "Pelanggan", "Pembayaran", "Subtotal", "Diskon", "Pajak", "Total", "Modal", "Untung"})
+	w.Write([]string{"Invoice", "Tanggal", "Pelanggan", "Pembayaran", "Subtotal", "Diskon", "Servis", "Pajak", "Total", "Refund", "Modal", "Untung"})
 
 	for rows.Next() {
 		var inv, cust, pay string
 		var created time.Time
-		var sub, disc, tax, tot, cost, profit float64
-		rows.Scan(&inv, &created, &cust, &pay, &sub, &disc, &tax, &tot, &cost, &profit)
+		var isSale bool
+		var sub, disc, service, tax, tot, amount, cost models.Money
+		rows.Scan(&inv, &created, &cust, &pay, &isSale, &sub, &disc, &service, &tax, &tot, &amount, &cost)
+
+		// A refund row only carries what was given back and the cost of the
+		// goods returned
+		var refunded models.Money
+		if !isSale {
+			sub, disc, service, tax, tot = 0, 0, 0, 0, 0
+			refunded = -amount
+		}
+		profit := amount - cost
 
 		w.Write([]string{
 			inv,
 			created.Format("2006-01-02 15:04"),
 			cust,
 			pay,
-			fmt.Sprintf("%.2f", sub),
-			fmt.Sprintf("%.2f", disc),
-			fmt.Sprintf("%.2f", tax),
-			fmt.Sprintf("%.2f", tot),
-			fmt.Sprintf("%.2f", cost),
-			fmt.Sprintf("%.2f", profit),
+			sub.String(),
+			disc.String(),
+			service.String(),
+			tax.String(),
+			tot.String(),
+			refunded.String(),
+			cost.String(),
+			profit.String(),
 		})
 	}
 	w.Flush()
@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+	"kasirku/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const scaleBarcodePatternColumns = `id, store_id, name, prefix, plu_length, value_type, value_length, value_decimals, is_active, created_at, updated_at`
+
+// queryScaleBarcodePatterns returns the scale barcode patterns matching where
+func queryScaleBarcodePatterns(q queryer, where string, args ...interface{}) ([]models.ScaleBarcodePattern, error) {
+	rows, err := q.Query(`
+		SELECT `+scaleBarcodePatternColumns+` FROM scale_barcode_patterns
+		WHERE `+where+`
+		ORDER BY prefix ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	patterns := []models.ScaleBarcodePattern{}
+	for rows.Next() {
+		var p models.ScaleBarcodePattern
+		if err := rows.Scan(
+			&p.ID, &p.StoreID, &p.Name, &p.Prefix, &p.PLULength, &p.ValueType,
+			&p.ValueLength, &p.ValueDecimals, &p.IsActive, &p.CreatedAt, &p.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// isDigits reports whether s is made of ASCII digits only
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// parseScaleBarcode matches code against the store's active scale barcode
+// patterns. It returns nil without an error when code is not a scale label.
+// A price label's Quantity is left for the caller, who knows the price.
+func parseScaleBarcode(q queryer, storeID uuid.UUID, code string) (*models.ScaleBarcode, error) {
+	if len(code) != 13 || !isDigits(code) {
+		return nil, nil
+	}
+	patterns, err := queryScaleBarcodePatterns(q, `store_id = $1 AND is_active = true`, storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range patterns {
+		if !strings.HasPrefix(code, p.Prefix) {
+			continue
+		}
+		if !services.IsEAN13(code) {
+			return nil, fmt.Errorf("Invalid check digit in barcode %s", code)
+		}
+
+		scale := &models.ScaleBarcode{
+			PatternID: p.ID,
+			PLU:       code[len(p.Prefix) : len(p.Prefix)+p.PLULength],
+			ValueType: p.ValueType,
+		}
+		value, _ := strconv.ParseInt(code[12-p.ValueLength:12], 10, 64)
+		divisor := int64(1)
+		for i := 0; i < p.ValueDecimals; i++ {
+			divisor *= 10
+		}
+		if p.ValueType == "weight" {
+			scale.Quantity = models.Quantity(value * 1000 / divisor)
+		} else {
+			price := models.Money(value * 100 / divisor)
+			scale.Price = &price
+		}
+		return scale, nil
+	}
+	return nil, nil
+}
+
+// checkScaleBarcodePattern checks that a pattern fits in an EAN-13 barcode
+// and doesn't overlap another active pattern of the store
+func checkScaleBarcodePattern(tx *sql.Tx, storeID uuid.UUID, p *models.ScaleBarcodePattern) error {
+	if !isDigits(p.Prefix) || p.Prefix[0] != '2' {
+		return fmt.Errorf("Scale barcode prefixes are in the 20-29 range")
+	}
+	if len(p.Prefix)+p.PLULength+p.ValueLength > 12 {
+		return fmt.Errorf("Prefix, PLU and value don't fit in an EAN-13 barcode")
+	}
+	if p.ValueType == "price" && p.ValueDecimals > 2 {
+		return fmt.Errorf("Prices have at most 2 decimals")
+	}
+	if !p.IsActive {
+		return nil
+	}
+
+	// One prefix being the start of another would make a label ambiguous
+	var other string
+	err := tx.QueryRow(`
+		SELECT name FROM scale_barcode_patterns
+		WHERE store_id = $1 AND is_active = true AND id <> $2
+		  AND (prefix LIKE $3 || '%' OR $3 LIKE prefix || '%')
+		LIMIT 1
+	`, storeID, p.ID, p.Prefix).Scan(&other)
+	if err == nil {
+		return fmt.Errorf("Prefix %s overlaps pattern %s", p.Prefix, other)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// ListScaleBarcodePatterns returns the scale barcode patterns of a store
+func ListScaleBarcodePatterns(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	where := `store_id = $1`
+	if c.QueryBool("active", false) {
+		where += ` AND is_active = true`
+	}
+
+	patterns, err := queryScaleBarcodePatterns(database.DB, where, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch scale barcode patterns",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    patterns,
+	})
+}
+
+// CreateScaleBarcodePattern adds a scale barcode pattern
+func CreateScaleBarcodePattern(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	var req models.CreateScaleBarcodePatternRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	pattern := models.ScaleBarcodePattern{
+		StoreID:     storeID,
+		Name:        req.Name,
+		Prefix:      req.Prefix,
+		PLULength:   req.PLULength,
+		ValueType:   req.ValueType,
+		ValueLength: req.ValueLength,
+		IsActive:    true,
+	}
+	if req.ValueDecimals != nil {
+		pattern.ValueDecimals = *req.ValueDecimals
+	} else if req.ValueType == "weight" {
+		pattern.ValueDecimals = 3
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	if err := checkScaleBarcodePattern(tx, storeID, &pattern); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO scale_barcode_patterns (store_id, name, prefix, plu_length, value_type, value_length, value_decimals)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+scaleBarcodePatternColumns,
+		storeID, pattern.Name, pattern.Prefix, pattern.PLULength, pattern.ValueType,
+		pattern.ValueLength, pattern.ValueDecimals,
+	).Scan(
+		&pattern.ID, &pattern.StoreID, &pattern.Name, &pattern.Prefix, &pattern.PLULength, &pattern.ValueType,
+		&pattern.ValueLength, &pattern.ValueDecimals, &pattern.IsActive, &pattern.CreatedAt, &pattern.UpdatedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create scale barcode pattern: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create scale barcode pattern",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    pattern,
+	})
+}
+
+// UpdateScaleBarcodePattern updates a scale barcode pattern
+func UpdateScaleBarcodePattern(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	patternUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid scale barcode pattern ID",
+		})
+	}
+
+	var req models.UpdateScaleBarcodePatternRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	patterns, err := queryScaleBarcodePatterns(tx, `id = $1 AND store_id = $2`, patternUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch scale barcode pattern",
+		})
+	}
+	if len(patterns) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Scale barcode pattern not found",
+		})
+	}
+
+	// The layout is checked as a whole, so apply the changes first
+	pattern := patterns[0]
+	if req.Name != nil {
+		pattern.Name = *req.Name
+	}
+	if req.Prefix != nil {
+		pattern.Prefix = *req.Prefix
+	}
+	if req.PLULength != nil {
+		pattern.PLULength = *req.PLULength
+	}
+	if req.ValueType != nil {
+		pattern.ValueType = *req.ValueType
+	}
+	if req.ValueLength != nil {
+		pattern.ValueLength = *req.ValueLength
+	}
+	if req.ValueDecimals != nil {
+		pattern.ValueDecimals = *req.ValueDecimals
+	}
+	if req.IsActive != nil {
+		pattern.IsActive = *req.IsActive
+	}
+	if err := checkScaleBarcodePattern(tx, storeID, &pattern); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	err = tx.QueryRow(`
+		UPDATE scale_barcode_patterns SET
+			name = $3, prefix = $4, plu_length = $5, value_type = $6,
+			value_length = $7, value_decimals = $8, is_active = $9,
+			updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+		RETURNING updated_at
+	`, patternUUID, storeID, pattern.Name, pattern.Prefix, pattern.PLULength, pattern.ValueType,
+		pattern.ValueLength, pattern.ValueDecimals, pattern.IsActive).Scan(&pattern.UpdatedAt)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update scale barcode pattern",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update scale barcode pattern",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    pattern,
+	})
+}
+
+// DeleteScaleBarcodePattern deactivates a scale barcode pattern
+func DeleteScaleBarcodePattern(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	patternUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid scale barcode pattern ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE scale_barcode_patterns SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+	`, patternUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete scale barcode pattern",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Scale barcode pattern not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Scale barcode pattern deleted successfully",
+	})
+}
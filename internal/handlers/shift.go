@@ -0,0 +1,512 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"kasirku/internal/database"
+	"kasirku/internal/middleware"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const shiftColumns = `s.id, s.store_id, s.cashier_id, s.status, s.opening_cash, s.expected_cash,
+	s.counted_cash, s.cash_difference, s.notes, s.opened_at, s.closed_at, s.closed_by, u.full_name`
+
+func scanShift(row interface{ Scan(...interface{}) error }, s *models.Shift) error {
+	return row.Scan(
+		&s.ID, &s.StoreID, &s.CashierID, &s.Status, &s.OpeningCash, &s.ExpectedCash,
+		&s.CountedCash, &s.CashDifference, &s.Notes, &s.OpenedAt, &s.ClosedAt, &s.ClosedBy,
+		&s.CashierName,
+	)
+}
+
+// OpenShift opens a shift for the current cashier with the opening float
+func OpenShift(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	var req models.OpenShiftRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	var shiftID uuid.UUID
+	err := database.DB.QueryRow(`
+		INSERT INTO shifts (store_id, cashier_id, opening_cash, notes)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, storeID, userID, req.OpeningCash, req.Notes).Scan(&shiftID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "idx_shifts_open" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success": false,
+			"error":   "You already have an open shift",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to open shift",
+		})
+	}
+
+	var shift models.Shift
+	if err := loadShift(database.DB, storeID, shiftID, &shift); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch shift",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    shift,
+		"message": "Shift opened",
+	})
+}
+
+// GetCurrentShift returns the current cashier's open shift with its running
+// totals (an X-report)
+func GetCurrentShift(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	var shift models.Shift
+	err := scanShift(database.DB.QueryRow(`
+		SELECT `+shiftColumns+`
+		FROM shifts s
+		LEFT JOIN users u ON s.cashier_id = u.id
+		WHERE s.store_id = $1 AND s.cashier_id = $2 AND s.status = 'open'
+	`, storeID, userID), &shift)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "No open shift",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch shift",
+		})
+	}
+
+	report, err := buildShiftReport(database.DB, shift)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to build shift report",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// ListShifts returns the shifts of a store
+func ListShifts(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	status := c.Query("status", "")
+	limit := c.QueryInt("limit", 50)
+
+	query := `
+		SELECT ` + shiftColumns + `
+		FROM shifts s
+		LEFT JOIN users u ON s.cashier_id = u.id
+		WHERE s.store_id = $1
+	`
+	args := []interface{}{storeID}
+	if status != "" {
+		query += " AND s.status = $2"
+		args = append(args, status)
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	query += fmt.Sprintf(" ORDER BY s.opened_at DESC LIMIT $%d", len(args)+1)
+	args = append(args, limit)
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch shifts",
+		})
+	}
+	defer rows.Close()
+
+	var shifts []models.Shift
+	for rows.Next() {
+		var s models.Shift
+		scanShift(rows, &s)
+		shifts = append(shifts, s)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    shifts,
+	})
+}
+
+// AddShiftCash records petty cash put into or taken out of the drawer
+func AddShiftCash(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	shiftUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid shift ID",
+		})
+	}
+
+	var req models.ShiftCashRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	var shift models.Shift
+	if err := loadShift(database.DB, storeID, shiftUUID, &shift); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Shift not found",
+		})
+	}
+	if !canAccessShift(c, &shift) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"error":   "This shift belongs to another cashier",
+		})
+	}
+	if shift.Status != "open" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Shift is already closed",
+		})
+	}
+
+	var movement models.ShiftCashMovement
+	err = database.DB.QueryRow(`
+		INSERT INTO shift_cash_movements (shift_id, type, amount, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, shift_id, type, amount, reason, created_by, created_at
+	`, shiftUUID, req.Type, req.Amount, req.Reason, userID).Scan(
+		&movement.ID, &movement.ShiftID, &movement.Type, &movement.Amount,
+		&movement.Reason, &movement.CreatedBy, &movement.CreatedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to record cash movement",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    movement,
+	})
+}
+
+// CloseShift closes a shift with the counted drawer cash and returns its
+// Z-report
+func CloseShift(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	shiftUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid shift ID",
+		})
+	}
+
+	var req models.CloseShiftRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT id FROM shifts WHERE id = $1 AND store_id = $2 FOR UPDATE`, shiftUUID, storeID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	var shift models.Shift
+	if err := loadShift(tx, storeID, shiftUUID, &shift); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Shift not found",
+		})
+	}
+	if !canAccessShift(c, &shift) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"error":   "This shift belongs to another cashier",
+		})
+	}
+	if shift.Status != "open" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Shift is already closed",
+		})
+	}
+
+	report, err := buildShiftReport(tx, shift)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to build shift report",
+		})
+	}
+
+	difference := req.CountedCash - report.ExpectedCash
+	_, err = tx.Exec(`
+		UPDATE shifts SET
+			status = 'closed',
+			expected_cash = $2,
+			counted_cash = $3,
+			cash_difference = $4,
+			notes = COALESCE($5, notes),
+			closed_at = NOW(),
+			closed_by = $6,
+			updated_at = NOW()
+		WHERE id = $1
+	`, shiftUUID, report.ExpectedCash, req.CountedCash, difference, req.Notes, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to close shift",
+		})
+	}
+
+	if err := loadShift(tx, storeID, shiftUUID, &report.Shift); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch shift",
+		})
+	}
+	report.CountedCash = report.Shift.CountedCash
+	report.CashDifference = report.Shift.CashDifference
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to close shift",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    report,
+		"message": "Shift closed",
+	})
+}
+
+// GetShiftReport returns the X-report of an open shift or the Z-report of a
+// closed one
+func GetShiftReport(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	shiftUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid shift ID",
+		})
+	}
+
+	var shift models.Shift
+	if err := loadShift(database.DB, storeID, shiftUUID, &shift); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Shift not found",
+		})
+	}
+	if !canAccessShift(c, &shift) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"error":   "This shift belongs to another cashier",
+		})
+	}
+
+	report, err := buildShiftReport(database.DB, shift)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to build shift report",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// activeShiftID returns the open shift of a cashier, if any, so new sales
+// and refunds can be attached to it
+func activeShiftID(tx *sql.Tx, storeID, userID uuid.UUID) *uuid.UUID {
+	var shiftID uuid.UUID
+	err := tx.QueryRow(`
+		SELECT id FROM shifts
+		WHERE store_id = $1 AND cashier_id = $2 AND status = 'open'
+	`, storeID, userID).Scan(&shiftID)
+	if err != nil {
+		return nil
+	}
+	return &shiftID
+}
+
+func loadShift(q queryer, storeID, shiftID uuid.UUID, shift *models.Shift) error {
+	return scanShift(q.QueryRow(`
+		SELECT `+shiftColumns+`
+		FROM shifts s
+		LEFT JOIN users u ON s.cashier_id = u.id
+		WHERE s.id = $1 AND s.store_id = $2
+	`, shiftID, storeID), shift)
+}
+
+// canAccessShift allows cashiers their own shifts and owners and managers
+// every shift of the store
+func canAccessShift(c *fiber.Ctx, shift *models.Shift) bool {
+	return shift.CashierID == middleware.GetUserID(c) || middleware.IsOwnerOrManager(c)
+}
+
+// buildShiftReport totals the sales, refunds and drawer movements of a shift.
+// Refunds are assumed to be paid out of the drawer in cash.
+func buildShiftReport(q queryer, shift models.Shift) (*models.ShiftReport, error) {
+	report := &models.ShiftReport{
+		Shift:          shift,
+		OpeningCash:    shift.OpeningCash,
+		CountedCash:    shift.CountedCash,
+		CashDifference: shift.CashDifference,
+		Payments:       []models.ShiftPaymentTotal{},
+		CashMovements:  []models.ShiftCashMovement{},
+	}
+
+	err := q.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(total), 0), COALESCE(SUM(discount_amount), 0)
+		FROM transactions
+		WHERE shift_id = $1 AND status IN ('completed', 'refunded')
+	`, shift.ID).Scan(&report.TransactionCount, &report.GrossSales, &report.TotalDiscounts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.QueryRow(`
+		SELECT COUNT(*) FROM transactions WHERE shift_id = $1 AND status = 'cancelled'
+	`, shift.ID).Scan(&report.VoidCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM refunds WHERE shift_id = $1
+	`, shift.ID).Scan(&report.RefundCount, &report.TotalRefunds)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cash is taken net of the change handed back
+	rows, err := q.Query(`
+		SELECT p.payment_type,
+		       COALESCE(SUM(p.amount - CASE WHEN p.payment_type = 'cash' THEN t.change_amount ELSE 0 END), 0),
+		       COUNT(*)
+		FROM transaction_payments p
+		JOIN transactions t ON p.transaction_id = t.id
+		WHERE t.shift_id = $1 AND t.status IN ('completed', 'refunded')
+		GROUP BY p.payment_type
+		ORDER BY p.payment_type
+	`, shift.ID)
+	if err != nil {
+		return nil, err
+	}
+	var cashSales models.Money
+	for rows.Next() {
+		var p models.ShiftPaymentTotal
+		rows.Scan(&p.PaymentType, &p.Amount, &p.Count)
+		if p.PaymentType == "cash" {
+			cashSales = p.Amount
+		}
+		report.Payments = append(report.Payments, p)
+	}
+	rows.Close()
+
+	rows, err = q.Query(`
+		SELECT id, shift_id, type, amount, reason, created_by, created_at
+		FROM shift_cash_movements
+		WHERE shift_id = $1
+		ORDER BY created_at ASC
+	`, shift.ID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var m models.ShiftCashMovement
+		rows.Scan(&m.ID, &m.ShiftID, &m.Type, &m.Amount, &m.Reason, &m.CreatedBy, &m.CreatedAt)
+		if m.Type == "in" {
+			report.CashIn += m.Amount
+		} else {
+			report.CashOut += m.Amount
+		}
+		report.CashMovements = append(report.CashMovements, m)
+	}
+	rows.Close()
+
+	report.NetSales = report.GrossSales - report.TotalRefunds
+	report.ExpectedCash = shift.OpeningCash + cashSales + report.CashIn - report.CashOut - report.TotalRefunds
+	return report, nil
+}
@@ -29,7 +29,7 @@ func ListStockMovements(c *fiber.Ctx) error {
 
 	query := `
 		SELECT sm.id, sm.product_id, sm.store_id, sm.type, sm.quantity,
-		       sm.stock_before, sm.stock_after, sm.notes, sm.created_by, sm.created_at,
+		       sm.stock_before, sm.stock_after, sm.unit_cost, sm.notes, sm.created_by, sm.created_at,
 		       p.name as product_name
 		FROM stock_movements sm
 		JOIN products p ON sm.product_id = p.id
@@ -67,7 +67,7 @@ func ListStockMovements(c *fiber.Ctx) error {
 		var m models.StockMovement
 		rows.Scan(
 			&m.ID, &m.ProductID, &m.StoreID, &m.Type, &m.Quantity,
-			&m.StockBefore, &m.StockAfter, &m.Notes, &m.CreatedBy, &m.CreatedAt,
+			&m.StockBefore, &m.StockAfter, &m.UnitCost, &m.Notes, &m.CreatedBy, &m.CreatedAt,
 			&m.ProductName,
 		)
 		movements = append(movements, m)
@@ -85,7 +85,29 @@ func ListStockMovements(c *fiber.Ctx) error {
 	})
 }
 
-// StockIn adds stock to a product
+// averageCost returns the moving average cost per base unit once quantity
+// bought at unitCost joins stock valued at cost. Stock below zero has
+// nothing left to average with, so the new goods set the cost.
+func averageCost(stock models.Quantity, cost models.Money, quantity models.Quantity, unitCost models.Money) models.Money {
+	if stock < 0 {
+		stock = 0
+	}
+	if stock+quantity <= 0 {
+		return unitCost
+	}
+	value := cost.MulQuantity(stock) + unitCost.MulQuantity(quantity)
+	return value.MulDiv(int64(models.NewQuantity(1)), int64(stock+quantity))
+}
+
+// baseUnitCost converts a cost per unit of factor base units into a cost per
+// base unit: a box of 12 costs a twelfth of its price per piece
+func baseUnitCost(unitCost models.Money, factor models.Quantity) models.Money {
+	return unitCost.MulDiv(int64(models.NewQuantity(1)), int64(factor))
+}
+
+// StockIn adds stock to a product. Given what it cost, the stock is averaged
+// into the product's cost, and given a batch number or expiry date, it is
+// kept as a batch.
 func StockIn(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 	userID := middleware.GetUserID(c)
@@ -114,27 +136,99 @@ func StockIn(c *fiber.Ctx) error {
 	}
 	defer tx.Rollback()
 
-	// Get current stock
-	var currentStock int
+	// Get current stock, locking the row until the new stock is written
+	var currentStock models.Quantity
+	var cost models.Money
 	var productName string
+	var hasVariants, hasRecipe, isBundle, decimalQuantity, trackStock bool
 	err = tx.QueryRow(`
-		SELECT stock, name FROM products 
+		SELECT stock, cost, name, variant_options IS NOT NULL,
+		       EXISTS(SELECT 1 FROM recipe_items WHERE product_id = products.id),
+		       EXISTS(SELECT 1 FROM bundle_items WHERE bundle_id = products.id),
+		       decimal_quantity, track_stock
+		FROM products 
 		WHERE id = $1 AND store_id = $2 AND is_active = true
-	`, req.ProductID, storeID).Scan(&currentStock, &productName)
+		FOR UPDATE
+	`, req.ProductID, storeID).Scan(&currentStock, &cost, &productName, &hasVariants, &hasRecipe, &isBundle, &decimalQuantity, &trackStock)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"success": false,
 			"error":   "Product not found",
 		})
 	}
+	if hasVariants {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock of " + productName + " is kept per variant",
+		})
+	}
+	if hasRecipe {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock of " + productName + " comes from its recipe ingredients",
+		})
+	}
+	if isBundle {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock of " + productName + " comes from its bundle items",
+		})
+	}
+
+	// Quantities can be given in any of the product's units; stock is kept
+	// in the base unit
+	unit, err := saleUnit(tx, req.ProductID, req.UnitID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := checkQuantity(productName, decimalQuantity, req.Quantity); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if hasBatch(req.BatchNumber, req.ExpiryDate) && !trackStock {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock of " + productName + " is not tracked, so it has no batches",
+		})
+	}
+	quantity := baseQuantity(req.Quantity, unit)
+	notes := req.Notes
+	if unit != nil {
+		note := req.Quantity.String() + " " + unit.Name
+		if notes != nil && *notes != "" {
+			note += ": " + *notes
+		}
+		notes = &note
+	}
+
+	// Costs are kept per base unit. An untracked product's stock figure
+	// means nothing, so it simply takes the price paid.
+	var unitCost *models.Money
+	if req.UnitCost != nil {
+		perBaseUnit := *req.UnitCost
+		if unit != nil {
+			perBaseUnit = baseUnitCost(perBaseUnit, unit.Factor)
+		}
+		unitCost = &perBaseUnit
+		if trackStock {
+			cost = averageCost(currentStock, cost, quantity, perBaseUnit)
+		} else {
+			cost = perBaseUnit
+		}
+	}
 
-	newStock := currentStock + req.Quantity
+	newStock := currentStock + quantity
 
 	// Update product stock
 	_, err = tx.Exec(`
-		UPDATE products SET stock = $1, updated_at = NOW()
-		WHERE id = $2 AND store_id = $3
-	`, newStock, req.ProductID, storeID)
+		UPDATE products SET stock = $1, cost = $2, updated_at = NOW()
+		WHERE id = $3 AND store_id = $4
+	`, newStock, cost, req.ProductID, storeID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
@@ -145,12 +239,12 @@ func StockIn(c *fiber.Ctx) error {
 	// Create stock movement record
 	var movement models.StockMovement
 	err = tx.QueryRow(`
-		INSERT INTO stock_movements (product_id, store_id, type, quantity, stock_before, stock_after, notes, created_by)
-		VALUES ($1, $2, 'in', $3, $4, $5, $6, $7)
-		RETURNING id, product_id, store_id, type, quantity, stock_before, stock_after, notes, created_by, created_at
-	`, req.ProductID, storeID, req.Quantity, currentStock, newStock, req.Notes, userID).Scan(
+		INSERT INTO stock_movements (product_id, store_id, type, quantity, stock_before, stock_after, unit_cost, notes, created_by)
+		VALUES ($1, $2, 'in', $3, $4, $5, $6, $7, $8)
+		RETURNING id, product_id, store_id, type, quantity, stock_before, stock_after, unit_cost, notes, created_by, created_at
+	`, req.ProductID, storeID, quantity, currentStock, newStock, unitCost, notes, userID).Scan(
 		&movement.ID, &movement.ProductID, &movement.StoreID, &movement.Type, &movement.Quantity,
-		&movement.StockBefore, &movement.StockAfter, &movement.Notes, &movement.CreatedBy, &movement.CreatedAt,
+		&movement.StockBefore, &movement.StockAfter, &movement.UnitCost, &movement.Notes, &movement.CreatedBy, &movement.CreatedAt,
 	)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -159,6 +253,13 @@ func StockIn(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := addStockBatch(tx, storeID, req.ProductID, req.BatchNumber, req.ExpiryDate, quantity); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to record batch",
+		})
+	}
+
 	if err := tx.Commit(); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
@@ -175,7 +276,8 @@ func StockIn(c *fiber.Ctx) error {
 	})
 }
 
-// StockOut removes stock from a product
+// StockOut removes stock from a product, out of the batch named or else the
+// one that expires first
 func StockOut(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 	userID := middleware.GetUserID(c)
@@ -204,28 +306,77 @@ func StockOut(c *fiber.Ctx) error {
 	}
 	defer tx.Rollback()
 
-	// Get current stock
-	var currentStock int
+	// Get current stock, locking the row until the new stock is written
+	var currentStock models.Quantity
 	var productName string
+	var hasVariants, hasRecipe, isBundle, decimalQuantity bool
 	err = tx.QueryRow(`
-		SELECT stock, name FROM products 
+		SELECT stock, name, variant_options IS NOT NULL,
+		       EXISTS(SELECT 1 FROM recipe_items WHERE product_id = products.id),
+		       EXISTS(SELECT 1 FROM bundle_items WHERE bundle_id = products.id),
+		       decimal_quantity
+		FROM products 
 		WHERE id = $1 AND store_id = $2 AND is_active = true
-	`, req.ProductID, storeID).Scan(&currentStock, &productName)
+		FOR UPDATE
+	`, req.ProductID, storeID).Scan(&currentStock, &productName, &hasVariants, &hasRecipe, &isBundle, &decimalQuantity)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"success": false,
 			"error":   "Product not found",
 		})
 	}
+	if hasVariants {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock of " + productName + " is kept per variant",
+		})
+	}
+	if hasRecipe {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock of " + productName + " comes from its recipe ingredients",
+		})
+	}
+	if isBundle {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Stock of " + productName + " comes from its bundle items",
+		})
+	}
 
-	if currentStock < req.Quantity {
+	// Quantities can be given in any of the product's units; stock is kept
+	// in the base unit
+	unit, err := saleUnit(tx, req.ProductID, req.UnitID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := checkQuantity(productName, decimalQuantity, req.Quantity); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	quantity := baseQuantity(req.Quantity, unit)
+	notes := req.Notes
+	if unit != nil {
+		note := req.Quantity.String() + " " + unit.Name
+		if notes != nil && *notes != "" {
+			note += ": " + *notes
+		}
+		notes = &note
+	}
+
+	if currentStock < quantity {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
 			"error":   "Insufficient stock",
 		})
 	}
 
-	newStock := currentStock - req.Quantity
+	newStock := currentStock - quantity
 
 	// Update product stock
 	_, err = tx.Exec(`
@@ -239,13 +390,29 @@ func StockOut(c *fiber.Ctx) error {
 		})
 	}
 
+	// Stock pulled from a named batch comes out of that one, anything else
+	// out of the batch that expires first
+	if req.BatchID != nil {
+		if err := takeFromBatch(tx, req.ProductID, *req.BatchID, quantity); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	} else if err := consumeStockBatches(tx, req.ProductID, quantity); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update batches",
+		})
+	}
+
 	// Create stock movement record
 	var movement models.StockMovement
 	err = tx.QueryRow(`
 		INSERT INTO stock_movements (product_id, store_id, type, quantity, stock_before, stock_after, notes, created_by)
 		VALUES ($1, $2, 'out', $3, $4, $5, $6, $7)
 		RETURNING id, product_id, store_id, type, quantity, stock_before, stock_after, notes, created_by, created_at
-	`, req.ProductID, storeID, req.Quantity, currentStock, newStock, req.Notes, userID).Scan(
+	`, req.ProductID, storeID, quantity, currentStock, newStock, notes, userID).Scan(
 		&movement.ID, &movement.ProductID, &movement.StoreID, &movement.Type, &movement.Quantity,
 		&movement.StockBefore, &movement.StockAfter, &movement.Notes, &movement.CreatedBy, &movement.CreatedAt,
 	)
@@ -294,12 +461,12 @@ func GetLowStock(c *fiber.Ctx) error {
 	defer rows.Close()
 
 	type LowStockItem struct {
-		ID       uuid.UUID `json:"id"`
-		Name     string    `json:"name"`
-		Barcode  *string   `json:"barcode"`
-		Stock    int       `json:"stock"`
-		MinStock int       `json:"min_stock"`
-		Unit     string    `json:"unit"`
+		ID       uuid.UUID       `json:"id"`
+		Name     string          `json:"name"`
+		Barcode  *string         `json:"barcode"`
+		Stock    models.Quantity `json:"stock"`
+		MinStock models.Quantity `json:"min_stock"`
+		Unit     string          `json:"unit"`
 	}
 
 	var items []LowStockItem
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // ListStores returns all stores for the current user
@@ -17,7 +18,10 @@ func ListStores(c *fiber.Ctx) error {
 
 	rows, err := database.DB.Query(`
 		SELECT id, user_id, name, address, phone, email, logo_url, 
-		       whatsapp_provider, tax_rate, currency, is_active, created_at, updated_at
+		       whatsapp_provider, tax_rate, negative_stock_policy,
+		       invoice_prefix, invoice_date_format, invoice_padding, invoice_reset,
+		       rounding_increment, rounding_mode, rounding_cash_only,
+		       currency, is_active, created_at, updated_at
 		FROM stores 
 		WHERE user_id = $1 AND is_active = true
 		ORDER BY created_at DESC
@@ -36,7 +40,10 @@ func ListStores(c *fiber.Ctx) error {
 		err := rows.Scan(
 			&store.ID, &store.UserID, &store.Name, &store.Address, &store.Phone,
 			&store.Email, &store.LogoURL, &store.WhatsAppProvider,
-			&store.TaxRate, &store.Currency, &store.IsActive, &store.CreatedAt, &store.UpdatedAt,
+			&store.TaxRate, &store.NegativeStockPolicy,
+			&store.InvoicePrefix, &store.InvoiceDateFormat, &store.InvoicePadding, &store.InvoiceReset,
+			&store.RoundingIncrement, &store.RoundingMode, &store.RoundingCashOnly,
+			&store.Currency, &store.IsActive, &store.CreatedAt, &store.UpdatedAt,
 		)
 		if err != nil {
 			continue
@@ -95,11 +102,17 @@ func CreateStore(c *fiber.Ctx) error {
 		INSERT INTO stores (user_id, name, address, phone, email)
 		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, user_id, name, address, phone, email, logo_url, 
-		          whatsapp_provider, tax_rate, currency, is_active, created_at, updated_at
+		          whatsapp_provider, tax_rate, negative_stock_policy,
+		          invoice_prefix, invoice_date_format, invoice_padding, invoice_reset,
+		          rounding_increment, rounding_mode, rounding_cash_only,
+		          currency, is_active, created_at, updated_at
 	`, userID, req.Name, req.Address, req.Phone, req.Email).Scan(
 		&store.ID, &store.UserID, &store.Name, &store.Address, &store.Phone,
 		&store.Email, &store.LogoURL, &store.WhatsAppProvider,
-		&store.TaxRate, &store.Currency, &store.IsActive, &store.CreatedAt, &store.UpdatedAt,
+		&store.TaxRate, &store.NegativeStockPolicy,
+		&store.InvoicePrefix, &store.InvoiceDateFormat, &store.InvoicePadding, &store.InvoiceReset,
+		&store.RoundingIncrement, &store.RoundingMode, &store.RoundingCashOnly,
+		&store.Currency, &store.IsActive, &store.CreatedAt, &store.UpdatedAt,
 	)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -136,13 +149,19 @@ func GetStore(c *fiber.Ctx) error {
 	var store models.Store
 	err = database.DB.QueryRow(`
 		SELECT id, user_id, name, address, phone, email, logo_url, 
-		       whatsapp_api_key, whatsapp_provider, tax_rate, currency, is_active, created_at, updated_at
+		       whatsapp_api_key, whatsapp_provider, tax_rate, negative_stock_policy,
+		       invoice_prefix, invoice_date_format, invoice_padding, invoice_reset,
+		       rounding_increment, rounding_mode, rounding_cash_only,
+		       currency, is_active, created_at, updated_at
 		FROM stores 
 		WHERE id = $1 AND user_id = $2
 	`, storeUUID, userID).Scan(
 		&store.ID, &store.UserID, &store.Name, &store.Address, &store.Phone,
 		&store.Email, &store.LogoURL, &store.WhatsAppAPIKey, &store.WhatsAppProvider,
-		&store.TaxRate, &store.Currency, &store.IsActive, &store.CreatedAt, &store.UpdatedAt,
+		&store.TaxRate, &store.NegativeStockPolicy,
+		&store.InvoicePrefix, &store.InvoiceDateFormat, &store.InvoicePadding, &store.InvoiceReset,
+		&store.RoundingIncrement, &store.RoundingMode, &store.RoundingCashOnly,
+		&store.Currency, &store.IsActive, &store.CreatedAt, &store.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -159,17 +178,17 @@ func GetStore(c *fiber.Ctx) error {
 
 	// Get store stats
 	var stats struct {
-		ProductCount      int     `json:"product_count"`
-		CustomerCount     int     `json:"customer_count"`
-		TodayTransactions int     `json:"today_transactions"`
-		TodaySales        float64 `json:"today_sales"`
+		ProductCount      int          `json:"product_count"`
+		CustomerCount     int          `json:"customer_count"`
+		TodayTransactions int          `json:"today_transactions"`
+		TodaySales        models.Money `json:"today_sales"`
 	}
 	database.DB.QueryRow(`
 		SELECT 
 			(SELECT COUNT(*) FROM products WHERE store_id = $1 AND is_active = true),
 			(SELECT COUNT(*) FROM customers WHERE store_id = $1 AND is_active = true),
-			(SELECT COUNT(*) FROM transactions WHERE store_id = $1 AND DATE(created_at) = CURRENT_DATE AND status = 'completed'),
-			COALESCE((SELECT SUM(total) FROM transactions WHERE store_id = $1 AND DATE(created_at) = CURRENT_DATE AND status = 'completed'), 0)
+			(SELECT COUNT(*) FROM sales_entries WHERE store_id = $1 AND DATE(occurred_at) = CURRENT_DATE AND is_sale),
+			COALESCE((SELECT SUM(amount) FROM sales_entries WHERE store_id = $1 AND DATE(occurred_at) = CURRENT_DATE), 0)
 	`, storeUUID).Scan(&stats.ProductCount, &stats.CustomerCount, &stats.TodayTransactions, &stats.TodaySales)
 
 	return c.JSON(fiber.Map{
@@ -202,6 +221,13 @@ func UpdateStore(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
 	var store models.Store
 	err = database.DB.QueryRow(`
 		UPDATE stores SET
@@ -213,15 +239,31 @@ func UpdateStore(c *fiber.Ctx) error {
 			whatsapp_api_key = COALESCE($8, whatsapp_api_key),
 			whatsapp_provider = COALESCE($9, whatsapp_provider),
 			tax_rate = COALESCE($10, tax_rate),
+			negative_stock_policy = COALESCE($11, negative_stock_policy),
+			invoice_prefix = COALESCE($12, invoice_prefix),
+			invoice_date_format = COALESCE($13, invoice_date_format),
+			invoice_padding = COALESCE($14, invoice_padding),
+			invoice_reset = COALESCE($15, invoice_reset),
+			rounding_increment = COALESCE($16, rounding_increment),
+			rounding_mode = COALESCE($17, rounding_mode),
+			rounding_cash_only = COALESCE($18, rounding_cash_only),
 			updated_at = NOW()
 		WHERE id = $1 AND user_id = $2
 		RETURNING id, user_id, name, address, phone, email, logo_url, 
-		          whatsapp_provider, tax_rate, currency, is_active, created_at, updated_at
+		          whatsapp_provider, tax_rate, negative_stock_policy,
+		          invoice_prefix, invoice_date_format, invoice_padding, invoice_reset,
+		          rounding_increment, rounding_mode, rounding_cash_only,
+		          currency, is_active, created_at, updated_at
 	`, storeUUID, userID, req.Name, req.Address, req.Phone, req.Email,
-		req.LogoURL, req.WhatsAppAPIKey, req.WhatsAppProvider, req.TaxRate).Scan(
+		req.LogoURL, req.WhatsAppAPIKey, req.WhatsAppProvider, req.TaxRate, req.NegativeStockPolicy,
+		req.InvoicePrefix, req.InvoiceDateFormat, req.InvoicePadding, req.InvoiceReset,
+		req.RoundingIncrement, req.RoundingMode, req.RoundingCashOnly).Scan(
 		&store.ID, &store.UserID, &store.Name, &store.Address, &store.Phone,
 		&store.Email, &store.LogoURL, &store.WhatsAppProvider,
-		&store.TaxRate, &store.Currency, &store.IsActive, &store.CreatedAt, &store.UpdatedAt,
+		&store.TaxRate, &store.NegativeStockPolicy,
+		&store.InvoicePrefix, &store.InvoiceDateFormat, &store.InvoicePadding, &store.InvoiceReset,
+		&store.RoundingIncrement, &store.RoundingMode, &store.RoundingCashOnly,
+		&store.Currency, &store.IsActive, &store.CreatedAt, &store.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -229,6 +271,12 @@ func UpdateStore(c *fiber.Ctx) error {
 			"error":   "Store not found",
 		})
 	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "stores_invoice_reset_date_format" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invoice date format must include the day for daily resets and the month for monthly resets",
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
@@ -285,7 +333,7 @@ func ListCategories(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
 
 	rows, err := database.DB.Query(`
-		SELECT id, store_id, name, color, icon, sort_order, is_active, created_at
+		SELECT id, store_id, name, color, icon, sort_order, is_active, created_at, updated_at
 		FROM categories
 		WHERE store_id = $1 AND is_active = true
 		ORDER BY sort_order ASC, name ASC
@@ -303,7 +351,7 @@ func ListCategories(c *fiber.Ctx) error {
 		var cat models.Category
 		rows.Scan(
 			&cat.ID, &cat.StoreID, &cat.Name, &cat.Color, &cat.Icon,
-			&cat.SortOrder, &cat.IsActive, &cat.CreatedAt,
+			&cat.SortOrder, &cat.IsActive, &cat.CreatedAt, &cat.UpdatedAt,
 		)
 		categories = append(categories, cat)
 	}
@@ -338,10 +386,10 @@ func CreateCategory(c *fiber.Ctx) error {
 	err := database.DB.QueryRow(`
 		INSERT INTO categories (store_id, name, color, icon)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, store_id, name, color, icon, sort_order, is_active, created_at
+		RETURNING id, store_id, name, color, icon, sort_order, is_active, created_at, updated_at
 	`, storeID, req.Name, req.Color, req.Icon).Scan(
 		&cat.ID, &cat.StoreID, &cat.Name, &cat.Color, &cat.Icon,
-		&cat.SortOrder, &cat.IsActive, &cat.CreatedAt,
+		&cat.SortOrder, &cat.IsActive, &cat.CreatedAt, &cat.UpdatedAt,
 	)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -373,12 +421,20 @@ func ResetStoreData(c *fiber.Ctx) error {
 	queries := []string{
 		"DELETE FROM transaction_items WHERE transaction_id IN (SELECT id FROM transactions WHERE store_id = $1)",
 		"DELETE FROM transactions WHERE store_id = $1",
+		"DELETE FROM shifts WHERE store_id = $1",
 		"DELETE FROM stock_movements WHERE store_id = $1",
+		"DELETE FROM stock_opnames WHERE store_id = $1",
+		"DELETE FROM purchase_orders WHERE store_id = $1",
+		"DELETE FROM suppliers WHERE store_id = $1",
 		"DELETE FROM products WHERE store_id = $1",
 		"DELETE FROM categories WHERE store_id = $1",
 		"DELETE FROM customers WHERE store_id = $1",
 		"DELETE FROM whatsapp_logs WHERE store_id = $1",
 		"DELETE FROM promos WHERE store_id = $1",
+		"DELETE FROM modifier_groups WHERE store_id = $1",
+		"DELETE FROM tax_rules WHERE store_id = $1",
+		"DELETE FROM scale_barcode_patterns WHERE store_id = $1",
+		"DELETE FROM invoice_sequences WHERE store_id = $1",
 		"DELETE FROM audit_logs WHERE store_id = $1",
 	}
 
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"database/sql"
+	"strconv"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ListSuppliers returns all suppliers for a store
+func ListSuppliers(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	perPage, _ := strconv.Atoi(c.Query("per_page", "50"))
+	search := c.Query("search", "")
+
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > 100 {
+		perPage = 50
+	}
+	offset := (page - 1) * perPage
+
+	query := `
+		SELECT id, store_id, name, contact_name, phone, email, address, notes,
+		       is_active, created_at, updated_at
+		FROM suppliers
+		WHERE store_id = $1 AND is_active = true
+	`
+	countQuery := `SELECT COUNT(*) FROM suppliers WHERE store_id = $1 AND is_active = true`
+	args := []interface{}{storeID}
+
+	if search != "" {
+		searchArg := "%" + search + "%"
+		query += " AND (name ILIKE $2 OR contact_name ILIKE $2 OR phone ILIKE $2 OR email ILIKE $2)"
+		countQuery += " AND (name ILIKE $2 OR contact_name ILIKE $2 OR phone ILIKE $2 OR email ILIKE $2)"
+		args = append(args, searchArg)
+	}
+
+	var total int
+	database.DB.QueryRow(countQuery, args...).Scan(&total)
+
+	query += " ORDER BY name ASC LIMIT $" + strconv.Itoa(len(args)+1) + " OFFSET $" + strconv.Itoa(len(args)+2)
+	args = append(args, perPage, offset)
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch suppliers",
+		})
+	}
+	defer rows.Close()
+
+	var suppliers []models.Supplier
+	for rows.Next() {
+		var s models.Supplier
+		rows.Scan(
+			&s.ID, &s.StoreID, &s.Name, &s.ContactName, &s.Phone, &s.Email,
+			&s.Address, &s.Notes, &s.IsActive, &s.CreatedAt, &s.UpdatedAt,
+		)
+		suppliers = append(suppliers, s)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": models.PaginatedResponse{
+			Data:       suppliers,
+			Page:       page,
+			PerPage:    perPage,
+			Total:      total,
+			TotalPages: (total + perPage - 1) / perPage,
+		},
+	})
+}
+
+// GetSupplier returns a specific supplier with its purchase orders
+func GetSupplier(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	supplierUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid supplier ID",
+		})
+	}
+
+	var s models.Supplier
+	err = database.DB.QueryRow(`
+		SELECT id, store_id, name, contact_name, phone, email, address, notes,
+		       is_active, created_at, updated_at
+		FROM suppliers
+		WHERE id = $1 AND store_id = $2
+	`, supplierUUID, storeID).Scan(
+		&s.ID, &s.StoreID, &s.Name, &s.ContactName, &s.Phone, &s.Email,
+		&s.Address, &s.Notes, &s.IsActive, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Supplier not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch supplier",
+		})
+	}
+
+	orders, err := queryPurchaseOrders(database.DB, `po.supplier_id = $1 AND po.store_id = $2`, supplierUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch purchase orders",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"supplier":        s,
+			"purchase_orders": orders,
+		},
+	})
+}
+
+// CreateSupplier creates a new supplier
+func CreateSupplier(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	var req models.CreateSupplierRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	var s models.Supplier
+	err := database.DB.QueryRow(`
+		INSERT INTO suppliers (store_id, name, contact_name, phone, email, address, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, store_id, name, contact_name, phone, email, address, notes,
+		          is_active, created_at, updated_at
+	`, storeID, req.Name, req.ContactName, req.Phone, req.Email, req.Address, req.Notes).Scan(
+		&s.ID, &s.StoreID, &s.Name, &s.ContactName, &s.Phone, &s.Email,
+		&s.Address, &s.Notes, &s.IsActive, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create supplier",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    s,
+	})
+}
+
+// UpdateSupplier updates a supplier
+func UpdateSupplier(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	supplierUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid supplier ID",
+		})
+	}
+
+	var req models.UpdateSupplierRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	var s models.Supplier
+	err = database.DB.QueryRow(`
+		UPDATE suppliers SET
+			name = COALESCE($3, name),
+			contact_name = COALESCE($4, contact_name),
+			phone = COALESCE($5, phone),
+			email = COALESCE($6, email),
+			address = COALESCE($7, address),
+			notes = COALESCE($8, notes),
+			is_active = COALESCE($9, is_active),
+			updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+		RETURNING id, store_id, name, contact_name, phone, email, address, notes,
+		          is_active, created_at, updated_at
+	`, supplierUUID, storeID, req.Name, req.ContactName, req.Phone, req.Email, req.Address, req.Notes, req.IsActive).Scan(
+		&s.ID, &s.StoreID, &s.Name, &s.ContactName, &s.Phone, &s.Email,
+		&s.Address, &s.Notes, &s.IsActive, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Supplier not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update supplier",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    s,
+	})
+}
+
+// DeleteSupplier soft-deletes a supplier. Its purchase orders are kept.
+func DeleteSupplier(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	supplierUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid supplier ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE suppliers SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+	`, supplierUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete supplier",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Supplier not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Supplier deleted successfully",
+	})
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"kasirku/internal/database"
+	"kasirku/internal/middleware"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// syncCursorOverlap is taken off the returned cursor so rows written by
+// transactions still in flight during a pull are picked up by the next one.
+// Terminals upsert by id, so seeing a row twice is harmless.
+const syncCursorOverlap = time.Minute
+
+// SyncPull returns the products, categories, customers, tax rules and
+// modifier groups changed since the given cursor. Inactive rows are included
+// so terminals can drop them.
+func SyncPull(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	since := time.Time{}
+	if cursor := c.Query("since", ""); cursor != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, cursor)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid since cursor, expected RFC3339 timestamp",
+			})
+		}
+		since = parsed
+	}
+
+	var serverTime time.Time
+	if err := database.DB.QueryRow(`SELECT NOW()`).Scan(&serverTime); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	productRows, err := database.DB.Query(`
+		SELECT p.id, p.store_id, p.category_id, p.name, p.barcode, p.sku, p.description,
+		       p.price, p.cost, p.stock, p.min_stock, p.unit, p.image_url, p.is_active,
+		       p.track_stock, p.decimal_quantity, p.parent_id, p.variant_options, p.option_values,
+		       p.created_at, p.updated_at, c.name as category_name
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.id
+		WHERE p.store_id = $1 AND p.updated_at > $2
+		ORDER BY p.updated_at ASC
+	`, storeID, since)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch products",
+		})
+	}
+	defer productRows.Close()
+
+	products := []models.Product{}
+	for productRows.Next() {
+		var p models.Product
+		productRows.Scan(
+			&p.ID, &p.StoreID, &p.CategoryID, &p.Name, &p.Barcode, &p.SKU, &p.Description,
+			&p.Price, &p.Cost, &p.Stock, &p.MinStock, &p.Unit, &p.ImageURL, &p.IsActive,
+			&p.TrackStock, &p.DecimalQuantity, &p.ParentID, &p.VariantOptions, &p.OptionValues,
+			&p.CreatedAt, &p.UpdatedAt, &p.CategoryName,
+		)
+		products = append(products, p)
+	}
+	if err := attachUnits(database.DB, products); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product units",
+		})
+	}
+
+	categoryRows, err := database.DB.Query(`
+		SELECT id, store_id, name, color, icon, sort_order, is_active, created_at, updated_at
+		FROM categories
+		WHERE store_id = $1 AND updated_at > $2
+		ORDER BY updated_at ASC
+	`, storeID, since)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch categories",
+		})
+	}
+	defer categoryRows.Close()
+
+	categories := []models.Category{}
+	for categoryRows.Next() {
+		var cat models.Category
+		categoryRows.Scan(
+			&cat.ID, &cat.StoreID, &cat.Name, &cat.Color, &cat.Icon,
+			&cat.SortOrder, &cat.IsActive, &cat.CreatedAt, &cat.UpdatedAt,
+		)
+		categories = append(categories, cat)
+	}
+
+	customerRows, err := database.DB.Query(`
+		SELECT id, store_id, name, phone, email, address, notes,
+		       total_transactions, total_spent, last_transaction_at, is_active, created_at, updated_at
+		FROM customers
+		WHERE store_id = $1 AND updated_at > $2
+		ORDER BY updated_at ASC
+	`, storeID, since)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch customers",
+		})
+	}
+	defer customerRows.Close()
+
+	customers := []models.Customer{}
+	for customerRows.Next() {
+		var cust models.Customer
+		customerRows.Scan(
+			&cust.ID, &cust.StoreID, &cust.Name, &cust.Phone, &cust.Email,
+			&cust.Address, &cust.Notes, &cust.TotalTransactions, &cust.TotalSpent,
+			&cust.LastTransactionAt, &cust.IsActive, &cust.CreatedAt, &cust.UpdatedAt,
+		)
+		customers = append(customers, cust)
+	}
+
+	taxRules, err := queryTaxRules(database.DB, `store_id = $1 AND updated_at > $2`, storeID, since)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch tax rules",
+		})
+	}
+
+	modifierGroups, err := queryModifierGroups(database.DB, `store_id = $1 AND updated_at > $2`, storeID, since)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch modifier groups",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"products":        products,
+			"categories":      categories,
+			"customers":       customers,
+			"tax_rules":       taxRules,
+			"modifier_groups": modifierGroups,
+		},
+		"cursor":      serverTime.Add(-syncCursorOverlap).Format(time.RFC3339Nano),
+		"server_time": serverTime,
+	})
+}
+
+// SyncPush replays a batch of offline sales through the same path as
+// CreateTransaction. Each sale is committed on its own, so one rejected sale
+// doesn't hold back the rest of the batch.
+func SyncPush(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+
+	var req models.SyncPushRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	results := make([]models.SyncSaleResult, 0, len(req.Sales))
+	var created, duplicates, rejected int
+	for _, s := range req.Sales {
+		result := syncSale(storeID, userID, s)
+		switch result.Status {
+		case "created":
+			created++
+		case "duplicate":
+			duplicates++
+		default:
+			rejected++
+		}
+		results = append(results, result)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    results,
+		"summary": fiber.Map{
+			"created":   created,
+			"duplicate": duplicates,
+			"rejected":  rejected,
+		},
+	})
+}
+
+// syncSale writes one offline sale in its own database transaction
+func syncSale(storeID, userID uuid.UUID, s models.SyncSaleRequest) models.SyncSaleResult {
+	result := models.SyncSaleResult{ClientTransactionID: s.ClientTransactionID, Status: "rejected"}
+	if s.ClientTransactionID == nil {
+		result.Error = "client_transaction_id is required"
+		return result
+	}
+	key := s.ClientTransactionID.String()
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		result.Error = "Database error"
+		return result
+	}
+	defer tx.Rollback()
+
+	_, replayBody, err := claimIdempotencyKey(tx, storeID, key)
+	if err != nil {
+		result.Error = "Database error"
+		return result
+	}
+	if replayBody != nil {
+		var replay struct {
+			Data *models.Transaction `json:"data"`
+		}
+		if json.Unmarshal(replayBody, &replay) == nil && replay.Data != nil {
+			result.Status = "duplicate"
+			result.TransactionID = &replay.Data.ID
+			result.InvoiceNumber = &replay.Data.InvoiceNumber
+		} else {
+			result.Error = "Sale is still being processed"
+		}
+		return result
+	}
+
+	// Report every conflicting item at once instead of the first one
+	// priceSale would stop at
+	if conflicts := findSaleConflicts(tx, storeID, s.Items); len(conflicts) > 0 {
+		result.Error = "Some items can no longer be sold"
+		result.Conflicts = conflicts
+		return result
+	}
+
+	transaction, err := createSale(tx, storeID, userID, s.CreateTransactionRequest)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// Keep the time the sale actually happened, as long as it isn't in the
+	// future, next to the push time rather than over it: the invoice number
+	// and shift were taken when it was pushed, and reports date it the same
+	if s.SoldAt != nil && s.SoldAt.Before(time.Now()) {
+		if err := tx.QueryRow(`
+			UPDATE transactions SET sold_at = $2 WHERE id = $1
+			RETURNING sold_at
+		`, transaction.ID, s.SoldAt).Scan(&transaction.SoldAt); err != nil {
+			result.Error = "Failed to record sale time"
+			return result
+		}
+	}
+
+	response := marshalResponse(fiber.Map{
+		"success": true,
+		"data":    transaction,
+		"message": "Transaction completed successfully",
+	})
+	if err := saveIdempotentResponse(tx, storeID, key, transaction.ID, fiber.StatusCreated, response); err != nil {
+		result.Error = "Failed to complete transaction"
+		return result
+	}
+
+	if err := tx.Commit(); err != nil {
+		result.Error = "Failed to complete transaction"
+		return result
+	}
+
+	result.Status = "created"
+	result.TransactionID = &transaction.ID
+	result.InvoiceNumber = &transaction.InvoiceNumber
+	return result
+}
+
+// findSaleConflicts checks every cart item against current product data.
+// Short stock only conflicts when the store blocks negative stock.
+func findSaleConflicts(tx *sql.Tx, storeID uuid.UUID, items []models.CreateTransactionItemRequest) []models.SyncConflict {
+	stockPolicy := "block"
+	tx.QueryRow(`
+		SELECT COALESCE(negative_stock_policy, 'block') FROM stores WHERE id = $1
+	`, storeID).Scan(&stockPolicy)
+
+	requested := map[uuid.UUID]models.Quantity{}
+	var order []uuid.UUID
+	for _, item := range items {
+		if _, seen := requested[item.ProductID]; !seen {
+			order = append(order, item.ProductID)
+			requested[item.ProductID] = 0
+		}
+		// An unknown unit is left for priceSale to reject
+		unit, err := saleUnit(tx, item.ProductID, item.UnitID)
+		if err != nil {
+			continue
+		}
+		requested[item.ProductID] += baseQuantity(item.Quantity, unit)
+	}
+
+	var conflicts []models.SyncConflict
+	for _, productID := range order {
+		conflict := models.SyncConflict{ProductID: productID, Requested: requested[productID]}
+
+		// Bundles and composite products are left to priceSale, which checks
+		// their components and ingredients
+		var isActive, trackStock, hasRecipe bool
+		err := tx.QueryRow(`
+			SELECT name, stock, is_active, track_stock,
+			       EXISTS(SELECT 1 FROM recipe_items WHERE product_id = products.id)
+			       OR EXISTS(SELECT 1 FROM bundle_items WHERE bundle_id = products.id)
+			FROM products
+			WHERE id = $1 AND store_id = $2
+		`, productID, storeID).Scan(&conflict.ProductName, &conflict.Available, &isActive, &trackStock, &hasRecipe)
+		switch {
+		case err != nil:
+			conflict.Reason = "not_found"
+		case !isActive:
+			conflict.Reason = "inactive"
+		case stockPolicy == "block" && trackStock && !hasRecipe && conflict.Available < conflict.Requested:
+			conflict.Reason = "insufficient_stock"
+		default:
+			continue
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
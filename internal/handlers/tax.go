@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const taxRuleColumns = `id, store_id, name, type, rate, is_inclusive, sort_order, is_active, created_at, updated_at`
+
+// queryTaxRules returns the tax rules matching where, with their exemptions
+func queryTaxRules(q queryer, where string, args ...interface{}) ([]models.TaxRule, error) {
+	rows, err := q.Query(`
+		SELECT `+taxRuleColumns+` FROM tax_rules
+		WHERE `+where+`
+		ORDER BY sort_order ASC, name ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	rules := []models.TaxRule{}
+	index := map[uuid.UUID]int{}
+	var ids []string
+	for rows.Next() {
+		r := models.TaxRule{ExemptProductIDs: []uuid.UUID{}, ExemptCategoryIDs: []uuid.UUID{}}
+		if err := rows.Scan(
+			&r.ID, &r.StoreID, &r.Name, &r.Type, &r.Rate, &r.IsInclusive,
+			&r.SortOrder, &r.IsActive, &r.CreatedAt, &r.UpdatedAt,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[r.ID] = len(rules)
+		ids = append(ids, r.ID.String())
+		rules = append(rules, r)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return rules, nil
+	}
+
+	exemptRows, err := q.Query(`
+		SELECT tax_rule_id, product_id, category_id FROM tax_rule_exemptions
+		WHERE tax_rule_id = ANY($1::uuid[])
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer exemptRows.Close()
+	for exemptRows.Next() {
+		var ruleID uuid.UUID
+		var productID, categoryID *uuid.UUID
+		exemptRows.Scan(&ruleID, &productID, &categoryID)
+		r := &rules[index[ruleID]]
+		if productID != nil {
+			r.ExemptProductIDs = append(r.ExemptProductIDs, *productID)
+		}
+		if categoryID != nil {
+			r.ExemptCategoryIDs = append(r.ExemptCategoryIDs, *categoryID)
+		}
+	}
+	return rules, nil
+}
+
+// ListTaxRules returns the tax and service charge rules of a store
+func ListTaxRules(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	where := `store_id = $1`
+	if c.QueryBool("active", false) {
+		where += ` AND is_active = true`
+	}
+
+	rules, err := queryTaxRules(database.DB, where, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch tax rules",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    rules,
+	})
+}
+
+// CreateTaxRule creates a tax or service charge rule
+func CreateTaxRule(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	var req models.CreateTaxRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	ruleType := req.Type
+	if ruleType == "" {
+		ruleType = "tax"
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var ruleID uuid.UUID
+	err = tx.QueryRow(`
+		INSERT INTO tax_rules (store_id, name, type, rate, is_inclusive, sort_order)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, storeID, req.Name, ruleType, req.Rate, req.IsInclusive, req.SortOrder).Scan(&ruleID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create tax rule: " + err.Error(),
+		})
+	}
+
+	if err := setTaxRuleExemptions(tx, storeID, ruleID, &req.ExemptProductIDs, &req.ExemptCategoryIDs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	rules, err := queryTaxRules(tx, `id = $1`, ruleID)
+	if err != nil || len(rules) == 0 {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch tax rule",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create tax rule",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    rules[0],
+	})
+}
+
+// UpdateTaxRule updates a tax rule
+func UpdateTaxRule(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	ruleUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid tax rule ID",
+		})
+	}
+
+	var req models.UpdateTaxRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE tax_rules SET
+			name = COALESCE($3, name),
+			rate = COALESCE($4, rate),
+			is_inclusive = COALESCE($5, is_inclusive),
+			sort_order = COALESCE($6, sort_order),
+			is_active = COALESCE($7, is_active),
+			updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+	`, ruleUUID, storeID, req.Name, req.Rate, req.IsInclusive, req.SortOrder, req.IsActive)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update tax rule",
+		})
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Tax rule not found",
+		})
+	}
+
+	if err := setTaxRuleExemptions(tx, storeID, ruleUUID, req.ExemptProductIDs, req.ExemptCategoryIDs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	rules, err := queryTaxRules(tx, `id = $1`, ruleUUID)
+	if err != nil || len(rules) == 0 {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch tax rule",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update tax rule",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    rules[0],
+	})
+}
+
+// DeleteTaxRule deactivates a tax rule. Past transactions keep their copy of
+// the tax lines either way.
+func DeleteTaxRule(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	ruleUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid tax rule ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE tax_rules SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND store_id = $2
+	`, ruleUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete tax rule",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Tax rule not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Tax rule deleted successfully",
+	})
+}
+
+// setTaxRuleExemptions replaces the product and category exemptions of a
+// rule. A nil list leaves that kind of exemption as it is.
+func setTaxRuleExemptions(tx *sql.Tx, storeID, ruleID uuid.UUID, productIDs, categoryIDs *[]uuid.UUID) error {
+	replace := func(column, table string, ids *[]uuid.UUID) error {
+		if ids == nil {
+			return nil
+		}
+		if _, err := tx.Exec(`
+			DELETE FROM tax_rule_exemptions WHERE tax_rule_id = $1 AND `+column+` IS NOT NULL
+		`, ruleID); err != nil {
+			return err
+		}
+		if len(*ids) == 0 {
+			return nil
+		}
+
+		unique := map[uuid.UUID]bool{}
+		var idStrings []string
+		for _, id := range *ids {
+			if !unique[id] {
+				unique[id] = true
+				idStrings = append(idStrings, id.String())
+			}
+		}
+		result, err := tx.Exec(`
+			INSERT INTO tax_rule_exemptions (tax_rule_id, `+column+`)
+			SELECT $1, id FROM `+table+`
+			WHERE store_id = $2 AND id = ANY($3::uuid[])
+		`, ruleID, storeID, pq.Array(idStrings))
+		if err != nil {
+			return err
+		}
+		if inserted, _ := result.RowsAffected(); int(inserted) != len(idStrings) {
+			return fmt.Errorf("Some exempt %s were not found", table)
+		}
+		return nil
+	}
+
+	if err := replace("product_id", "products", productIDs); err != nil {
+		return err
+	}
+	return replace("category_id", "categories", categoryIDs)
+}
+
+// saleTaxRules returns the active tax rules used to price a sale. Stores
+// without any fall back to their single exclusive tax_rate.
+func saleTaxRules(tx *sql.Tx, storeID uuid.UUID, legacyRate float64) ([]models.TaxRule, error) {
+	rules, err := queryTaxRules(tx, `store_id = $1 AND is_active = true`, storeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 && legacyRate > 0 {
+		rules = []models.TaxRule{{Name: "Pajak", Type: "tax", Rate: legacyRate}}
+	}
+	return rules, nil
+}
+
+// taxApplies reports whether a rule applies to a cart line
+func taxApplies(rule models.TaxRule, line saleLine) bool {
+	for _, id := range rule.ExemptProductIDs {
+		if id == line.ProductID {
+			return false
+		}
+	}
+	if line.CategoryID != nil {
+		for _, id := range rule.ExemptCategoryIDs {
+			if id == *line.CategoryID {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyTaxes works out the tax and service charge lines of a priced sale.
+// The cart discount is spread over the lines pro rata. Inclusive rules are
+// taken out of the line price; exclusive service charges are added on that
+// net price, and exclusive taxes on the net price plus service charge.
+func (s *sale) applyTaxes(rules []models.TaxRule) {
+	s.Taxes = nil
+	s.ServiceCharge = 0
+	s.Tax = 0
+	if len(rules) == 0 {
+		return
+	}
+
+	taxable := make([]models.Money, len(rules))
+	amounts := make([]models.Money, len(rules))
+	applied := make([]bool, len(rules))
+	discounted := s.Subtotal - s.Discount
+
+	for _, line := range s.Lines {
+		net := line.ItemSubtotal
+		if s.Subtotal > 0 {
+			net = line.ItemSubtotal.MulDiv(int64(discounted), int64(s.Subtotal))
+		}
+
+		var inclusiveRate float64
+		for i, rule := range rules {
+			applied[i] = taxApplies(rule, line)
+			if applied[i] && rule.IsInclusive {
+				inclusiveRate += rule.Rate
+			}
+		}
+
+		// Take inclusive taxes out of the price. The last one gets any
+		// rounding remainder so the parts add back up to the price.
+		base := net
+		if inclusiveRate > 0 {
+			base = net.MulDiv(100*100, int64(models.MoneyFromFloat(100+inclusiveRate)))
+			remainder, last := net-base, -1
+			for i, rule := range rules {
+				if applied[i] && rule.IsInclusive {
+					amount := base.Percent(rule.Rate)
+					taxable[i] += base
+					amounts[i] += amount
+					remainder -= amount
+					last = i
+				}
+			}
+			amounts[last] += remainder
+		}
+
+		var serviceCharge models.Money
+		for i, rule := range rules {
+			if applied[i] && !rule.IsInclusive && rule.Type == "service_charge" {
+				amount := base.Percent(rule.Rate)
+				taxable[i] += base
+				amounts[i] += amount
+				serviceCharge += amount
+			}
+		}
+		for i, rule := range rules {
+			if applied[i] && !rule.IsInclusive && rule.Type == "tax" {
+				taxable[i] += base + serviceCharge
+				amounts[i] += (base + serviceCharge).Percent(rule.Rate)
+			}
+		}
+	}
+
+	for i, rule := range rules {
+		if taxable[i] == 0 && amounts[i] == 0 {
+			continue
+		}
+		line := models.TransactionTax{
+			Name:          rule.Name,
+			Type:          rule.Type,
+			Rate:          rule.Rate,
+			IsInclusive:   rule.IsInclusive,
+			TaxableAmount: taxable[i],
+			Amount:        amounts[i],
+		}
+		if rule.ID != uuid.Nil {
+			ruleID := rule.ID
+			line.TaxRuleID = &ruleID
+		}
+		s.Taxes = append(s.Taxes, line)
+
+		if rule.IsInclusive {
+			continue
+		}
+		if rule.Type == "service_charge" {
+			s.ServiceCharge += amounts[i]
+		} else {
+			s.Tax += amounts[i]
+		}
+	}
+}
+
+// insertSaleTaxes writes the tax lines of a transaction
+func insertSaleTaxes(tx *sql.Tx, transactionID uuid.UUID, taxes []models.TransactionTax) ([]models.TransactionTax, error) {
+	var result []models.TransactionTax
+	for _, t := range taxes {
+		err := tx.QueryRow(`
+			INSERT INTO transaction_taxes (transaction_id, tax_rule_id, name, type, rate, is_inclusive, taxable_amount, amount)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id
+		`, transactionID, t.TaxRuleID, t.Name, t.Type, t.Rate, t.IsInclusive, t.TaxableAmount, t.Amount).Scan(&t.ID)
+		if err != nil {
+			return nil, err
+		}
+		t.TransactionID = transactionID
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// loadTransactionTaxes returns the tax lines of a transaction
+func loadTransactionTaxes(transactionID uuid.UUID) []models.TransactionTax {
+	var taxes []models.TransactionTax
+	rows, err := database.DB.Query(`
+		SELECT id, transaction_id, tax_rule_id, name, type, rate, is_inclusive, taxable_amount, amount
+		FROM transaction_taxes
+		WHERE transaction_id = $1
+		ORDER BY created_at ASC
+	`, transactionID)
+	if err != nil {
+		return taxes
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.TransactionTax
+		rows.Scan(&t.ID, &t.TransactionID, &t.TaxRuleID, &t.Name, &t.Type, &t.Rate,
+			&t.IsInclusive, &t.TaxableAmount, &t.Amount)
+		taxes = append(taxes, t)
+	}
+	return taxes
+}
@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"fmt"
 	"strconv"
-	"time"
 
 	"kasirku/internal/database"
 	"kasirku/internal/middleware"
@@ -12,6 +11,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // ListTransactions returns transactions for a store
@@ -35,8 +35,8 @@ func ListTransactions(c *fiber.Ctx) error {
 
 	query := `
 		SELECT t.id, t.store_id, t.customer_id, t.cashier_id, t.invoice_number,
-		       t.subtotal, t.discount_amount, t.discount_percent, t.tax_amount, t.total,
-		       t.payment_amount, t.change_amount, t.payment_type, t.status, t.notes,
+		       t.subtotal, t.discount_amount, t.discount_percent, t.service_charge, t.tax_amount, t.total,
+		       t.payment_amount, t.change_amount, t.payment_type, t.status, t.refunded_amount, t.notes,
 		       t.created_at, t.updated_at, c.name as customer_name, u.full_name as cashier_name
 		FROM transactions t
 		LEFT JOIN customers c ON t.customer_id = c.id
@@ -49,16 +49,16 @@ func ListTransactions(c *fiber.Ctx) error {
 
 	if dateFrom != "" {
 		argCount++
-		query += fmt.Sprintf(" AND DATE(t.created_at AT TIME ZONE $%d) >= $%d", argCount, argCount+1)
-		countQuery += fmt.Sprintf(" AND DATE(created_at AT TIME ZONE $%d) >= $%d", argCount, argCount+1)
+		query += fmt.Sprintf(" AND DATE(COALESCE(t.completed_at, t.created_at) AT TIME ZONE $%d) >= $%d", argCount, argCount+1)
+		countQuery += fmt.Sprintf(" AND DATE(COALESCE(completed_at, created_at) AT TIME ZONE $%d) >= $%d", argCount, argCount+1)
 		args = append(args, timezone, dateFrom)
 		argCount++
 	}
 
 	if dateTo != "" {
 		argCount++
-		query += fmt.Sprintf(" AND DATE(t.created_at AT TIME ZONE $%d) <= $%d", argCount, argCount+1)
-		countQuery += fmt.Sprintf(" AND DATE(created_at AT TIME ZONE $%d) <= $%d", argCount, argCount+1)
+		query += fmt.Sprintf(" AND DATE(COALESCE(t.completed_at, t.created_at) AT TIME ZONE $%d) <= $%d", argCount, argCount+1)
+		countQuery += fmt.Sprintf(" AND DATE(COALESCE(completed_at, created_at) AT TIME ZONE $%d) <= $%d", argCount, argCount+1)
 		args = append(args, timezone, dateTo)
 		argCount++
 	}
@@ -90,8 +90,8 @@ func ListTransactions(c *fiber.Ctx) error {
 		var t models.Transaction
 		rows.Scan(
 			&t.ID, &t.StoreID, &t.CustomerID, &t.CashierID, &t.InvoiceNumber,
-			&t.Subtotal, &t.DiscountAmount, &t.DiscountPercent, &t.TaxAmount, &t.Total,
-			&t.PaymentAmount, &t.ChangeAmount, &t.PaymentType, &t.Status, &t.Notes,
+			&t.Subtotal, &t.DiscountAmount, &t.DiscountPercent, &t.ServiceCharge, &t.TaxAmount, &t.Total,
+			&t.PaymentAmount, &t.ChangeAmount, &t.PaymentType, &t.Status, &t.RefundedAmount, &t.Notes,
 			&t.CreatedAt, &t.UpdatedAt, &t.CustomerName, &t.CashierName,
 		)
 		transactions = append(transactions, t)
@@ -125,9 +125,10 @@ func GetTransaction(c *fiber.Ctx) error {
 	var t models.Transaction
 	err = database.DB.QueryRow(`
 		SELECT t.id, t.store_id, t.customer_id, t.cashier_id, t.invoice_number,
-		       t.subtotal, t.discount_amount, t.discount_percent, t.tax_amount, t.total,
+		       t.subtotal, t.discount_amount, t.discount_percent, t.service_charge, t.tax_amount, t.rounding_adjustment, t.total,
 		       t.payment_amount, t.change_amount, t.payment_type, t.payment_reference,
-		       t.status, t.notes, t.created_at, t.updated_at,
+		       t.status, t.refunded_amount, t.promo_id, t.promo_discount,
+		       t.notes, t.created_at, t.completed_at, t.sold_at, t.updated_at,
 		       c.name as customer_name, u.full_name as cashier_name
 		FROM transactions t
 		LEFT JOIN customers c ON t.customer_id = c.id
@@ -135,9 +136,10 @@ func GetTransaction(c *fiber.Ctx) error {
 		WHERE t.id = $1 AND t.store_id = $2
 	`, txUUID, storeID).Scan(
 		&t.ID, &t.StoreID, &t.CustomerID, &t.CashierID, &t.InvoiceNumber,
-		&t.Subtotal, &t.DiscountAmount, &t.DiscountPercent, &t.TaxAmount, &t.Total,
+		&t.Subtotal, &t.DiscountAmount, &t.DiscountPercent, &t.ServiceCharge, &t.TaxAmount, &t.RoundingAdjustment, &t.Total,
 		&t.PaymentAmount, &t.ChangeAmount, &t.PaymentType, &t.PaymentReference,
-		&t.Status, &t.Notes, &t.CreatedAt, &t.UpdatedAt,
+		&t.Status, &t.RefundedAmount, &t.PromoID, &t.PromoDiscount,
+		&t.Notes, &t.CreatedAt, &t.CompletedAt, &t.SoldAt, &t.UpdatedAt,
 		&t.CustomerName, &t.CashierName,
 	)
 	if err == sql.ErrNoRows {
@@ -153,31 +155,71 @@ func GetTransaction(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get transaction items
+	t.Items = loadTransactionItems(txUUID)
+	t.Payments = loadTransactionPayments(txUUID)
+	t.Taxes = loadTransactionTaxes(txUUID)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    t,
+	})
+}
+
+// loadTransactionItems returns the items of a transaction with their modifiers
+func loadTransactionItems(transactionID uuid.UUID) []models.TransactionItem {
+	var items []models.TransactionItem
 	rows, err := database.DB.Query(`
 		SELECT id, transaction_id, product_id, product_name, product_price,
-		       quantity, discount_amount, discount_percent, subtotal, cost
+		       quantity, discount_amount, discount_percent, subtotal, cost, refunded_quantity,
+		       modifier_amount, unit_id, unit_name, unit_factor
 		FROM transaction_items
 		WHERE transaction_id = $1
 		ORDER BY created_at ASC
-	`, txUUID)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var item models.TransactionItem
-			rows.Scan(
-				&item.ID, &item.TransactionID, &item.ProductID, &item.ProductName,
-				&item.ProductPrice, &item.Quantity, &item.DiscountAmount,
-				&item.DiscountPercent, &item.Subtotal, &item.Cost,
-			)
-			t.Items = append(t.Items, item)
-		}
+	`, transactionID)
+	if err != nil {
+		return items
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.TransactionItem
+		rows.Scan(
+			&item.ID, &item.TransactionID, &item.ProductID, &item.ProductName,
+			&item.ProductPrice, &item.Quantity, &item.DiscountAmount,
+			&item.DiscountPercent, &item.Subtotal, &item.Cost, &item.RefundedQuantity,
+			&item.ModifierAmount, &item.UnitID, &item.UnitName, &item.UnitFactor,
+		)
+		items = append(items, item)
 	}
+	rows.Close()
 
-	return c.JSON(fiber.Map{
-		"success": true,
-		"data":    t,
-	})
+	modifiers := loadItemModifiers(database.DB, transactionID)
+	components := loadItemComponents(database.DB, transactionID)
+	for i := range items {
+		items[i].Modifiers = modifiers[items[i].ID]
+		items[i].Components = components[items[i].ID]
+	}
+	return items
+}
+
+// loadTransactionPayments returns the payment lines of a transaction
+func loadTransactionPayments(transactionID uuid.UUID) []models.TransactionPayment {
+	var payments []models.TransactionPayment
+	rows, err := database.DB.Query(`
+		SELECT id, transaction_id, payment_type, amount, reference, created_at
+		FROM transaction_payments
+		WHERE transaction_id = $1
+		ORDER BY created_at ASC
+	`, transactionID)
+	if err != nil {
+		return payments
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.TransactionPayment
+		rows.Scan(&p.ID, &p.TransactionID, &p.PaymentType, &p.Amount, &p.Reference, &p.CreatedAt)
+		payments = append(payments, p)
+	}
+	return payments
 }
 
 // CreateTransaction creates a new transaction (POS sale)
@@ -209,163 +251,1000 @@ func CreateTransaction(c *fiber.Ctx) error {
 	}
 	defer tx.Rollback()
 
-	// Generate invoice number
-	var invoiceNumber string
-	err = tx.QueryRow(`SELECT generate_invoice_number($1)`, storeID).Scan(&invoiceNumber)
+	// Retries with the same key get the original response back
+	key := idempotencyKey(c, req.ClientTransactionID)
+	if len(key) > 255 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Idempotency-Key is too long",
+		})
+	}
+	if key != "" {
+		replayStatus, replayBody, err := claimIdempotencyKey(tx, storeID, key)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+		if replayBody != nil {
+			return replayIdempotentResponse(c, replayStatus, replayBody)
+		}
+	}
+
+	transaction, err := createSale(tx, storeID, userID, req)
+	if err != nil {
+		return saleErrorResponse(c, err)
+	}
+
+	response := marshalResponse(fiber.Map{
+		"success": true,
+		"data":    transaction,
+		"message": "Transaction completed successfully",
+	})
+	if key != "" {
+		if err := saveIdempotentResponse(tx, storeID, key, transaction.ID, fiber.StatusCreated, response); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to complete transaction",
+			})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to complete transaction",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Status(fiber.StatusCreated).Send(response)
+}
+
+// createSale prices, pays and writes a completed sale inside tx. Stock,
+// customer stats and the plan limit are handled by the database triggers.
+// Errors are *fiber.Error so callers can tell client mistakes from failures.
+func createSale(tx *sql.Tx, storeID, userID uuid.UUID, req models.CreateTransactionRequest) (*models.Transaction, error) {
+	sale, err := priceSale(tx, storeID, req, true)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	sale.applyRounding(paidInCash(req))
+
+	payments, paymentAmount, changeAmount, paymentType, err := settlePayments(req, sale.Total)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	paymentRef := req.PaymentRef
+	if len(payments) == 1 && payments[0].Reference != nil {
+		paymentRef = payments[0].Reference
+	}
+
+	if sale.PromoID != nil {
+		if err := claimPromo(tx, *sale.PromoID); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+
+	invoiceNumber, err := nextInvoiceNumber(tx, storeID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to generate invoice number: "+err.Error())
+	}
+
+	// Create transaction
+	var transaction models.Transaction
+	err = tx.QueryRow(`
+		INSERT INTO transactions (
+			store_id, customer_id, cashier_id, invoice_number,
+			subtotal, discount_amount, discount_percent, tax_amount, rounding_adjustment, total,
+			payment_amount, change_amount, payment_type, payment_reference, notes,
+			promo_id, promo_discount, shift_id, service_charge, status, completed_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, 'completed', NOW())
+		RETURNING id, store_id, customer_id, cashier_id, invoice_number,
+		          subtotal, discount_amount, discount_percent, service_charge, tax_amount, rounding_adjustment, total,
+		          payment_amount, change_amount, payment_type, promo_id, promo_discount,
+		          shift_id, status, created_at, completed_at
+	`, storeID, req.CustomerID, userID, invoiceNumber,
+		sale.Subtotal, sale.Discount, req.DiscountPercent, sale.Tax, sale.Rounding, sale.Total,
+		paymentAmount, changeAmount, paymentType, paymentRef, req.Notes,
+		sale.PromoID, sale.PromoDiscount, activeShiftID(tx, storeID, userID), sale.ServiceCharge).Scan(
+		&transaction.ID, &transaction.StoreID, &transaction.CustomerID, &transaction.CashierID,
+		&transaction.InvoiceNumber, &transaction.Subtotal, &transaction.DiscountAmount,
+		&transaction.DiscountPercent, &transaction.ServiceCharge, &transaction.TaxAmount, &transaction.RoundingAdjustment, &transaction.Total,
+		&transaction.PaymentAmount, &transaction.ChangeAmount, &transaction.PaymentType,
+		&transaction.PromoID, &transaction.PromoDiscount, &transaction.ShiftID,
+		&transaction.Status, &transaction.CreatedAt, &transaction.CompletedAt,
+	)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to create transaction: "+err.Error())
+	}
+
+	transaction.Payments, err = insertPayments(tx, transaction.ID, payments)
 	if err != nil {
-		// Fallback if function doesn't exist
-		invoiceNumber = fmt.Sprintf("INV-%s-%04d", time.Now().Format("20060102"), time.Now().UnixNano()%10000)
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to record payment: "+err.Error())
 	}
 
-	// Calculate totals
-	var subtotal float64 = 0
-	var itemsData []struct {
-		ProductID    uuid.UUID
-		ProductName  string
-		ProductPrice float64
-		Quantity     int
-		Cost         float64
-		ItemDiscount float64
-		ItemSubtotal float64
+	transaction.Taxes, err = insertSaleTaxes(tx, transaction.ID, sale.Taxes)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to record taxes: "+err.Error())
 	}
 
+	// Create transaction items (this will trigger stock update via trigger)
+	transaction.Items, err = insertSaleItems(tx, transaction.ID, sale.Lines)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to create transaction item: "+err.Error())
+	}
+	transaction.StockWarnings = sale.StockWarnings
+
+	return &transaction, nil
+}
+
+// saleErrorResponse writes an error returned by createSale
+func saleErrorResponse(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	if e, ok := err.(*fiber.Error); ok {
+		status = e.Code
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
+
+// saleLine is a priced cart line ready to be written to transaction_items
+type saleLine struct {
+	ProductID       uuid.UUID
+	CategoryID      *uuid.UUID
+	ProductName     string
+	ProductPrice    models.Money
+	Quantity        models.Quantity
+	Cost            models.Money
+	DiscountPercent float64
+	ItemDiscount    models.Money
+	ItemSubtotal    models.Money
+	ModifierAmount  models.Money
+	Modifiers       []models.TransactionItemModifier
+	Components      []models.TransactionItemComponent
+	UnitID          *uuid.UUID
+	UnitName        *string
+	UnitFactor      models.Quantity
+}
+
+// sale holds the priced lines and totals of a cart
+type sale struct {
+	Lines         []saleLine
+	Subtotal      models.Money
+	Discount      models.Money
+	ServiceCharge models.Money
+	Tax           models.Money
+	Taxes         []models.TransactionTax
+	Total         models.Money
+	PromoID       *uuid.UUID
+	PromoDiscount models.Money
+	Rounding      models.Money
+	StockWarnings []string
+	rounding      roundingRule
+}
+
+// roundingRule is a store's cash rounding (pembulatan) setting
+type roundingRule struct {
+	Increment models.Money
+	Mode      string
+	CashOnly  bool
+}
+
+// applyRounding rounds the total to the store's increment and keeps the
+// difference in Rounding. Cash-only rules are left out until the sale is known
+// to be paid fully in cash. It can be called again once that is known.
+func (s *sale) applyRounding(paidInCash bool) {
+	total := s.Total - s.Rounding
+	s.Rounding = 0
+	if s.rounding.Increment > 0 && (paidInCash || !s.rounding.CashOnly) {
+		s.Rounding = total.RoundTo(s.rounding.Increment, s.rounding.Mode) - total
+	}
+	s.Total = total + s.Rounding
+}
+
+// paidInCash reports whether every payment line of the request is cash
+func paidInCash(req models.CreateTransactionRequest) bool {
+	if len(req.Payments) == 0 {
+		return req.PaymentType == "cash"
+	}
+	for _, p := range req.Payments {
+		if p.PaymentType != "cash" {
+			return false
+		}
+	}
+	return true
+}
+
+// lockProducts locks the rows of the products being sold, and of their
+// recipe ingredients. Rows are locked in id order so two carts with the same
+// products can't deadlock, and the stock read afterwards can't change until
+// this sale commits.
+func lockProducts(tx *sql.Tx, storeID uuid.UUID, productIDs []uuid.UUID) error {
+	ids := make([]string, 0, len(productIDs))
+	for _, id := range productIDs {
+		ids = append(ids, id.String())
+	}
+	rows, err := tx.Query(`
+		SELECT id FROM products
+		WHERE store_id = $1 AND id = ANY($2::uuid[])
+		ORDER BY id
+		FOR UPDATE
+	`, storeID, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// nextInvoiceNumber takes the next number from the store's invoice sequence.
+// The sequence row stays locked until tx ends, so call it after the products
+// are locked to keep the lock order the same everywhere.
+func nextInvoiceNumber(tx *sql.Tx, storeID uuid.UUID) (string, error) {
+	var invoiceNumber string
+	err := tx.QueryRow(`SELECT generate_invoice_number($1)`, storeID).Scan(&invoiceNumber)
+	return invoiceNumber, err
+}
+
+// priceSale looks up current product prices for the cart and works out item
+// discounts, the global discount, taxes and total. Stock is only checked when
+// checkStock is set, so held carts can be priced without reserving anything.
+func priceSale(tx *sql.Tx, storeID uuid.UUID, req models.CreateTransactionRequest, checkStock bool) (*sale, error) {
+	s := &sale{}
+
+	var taxRate float64
+	var stockPolicy string
+	tx.QueryRow(`
+		SELECT COALESCE(tax_rate, 0), COALESCE(negative_stock_policy, 'block'),
+		       COALESCE(rounding_increment, 0), COALESCE(rounding_mode, 'nearest'),
+		       COALESCE(rounding_cash_only, true)
+		FROM stores WHERE id = $1
+	`, storeID).Scan(&taxRate, &stockPolicy, &s.rounding.Increment, &s.rounding.Mode, &s.rounding.CashOnly)
+
+	var productIDs []uuid.UUID
+	seen := map[uuid.UUID]bool{}
 	for _, item := range req.Items {
+		// Callers validate this too; a line of nothing or less would put
+		// stock back and take money off the sale
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("Quantity must be more than 0")
+		}
+		if !seen[item.ProductID] {
+			seen[item.ProductID] = true
+			productIDs = append(productIDs, item.ProductID)
+		}
+	}
+	bundles, err := loadBundles(tx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	// Bundle components can have recipes of their own
+	recipeIDs := append([]uuid.UUID{}, productIDs...)
+	for _, id := range productIDs {
+		for _, component := range bundles[id] {
+			recipeIDs = append(recipeIDs, component.ProductID)
+		}
+	}
+	recipes, err := loadRecipes(tx, recipeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	units := make([]*models.ProductUnit, len(req.Items))
+	for i, item := range req.Items {
+		if units[i], err = saleUnit(tx, item.ProductID, item.UnitID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Work out the stock the cart takes in base units, with bundles taking
+	// their components and composite products their ingredients instead of
+	// themselves
+	needed := map[uuid.UUID]models.Quantity{}
+	var stockIDs []uuid.UUID
+	takeStock := func(id uuid.UUID, quantity models.Quantity) {
+		if _, ok := needed[id]; !ok {
+			stockIDs = append(stockIDs, id)
+		}
+		needed[id] += quantity
+	}
+	take := func(id uuid.UUID, quantity models.Quantity) {
+		if recipe, ok := recipes[id]; ok {
+			for _, ingredient := range recipe {
+				takeStock(ingredient.IngredientID, ingredient.Quantity.MulQuantity(quantity))
+			}
+			return
+		}
+		takeStock(id, quantity)
+	}
+	for i, item := range req.Items {
+		quantity := baseQuantity(item.Quantity, units[i])
+		if bundle, ok := bundles[item.ProductID]; ok {
+			for _, component := range bundle {
+				take(component.ProductID, quantity.Mul(component.Quantity))
+			}
+		} else {
+			take(item.ProductID, quantity)
+		}
+	}
+
+	if checkStock {
+		if err := lockProducts(tx, storeID, append(productIDs, stockIDs...)); err != nil {
+			return nil, err
+		}
+	}
+	modifierGroups := map[uuid.UUID][]models.ModifierGroup{}
+
+	for i, item := range req.Items {
 		var product struct {
-			Name  string
-			Price float64
-			Cost  float64
-			Stock int
+			ParentID    *uuid.UUID
+			CategoryID  *uuid.UUID
+			Name        string
+			Price       models.Money
+			Cost        models.Money
+			HasVariants bool
+			Decimal     bool
 		}
 		err := tx.QueryRow(`
-			SELECT name, price, cost, stock FROM products 
+			SELECT parent_id, category_id, name, price, cost, variant_options IS NOT NULL, decimal_quantity FROM products 
 			WHERE id = $1 AND store_id = $2 AND is_active = true
-		`, item.ProductID, storeID).Scan(&product.Name, &product.Price, &product.Cost, &product.Stock)
+		`, item.ProductID, storeID).Scan(&product.ParentID, &product.CategoryID, &product.Name, &product.Price, &product.Cost, &product.HasVariants, &product.Decimal)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success": false,
-				"error":   fmt.Sprintf("Product not found: %s", item.ProductID),
-			})
+			return nil, fmt.Errorf("Product not found: %s", item.ProductID)
+		}
+		// Stock and price live on the variants, so one of them has to be sold
+		if product.HasVariants {
+			return nil, fmt.Errorf("Choose a variant of %s", product.Name)
+		}
+		if err := checkQuantity(product.Name, product.Decimal, item.Quantity); err != nil {
+			return nil, err
 		}
 
-		// Check stock
-		if product.Stock < item.Quantity {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success": false,
-				"error":   fmt.Sprintf("Insufficient stock for %s", product.Name),
-			})
+		// Bundles and composite products cost what their components and
+		// ingredients cost today
+		var components []models.TransactionItemComponent
+		if bundle, ok := bundles[item.ProductID]; ok {
+			product.Cost = bundleCost(bundle)
+			for _, component := range bundle {
+				productID := component.ProductID
+				components = append(components, models.TransactionItemComponent{
+					ProductID:   &productID,
+					ProductName: component.ProductName,
+					Quantity:    component.Quantity,
+				})
+			}
+		} else if recipe, ok := recipes[item.ProductID]; ok {
+			product.Cost = recipeCost(recipe)
+		}
+
+		// Sold in another unit, the item is priced per that unit and costs
+		// as many base units as the unit holds
+		unitFactor := models.NewQuantity(1)
+		var unitName *string
+		if unit := units[i]; unit != nil {
+			product.Price = unit.Price
+			product.Cost = product.Cost.MulQuantity(unit.Factor)
+			unitFactor = unit.Factor
+			unitName = &unit.Name
+		}
+
+		groups, ok := modifierGroups[item.ProductID]
+		if !ok {
+			groups, err = productModifierGroups(tx, storeID, item.ProductID, product.ParentID, product.CategoryID)
+			if err != nil {
+				return nil, err
+			}
+			modifierGroups[item.ProductID] = groups
+		}
+		modifiers, modifierAmount, err := pickModifiers(groups, item.ModifierIDs, product.Name)
+		if err != nil {
+			return nil, err
 		}
 
-		// Calculate item subtotal
-		itemPrice := product.Price * float64(item.Quantity)
+		// Calculate item subtotal, with the modifiers charged for every unit
+		itemPrice := (product.Price + modifierAmount).MulQuantity(item.Quantity)
 		itemDiscount := item.DiscountAmount
 		if item.DiscountPercent > 0 {
-			itemDiscount = itemPrice * (item.DiscountPercent / 100)
+			itemDiscount = itemPrice.Percent(item.DiscountPercent)
 		}
 		itemSubtotal := itemPrice - itemDiscount
 
-		subtotal += itemSubtotal
-
-		itemsData = append(itemsData, struct {
-			ProductID    uuid.UUID
-			ProductName  string
-			ProductPrice float64
-			Quantity     int
-			Cost         float64
-			ItemDiscount float64
-			ItemSubtotal float64
-		}{
-			ProductID:    item.ProductID,
-			ProductName:  product.Name,
-			ProductPrice: product.Price,
-			Quantity:     item.Quantity,
-			Cost:         product.Cost,
-			ItemDiscount: itemDiscount,
-			ItemSubtotal: itemSubtotal,
+		s.Subtotal += itemSubtotal
+
+		s.Lines = append(s.Lines, saleLine{
+			ProductID:       item.ProductID,
+			CategoryID:      product.CategoryID,
+			ProductName:     product.Name,
+			ProductPrice:    product.Price,
+			Quantity:        item.Quantity,
+			Cost:            product.Cost,
+			DiscountPercent: item.DiscountPercent,
+			ItemDiscount:    itemDiscount,
+			ItemSubtotal:    itemSubtotal,
+			ModifierAmount:  modifierAmount,
+			Modifiers:       modifiers,
+			Components:      components,
+			UnitID:          item.UnitID,
+			UnitName:        unitName,
+			UnitFactor:      unitFactor,
 		})
 	}
 
+	// Check stock against everything the cart takes of each product
+	if checkStock {
+		for _, id := range stockIDs {
+			var name string
+			var stock models.Quantity
+			var trackStock bool
+			if err := tx.QueryRow(`
+				SELECT name, stock, track_stock FROM products
+				WHERE id = $1 AND store_id = $2
+			`, id, storeID).Scan(&name, &stock, &trackStock); err != nil {
+				return nil, fmt.Errorf("Product not found: %s", id)
+			}
+			if !trackStock || stock >= needed[id] {
+				continue
+			}
+			switch stockPolicy {
+			case "allow":
+			case "warn":
+				s.StockWarnings = append(s.StockWarnings, fmt.Sprintf(
+					"Stock for %s goes negative (%s on hand, %s sold)", name, stock, needed[id]))
+			default:
+				return nil, fmt.Errorf("Insufficient stock for %s", name)
+			}
+		}
+	}
+
 	// Apply global discount
-	globalDiscount := req.DiscountAmount
+	s.Discount = req.DiscountAmount
 	if req.DiscountPercent > 0 {
-		globalDiscount = subtotal * (req.DiscountPercent / 100)
+		s.Discount = s.Subtotal.Percent(req.DiscountPercent)
 	}
 
-	// Get tax rate
-	var taxRate float64
-	tx.QueryRow(`SELECT COALESCE(tax_rate, 0) FROM stores WHERE id = $1`, storeID).Scan(&taxRate)
-	taxAmount := (subtotal - globalDiscount) * (taxRate / 100)
+	// Apply promo code on top of the manual discount
+	if req.PromoCode != nil && *req.PromoCode != "" {
+		if err := applyPromo(tx, storeID, *req.PromoCode, s); err != nil {
+			return nil, err
+		}
+	}
+
+	rules, err := saleTaxRules(tx, storeID, taxRate)
+	if err != nil {
+		return nil, err
+	}
+	s.applyTaxes(rules)
+
+	s.Total = s.Subtotal - s.Discount + s.ServiceCharge + s.Tax
+	s.applyRounding(false)
 
-	total := subtotal - globalDiscount + taxAmount
-	changeAmount := req.PaymentAmount - total
+	return s, nil
+}
 
+// insertSaleItems writes priced cart lines to transaction_items, with their
+// chosen modifiers
+func insertSaleItems(tx *sql.Tx, transactionID uuid.UUID, lines []saleLine) ([]models.TransactionItem, error) {
+	var items []models.TransactionItem
+	for _, item := range lines {
+		var txItem models.TransactionItem
+		err := tx.QueryRow(`
+			INSERT INTO transaction_items (
+				transaction_id, product_id, product_name, product_price,
+				quantity, discount_amount, discount_percent, subtotal, cost, modifier_amount,
+				unit_id, unit_name, unit_factor
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			RETURNING id, transaction_id, product_id, product_name, product_price,
+			          quantity, discount_amount, discount_percent, subtotal, modifier_amount,
+			          unit_id, unit_name, unit_factor
+		`, transactionID, item.ProductID, item.ProductName, item.ProductPrice,
+			item.Quantity, item.ItemDiscount, item.DiscountPercent, item.ItemSubtotal, item.Cost, item.ModifierAmount,
+			item.UnitID, item.UnitName, item.UnitFactor).Scan(
+			&txItem.ID, &txItem.TransactionID, &txItem.ProductID, &txItem.ProductName,
+			&txItem.ProductPrice, &txItem.Quantity, &txItem.DiscountAmount,
+			&txItem.DiscountPercent, &txItem.Subtotal, &txItem.ModifierAmount,
+			&txItem.UnitID, &txItem.UnitName, &txItem.UnitFactor,
+		)
+		if err != nil {
+			return nil, err
+		}
+		txItem.Modifiers, err = insertItemModifiers(tx, txItem.ID, item.Modifiers)
+		if err != nil {
+			return nil, err
+		}
+		txItem.Components, err = insertItemComponents(tx, txItem.ID, item.Components)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, txItem)
+	}
+	return items, nil
+}
+
+// insertPayments writes the payment lines of a transaction
+func insertPayments(tx *sql.Tx, transactionID uuid.UUID, payments []models.CreateTransactionPaymentRequest) ([]models.TransactionPayment, error) {
+	var result []models.TransactionPayment
+	for _, p := range payments {
+		var payment models.TransactionPayment
+		err := tx.QueryRow(`
+			INSERT INTO transaction_payments (transaction_id, payment_type, amount, reference)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, transaction_id, payment_type, amount, reference, created_at
+		`, transactionID, p.PaymentType, p.Amount, p.Reference).Scan(
+			&payment.ID, &payment.TransactionID, &payment.PaymentType, &payment.Amount,
+			&payment.Reference, &payment.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, payment)
+	}
+	return result, nil
+}
+
+// settlePayments turns the request's payment lines (or its single legacy
+// payment_type/payment_amount) into the lines to store. Cash lines are merged
+// into one and change is only ever given out of cash.
+func settlePayments(req models.CreateTransactionRequest, total models.Money) (payments []models.CreateTransactionPaymentRequest, paymentAmount, changeAmount models.Money, paymentType string, err error) {
+	lines := req.Payments
+	if len(lines) == 0 {
+		if req.PaymentType == "" {
+			return nil, 0, 0, "", fmt.Errorf("Payment type is required")
+		}
+		lines = []models.CreateTransactionPaymentRequest{{
+			PaymentType: req.PaymentType,
+			Amount:      req.PaymentAmount,
+			Reference:   req.PaymentRef,
+		}}
+	}
+
+	var cashPaid, nonCashPaid models.Money
+	for _, line := range lines {
+		if line.PaymentType == "cash" {
+			cashPaid += line.Amount
+			continue
+		}
+		nonCashPaid += line.Amount
+		payments = append(payments, line)
+	}
+	if cashPaid > 0 || len(payments) == 0 {
+		payments = append([]models.CreateTransactionPaymentRequest{{
+			PaymentType: "cash",
+			Amount:      cashPaid,
+		}}, payments...)
+	}
+
+	if nonCashPaid > total {
+		return nil, 0, 0, "", fmt.Errorf("Non-cash payments exceed the transaction total")
+	}
+
+	paymentAmount = cashPaid + nonCashPaid
+	changeAmount = paymentAmount - total
 	if changeAmount < 0 {
+		return nil, 0, 0, "", fmt.Errorf("Insufficient payment amount")
+	}
+
+	paymentType = payments[0].PaymentType
+	if len(payments) > 1 {
+		paymentType = "split"
+	}
+	return payments, paymentAmount, changeAmount, paymentType, nil
+}
+
+// RefundTransaction refunds a completed transaction, either fully or for
+// selected item quantities, and puts tracked stock back on the shelf
+func RefundTransaction(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+	transactionID := c.Params("id")
+
+	txUUID, err := uuid.Parse(transactionID)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"error":   "Insufficient payment amount",
+			"error":   "Invalid transaction ID",
 		})
 	}
 
-	// Create transaction
-	var transaction models.Transaction
+	var req models.RefundTransactionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid request body",
+			})
+		}
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	// Lock the transaction so two refunds can't race each other
+	var t models.Transaction
 	err = tx.QueryRow(`
-		INSERT INTO transactions (
-			store_id, customer_id, cashier_id, invoice_number,
-			subtotal, discount_amount, discount_percent, tax_amount, total,
-			payment_amount, change_amount, payment_type, payment_reference, notes, status
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, 'completed')
-		RETURNING id, store_id, customer_id, cashier_id, invoice_number,
-		          subtotal, discount_amount, discount_percent, tax_amount, total,
-		          payment_amount, change_amount, payment_type, status, created_at
-	`, storeID, req.CustomerID, userID, invoiceNumber,
-		subtotal, globalDiscount, req.DiscountPercent, taxAmount, total,
-		req.PaymentAmount, changeAmount, req.PaymentType, req.PaymentRef, req.Notes).Scan(
-		&transaction.ID, &transaction.StoreID, &transaction.CustomerID, &transaction.CashierID,
-		&transaction.InvoiceNumber, &transaction.Subtotal, &transaction.DiscountAmount,
-		&transaction.DiscountPercent, &transaction.TaxAmount, &transaction.Total,
-		&transaction.PaymentAmount, &transaction.ChangeAmount, &transaction.PaymentType,
-		&transaction.Status, &transaction.CreatedAt,
+		SELECT id, customer_id, invoice_number, subtotal, total, refunded_amount, status
+		FROM transactions
+		WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, txUUID, storeID).Scan(
+		&t.ID, &t.CustomerID, &t.InvoiceNumber, &t.Subtotal, &t.Total, &t.RefundedAmount, &t.Status,
 	)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Transaction not found",
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-			"error":   "Failed to create transaction: " + err.Error(),
+			"error":   "Failed to fetch transaction",
 		})
 	}
 
-	// Create transaction items (this will trigger stock update via trigger)
-	for _, item := range itemsData {
-		var txItem models.TransactionItem
-		err = tx.QueryRow(`
-			INSERT INTO transaction_items (
-				transaction_id, product_id, product_name, product_price,
-				quantity, discount_amount, subtotal, cost
-			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-			RETURNING id, transaction_id, product_id, product_name, product_price,
-			          quantity, discount_amount, subtotal
-		`, transaction.ID, item.ProductID, item.ProductName, item.ProductPrice,
-			item.Quantity, item.ItemDiscount, item.ItemSubtotal, item.Cost).Scan(
-			&txItem.ID, &txItem.TransactionID, &txItem.ProductID, &txItem.ProductName,
-			&txItem.ProductPrice, &txItem.Quantity, &txItem.DiscountAmount, &txItem.Subtotal,
+	if t.Status != "completed" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Only completed transactions can be refunded",
+		})
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, product_id, product_name, quantity, refunded_quantity, subtotal, cost, unit_factor,
+		       COALESCE((SELECT decimal_quantity FROM products WHERE id = product_id), false)
+		FROM transaction_items
+		WHERE transaction_id = $1
+		FOR UPDATE
+	`, txUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch transaction items",
+		})
+	}
+	itemsByID := map[uuid.UUID]*models.TransactionItem{}
+	decimalQuantity := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var item models.TransactionItem
+		var isDecimal bool
+		rows.Scan(
+			&item.ID, &item.ProductID, &item.ProductName, &item.Quantity,
+			&item.RefundedQuantity, &item.Subtotal, &item.Cost, &item.UnitFactor, &isDecimal,
 		)
+		t.Items = append(t.Items, item)
+		decimalQuantity[item.ID] = isDecimal
+	}
+	rows.Close()
+	for i := range t.Items {
+		itemsByID[t.Items[i].ID] = &t.Items[i]
+	}
+
+	// Work out which quantities are being refunded
+	refundQty := map[uuid.UUID]models.Quantity{}
+	if len(req.Items) == 0 {
+		for _, item := range t.Items {
+			if remaining := item.Quantity - item.RefundedQuantity; remaining > 0 {
+				refundQty[item.ID] = remaining
+			}
+		}
+	} else {
+		for _, r := range req.Items {
+			item, ok := itemsByID[r.TransactionItemID]
+			if !ok {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   fmt.Sprintf("Transaction item not found: %s", r.TransactionItemID),
+				})
+			}
+			if err := checkQuantity(item.ProductName, decimalQuantity[item.ID], r.Quantity); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   err.Error(),
+				})
+			}
+			refundQty[item.ID] += r.Quantity
+			if refundQty[item.ID] > item.Quantity-item.RefundedQuantity {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   fmt.Sprintf("Refund quantity exceeds remaining quantity for %s", item.ProductName),
+				})
+			}
+		}
+	}
+
+	if len(refundQty) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Nothing left to refund",
+		})
+	}
+
+	// Item subtotals are before the global discount, service charge and tax, so scale them
+	// by total/subtotal to get what the customer actually paid per line
+	paid, priced := int64(1), int64(1)
+	if t.Subtotal > 0 {
+		paid, priced = int64(t.Total), int64(t.Subtotal)
+	}
+
+	isFull := true
+	for _, item := range t.Items {
+		if item.Quantity-item.RefundedQuantity-refundQty[item.ID] > 0 {
+			isFull = false
+			break
+		}
+	}
+
+	var refundItems []models.RefundItem
+	var refundAmount, refundCost models.Money
+	for _, item := range t.Items {
+		qty, ok := refundQty[item.ID]
+		if !ok {
+			continue
+		}
+		amount := item.Subtotal.MulDiv(int64(qty)*paid, int64(item.Quantity)*priced)
+		refundItems = append(refundItems, models.RefundItem{
+			TransactionItemID: item.ID,
+			ProductID:         item.ProductID,
+			Quantity:          qty,
+			Amount:            amount,
+			Cost:              item.Cost,
+		})
+		refundAmount += amount
+		refundCost += item.Cost.MulQuantity(qty)
+	}
+
+	// The last refund always settles whatever is left so rounding can't drift
+	if isFull {
+		refundAmount = t.Total - t.RefundedAmount
+	}
+
+	var refund models.Refund
+	err = tx.QueryRow(`
+		INSERT INTO refunds (transaction_id, store_id, amount, cost_amount, is_full, reason, shift_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, transaction_id, store_id, amount, cost_amount, is_full, reason, shift_id, created_by, created_at
+	`, txUUID, storeID, refundAmount, refundCost, isFull, req.Reason, activeShiftID(tx, storeID, userID), userID).Scan(
+		&refund.ID, &refund.TransactionID, &refund.StoreID, &refund.Amount, &refund.CostAmount,
+		&refund.IsFull, &refund.Reason, &refund.ShiftID, &refund.CreatedBy, &refund.CreatedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create refund: " + err.Error(),
+		})
+	}
+
+	for _, ri := range refundItems {
+		ri.RefundID = refund.ID
+		err = tx.QueryRow(`
+			INSERT INTO refund_items (refund_id, transaction_item_id, product_id, quantity, amount, cost)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`, ri.RefundID, ri.TransactionItemID, ri.ProductID, ri.Quantity, ri.Amount, ri.Cost).Scan(&ri.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to create refund item: " + err.Error(),
+			})
+		}
+
+		_, err = tx.Exec(`
+			UPDATE transaction_items SET refunded_quantity = refunded_quantity + $2
+			WHERE id = $1
+		`, ri.TransactionItemID, ri.Quantity)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success": false,
-				"error":   "Failed to create transaction item: " + err.Error(),
+				"error":   "Failed to update transaction item",
+			})
+		}
+
+		if ri.ProductID != nil {
+			item := itemsByID[ri.TransactionItemID]
+			if err := returnSaleStock(tx, storeID, item.ID, *ri.ProductID,
+				ri.Quantity.MulQuantity(item.UnitFactor), item.Quantity.MulQuantity(item.UnitFactor),
+				"return", refund.ID, "refund", "Refund: "+t.InvoiceNumber, userID); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"success": false,
+					"error":   "Failed to restock product: " + err.Error(),
+				})
+			}
+		}
+
+		refund.Items = append(refund.Items, ri)
+	}
+
+	status := t.Status
+	if isFull {
+		status = "refunded"
+	}
+	_, err = tx.Exec(`
+		UPDATE transactions SET refunded_amount = refunded_amount + $2, status = $3, updated_at = NOW()
+		WHERE id = $1
+	`, txUUID, refundAmount, status)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update transaction",
+		})
+	}
+
+	// Reverse what update_customer_stats added when the sale was made
+	if t.CustomerID != nil {
+		countDelta := 0
+		if isFull {
+			countDelta = 1
+		}
+		_, err = tx.Exec(`
+			UPDATE customers SET
+				total_spent = GREATEST(total_spent - $2, 0),
+				total_transactions = GREATEST(total_transactions - $3, 0),
+				updated_at = NOW()
+			WHERE id = $1
+		`, t.CustomerID, refundAmount, countDelta)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to update customer stats",
 			})
 		}
-		transaction.Items = append(transaction.Items, txItem)
 	}
 
 	if err := tx.Commit(); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-			"error":   "Failed to complete transaction",
+			"error":   "Failed to complete refund",
 		})
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"success": true,
-		"data":    transaction,
-		"message": "Transaction completed successfully",
+		"data":    refund,
+		"message": "Transaction refunded successfully",
 	})
 }
+
+// returnSaleStock puts back the stock a sale line took, for quantity of the
+// sold quantity of the line (both in base units). What the line took is read
+// from transaction_item_stock, recorded as it was sold, so a bundle or
+// recipe changed since doesn't change what comes back. Lines sold before
+// that was recorded are returned as their product is defined now.
+func returnSaleStock(tx *sql.Tx, storeID, itemID, productID uuid.UUID, quantity, sold models.Quantity, movementType string, referenceID uuid.UUID, referenceType, notes string, userID uuid.UUID) error {
+	rows, err := tx.Query(`
+		SELECT product_id, quantity FROM transaction_item_stock
+		WHERE transaction_item_id = $1 AND product_id IS NOT NULL
+		ORDER BY product_id
+	`, itemID)
+	if err != nil {
+		return err
+	}
+	type taken struct {
+		ProductID uuid.UUID
+		Quantity  models.Quantity
+	}
+	var took []taken
+	for rows.Next() {
+		var t taken
+		if err := rows.Scan(&t.ProductID, &t.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		took = append(took, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(took) == 0 {
+		return returnProductStock(tx, storeID, productID, quantity, movementType, referenceID, referenceType, notes, userID)
+	}
+	for _, t := range took {
+		if err := restockProduct(tx, storeID, t.ProductID, t.Quantity.MulDiv(int64(quantity), int64(sold)), nil,
+			movementType, referenceID, referenceType, notes, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returnProductStock puts back the stock quantity of a product takes when
+// sold now: its own, its components' for a bundle, or its recipe
+// ingredients' for a composite product. quantity is in base units.
+func returnProductStock(tx *sql.Tx, storeID, productID uuid.UUID, quantity models.Quantity, movementType string, referenceID uuid.UUID, referenceType, notes string, userID uuid.UUID) error {
+	bundles, err := loadBundles(tx, []uuid.UUID{productID})
+	if err != nil {
+		return err
+	}
+	if bundle, ok := bundles[productID]; ok {
+		for _, component := range bundle {
+			if err := returnProductStock(tx, storeID, component.ProductID, quantity.Mul(component.Quantity), movementType, referenceID, referenceType, notes, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	recipes, err := loadRecipes(tx, []uuid.UUID{productID})
+	if err != nil {
+		return err
+	}
+	recipe, ok := recipes[productID]
+	if !ok {
+		return restockProduct(tx, storeID, productID, quantity, nil, movementType, referenceID, referenceType, notes, userID)
+	}
+	for _, ingredient := range recipe {
+		if err := restockProduct(tx, storeID, ingredient.IngredientID, ingredient.Quantity.MulQuantity(quantity), nil, movementType, referenceID, referenceType, notes, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restockProduct puts quantity back into a tracked product's stock and records
+// the movement. Untracked products are left alone. With a unitCost per base
+// unit, the goods are bought in and averaged into the product's cost;
+// without one, as for returns, they come back at the current cost.
+func restockProduct(tx *sql.Tx, storeID, productID uuid.UUID, quantity models.Quantity, unitCost *models.Money, movementType string, referenceID uuid.UUID, referenceType, notes string, userID uuid.UUID) error {
+	var currentStock models.Quantity
+	var cost models.Money
+	var trackStock bool
+	err := tx.QueryRow(`
+		SELECT stock, cost, track_stock FROM products
+		WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, productID, storeID).Scan(&currentStock, &cost, &trackStock)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !trackStock {
+		// Without stock to average over, the last price paid is the cost
+		if unitCost != nil {
+			_, err = tx.Exec(`UPDATE products SET cost = $1, updated_at = NOW() WHERE id = $2`, *unitCost, productID)
+		}
+		return err
+	}
+
+	newStock := currentStock + quantity
+	if unitCost != nil {
+		cost = averageCost(currentStock, cost, quantity, *unitCost)
+	}
+	if _, err := tx.Exec(`
+		UPDATE products SET stock = $1, cost = $2, updated_at = NOW()
+		WHERE id = $3
+	`, newStock, cost, productID); err != nil {
+		return err
+	}
+	// Stock taken away, as by a stocktake shortage, leaves the batches first
+	// expired first out; stock put back has no batch
+	if quantity < 0 {
+		if err := consumeStockBatches(tx, productID, -quantity); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO stock_movements (product_id, store_id, type, quantity, stock_before, stock_after, unit_cost,
+		                             reference_id, reference_type, notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, productID, storeID, movementType, quantity, currentStock, newStock, unitCost, referenceID, referenceType, notes, userID)
+	return err
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// TestConcurrentSalesBlockPolicy sells one product from many connections at
+// once in a store that blocks negative stock. Every sale must either take
+// its stock or be rejected for insufficient stock: the final stock is the
+// initial stock less the sales that went through, and it never dips below
+// zero on the way.
+//
+// It needs a database with database/schema.sql loaded, given by
+// DATABASE_URL, and cleans up the store it creates.
+func TestConcurrentSalesBlockPolicy(t *testing.T) {
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(25)
+	database.DB = db
+
+	const (
+		initialStock = 30
+		workers      = 20
+		salesEach    = 5
+	)
+
+	var userID, storeID, productID uuid.UUID
+	if err := db.QueryRow(`
+		INSERT INTO users (email, full_name) VALUES ($1, 'Concurrency Test')
+		RETURNING id
+	`, fmt.Sprintf("concurrency-%s@test.local", uuid.New())).Scan(&userID); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM stores WHERE user_id = $1`, userID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	})
+	if err := db.QueryRow(`
+		INSERT INTO stores (user_id, name, negative_stock_policy)
+		VALUES ($1, 'Concurrency Test', 'block')
+		RETURNING id
+	`, userID).Scan(&storeID); err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if err := db.QueryRow(`
+		INSERT INTO products (store_id, name, price, cost, stock, track_stock)
+		VALUES ($1, 'Concurrency Test Product', 10000, 6000, $2, true)
+		RETURNING id
+	`, storeID, initialStock).Scan(&productID); err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+
+	req := models.CreateTransactionRequest{
+		Items: []models.CreateTransactionItemRequest{{
+			ProductID: productID,
+			Quantity:  models.NewQuantity(1),
+		}},
+		PaymentType:   "cash",
+		PaymentAmount: models.NewMoney(10000),
+	}
+
+	var mu sync.Mutex
+	var succeeded, rejected int
+	var unexpected []error
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < salesEach; i++ {
+				err := sellOnce(storeID, userID, req)
+				mu.Lock()
+				switch {
+				case err == nil:
+					succeeded++
+				case strings.Contains(err.Error(), "Insufficient stock"):
+					rejected++
+				default:
+					unexpected = append(unexpected, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range unexpected {
+		t.Errorf("unexpected sale error: %v", err)
+	}
+
+	total := workers * salesEach
+	if succeeded+rejected != total {
+		t.Fatalf("%d sales succeeded and %d were rejected, want %d in all", succeeded, rejected, total)
+	}
+	if succeeded != initialStock {
+		t.Errorf("%d sales succeeded, want %d", succeeded, initialStock)
+	}
+	if rejected != total-initialStock {
+		t.Errorf("%d sales were rejected, want %d", rejected, total-initialStock)
+	}
+
+	var stock models.Quantity
+	if err := db.QueryRow(`SELECT stock FROM products WHERE id = $1`, productID).Scan(&stock); err != nil {
+		t.Fatalf("read stock: %v", err)
+	}
+	if want := models.NewQuantity(int64(initialStock - succeeded)); stock != want {
+		t.Errorf("final stock is %s, want %s", stock, want)
+	}
+
+	var lowest models.Quantity
+	var movements int
+	if err := db.QueryRow(`
+		SELECT COALESCE(MIN(stock_after), 0), COUNT(*) FROM stock_movements
+		WHERE product_id = $1 AND type = 'sale'
+	`, productID).Scan(&lowest, &movements); err != nil {
+		t.Fatalf("read movements: %v", err)
+	}
+	if lowest < 0 {
+		t.Errorf("stock went down to %s", lowest)
+	}
+	if movements != succeeded {
+		t.Errorf("%d sale movements recorded, want %d", movements, succeeded)
+	}
+}
+
+// sellOnce records one sale in its own transaction, as CreateTransaction does
+func sellOnce(storeID, userID uuid.UUID, req models.CreateTransactionRequest) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := createSale(tx, storeID, userID, req); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"testing"
+
+	"kasirku/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestCreateTransactionRequestItemQuantity(t *testing.T) {
+	tests := []struct {
+		quantity models.Quantity
+		valid    bool
+	}{
+		{models.NewQuantity(1), true},
+		{250, true},
+		{0, false},
+		{models.NewQuantity(-3), false},
+	}
+	for _, tt := range tests {
+		req := models.CreateTransactionRequest{
+			Items: []models.CreateTransactionItemRequest{{
+				ProductID: uuid.New(),
+				Quantity:  tt.quantity,
+			}},
+			PaymentType: "cash",
+		}
+		err := validate.Struct(req)
+		if tt.valid && err != nil {
+			t.Errorf("quantity %s: unexpected error %v", tt.quantity, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("quantity %s passed validation", tt.quantity)
+		}
+
+		sync := models.SyncPushRequest{Sales: []models.SyncSaleRequest{{CreateTransactionRequest: req}}}
+		if err := validate.Struct(sync); !tt.valid && err == nil {
+			t.Errorf("quantity %s passed sync push validation", tt.quantity)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const productUnitColumns = `id, product_id, name, factor, barcode, price, is_active, created_at, updated_at`
+
+// queryProductUnits returns the product units matching where
+func queryProductUnits(q queryer, where string, args ...interface{}) ([]models.ProductUnit, error) {
+	rows, err := q.Query(`
+		SELECT `+productUnitColumns+` FROM product_units
+		WHERE `+where+`
+		ORDER BY factor ASC, name ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	units := []models.ProductUnit{}
+	for rows.Next() {
+		var u models.ProductUnit
+		if err := rows.Scan(
+			&u.ID, &u.ProductID, &u.Name, &u.Factor, &u.Barcode, &u.Price,
+			&u.IsActive, &u.CreatedAt, &u.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		units = append(units, u)
+	}
+	return units, rows.Err()
+}
+
+// attachUnits fills in the active units of products and of their variants
+func attachUnits(q queryer, products []models.Product) error {
+	index := map[uuid.UUID]*models.Product{}
+	var ids []string
+	for i := range products {
+		index[products[i].ID] = &products[i]
+		ids = append(ids, products[i].ID.String())
+		for j := range products[i].Variants {
+			v := &products[i].Variants[j]
+			index[v.ID] = v
+			ids = append(ids, v.ID.String())
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	units, err := queryProductUnits(q, `product_id = ANY($1::uuid[]) AND is_active = true`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, u := range units {
+		p := index[u.ProductID]
+		p.Units = append(p.Units, u)
+	}
+	return nil
+}
+
+// saleUnit looks up an active unit of a product. A nil unitID is the base
+// unit and returns nil.
+func saleUnit(q queryer, productID uuid.UUID, unitID *uuid.UUID) (*models.ProductUnit, error) {
+	if unitID == nil {
+		return nil, nil
+	}
+	units, err := queryProductUnits(q, `id = $1 AND product_id = $2 AND is_active = true`, *unitID, productID)
+	if err != nil {
+		return nil, err
+	}
+	if len(units) == 0 {
+		return nil, fmt.Errorf("Unit not found: %s", *unitID)
+	}
+	return &units[0], nil
+}
+
+// baseQuantity converts a quantity in unit into the product's base unit
+func baseQuantity(quantity models.Quantity, unit *models.ProductUnit) models.Quantity {
+	if unit == nil {
+		return quantity
+	}
+	return quantity.MulQuantity(unit.Factor)
+}
+
+// checkQuantity rejects a fractional quantity of a product that is sold in
+// whole units
+func checkQuantity(name string, decimalQuantity bool, quantity models.Quantity) error {
+	if !decimalQuantity && !quantity.IsWhole() {
+		return fmt.Errorf("%s is sold in whole units", name)
+	}
+	return nil
+}
+
+// checkUnitName rejects a unit name that is the product's base unit or
+// another of its active units
+func checkUnitName(tx *sql.Tx, productID uuid.UUID, unitID *uuid.UUID, baseUnit, name string) error {
+	if strings.EqualFold(strings.TrimSpace(name), baseUnit) {
+		return fmt.Errorf("%s is the base unit of the product", name)
+	}
+	var exists bool
+	tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM product_units
+			WHERE product_id = $1 AND is_active = true AND LOWER(name) = LOWER($2)
+			  AND ($3::uuid IS NULL OR id <> $3)
+		)
+	`, productID, strings.TrimSpace(name), unitID).Scan(&exists)
+	if exists {
+		return fmt.Errorf("Unit %s already exists", name)
+	}
+	return nil
+}
+
+// ListProductUnits returns the units of a product
+func ListProductUnits(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+
+	var exists bool
+	database.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND store_id = $2)
+	`, productUUID, storeID).Scan(&exists)
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+
+	where := `product_id = $1`
+	if c.Query("active", "true") == "true" {
+		where += ` AND is_active = true`
+	}
+	units, err := queryProductUnits(database.DB, where, productUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product units",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    units,
+	})
+}
+
+// CreateProductUnit adds a unit to a product
+func CreateProductUnit(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+
+	var req models.CreateProductUnitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	// Lock the product so two requests can't add the same unit
+	var productName, baseUnit string
+	var hasVariants, decimalQuantity bool
+	err = tx.QueryRow(`
+		SELECT name, unit, variant_options IS NOT NULL, decimal_quantity FROM products
+		WHERE id = $1 AND store_id = $2 AND is_active = true
+		FOR UPDATE
+	`, productUUID, storeID).Scan(&productName, &baseUnit, &hasVariants, &decimalQuantity)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product",
+		})
+	}
+	if hasVariants {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Units of " + productName + " are set per variant",
+		})
+	}
+	if err := checkUnitName(tx, productUUID, nil, baseUnit, req.Name); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := checkQuantity(productName, decimalQuantity, req.Factor); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	var u models.ProductUnit
+	err = tx.QueryRow(`
+		INSERT INTO product_units (product_id, name, factor, barcode, price)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+productUnitColumns,
+		productUUID, strings.TrimSpace(req.Name), req.Factor, req.Barcode, req.Price,
+	).Scan(&u.ID, &u.ProductID, &u.Name, &u.Factor, &u.Barcode, &u.Price, &u.IsActive, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create product unit: " + err.Error(),
+		})
+	}
+
+	// Touch the product so terminals pick up the new unit on their next sync
+	if _, err := tx.Exec(`UPDATE products SET updated_at = NOW() WHERE id = $1`, productUUID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update product",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create product unit",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    u,
+	})
+}
+
+// UpdateProductUnit updates a unit of a product
+func UpdateProductUnit(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+	unitUUID, err := uuid.Parse(c.Params("unitId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid unit ID",
+		})
+	}
+
+	var req models.UpdateProductUnitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var productName, baseUnit string
+	var decimalQuantity bool
+	err = tx.QueryRow(`
+		SELECT name, unit, decimal_quantity FROM products
+		WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, productUUID, storeID).Scan(&productName, &baseUnit, &decimalQuantity)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+	if req.Name != nil {
+		if err := checkUnitName(tx, productUUID, &unitUUID, baseUnit, *req.Name); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		name := strings.TrimSpace(*req.Name)
+		req.Name = &name
+	}
+	if req.Factor != nil {
+		if err := checkQuantity(productName, decimalQuantity, *req.Factor); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	var u models.ProductUnit
+	err = tx.QueryRow(`
+		UPDATE product_units SET
+			name = COALESCE($3, name),
+			factor = COALESCE($4, factor),
+			barcode = COALESCE($5, barcode),
+			price = COALESCE($6, price),
+			is_active = COALESCE($7, is_active),
+			updated_at = NOW()
+		WHERE id = $1 AND product_id = $2
+		RETURNING `+productUnitColumns,
+		unitUUID, productUUID, req.Name, req.Factor, req.Barcode, req.Price, req.IsActive,
+	).Scan(&u.ID, &u.ProductID, &u.Name, &u.Factor, &u.Barcode, &u.Price, &u.IsActive, &u.CreatedAt, &u.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product unit not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update product unit: " + err.Error(),
+		})
+	}
+
+	if _, err := tx.Exec(`UPDATE products SET updated_at = NOW() WHERE id = $1`, productUUID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update product",
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update product unit",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    u,
+	})
+}
+
+// DeleteProductUnit deactivates a unit of a product. Past sales keep their
+// unit name and factor.
+func DeleteProductUnit(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+	unitUUID, err := uuid.Parse(c.Params("unitId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid unit ID",
+		})
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE product_units SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND product_id = $2
+		  AND product_id IN (SELECT id FROM products WHERE store_id = $3)
+	`, unitUUID, productUUID, storeID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete product unit",
+		})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product unit not found",
+		})
+	}
+
+	database.DB.Exec(`UPDATE products SET updated_at = NOW() WHERE id = $1`, productUUID)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Product unit deleted successfully",
+	})
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"kasirku/internal/database"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// queryVariants returns the variant products matching where
+func queryVariants(q queryer, where string, args ...interface{}) ([]models.Product, error) {
+	rows, err := q.Query(`
+		SELECT p.id, p.store_id, p.category_id, p.name, p.barcode, p.sku, p.description,
+		       p.price, p.cost, p.stock, p.min_stock, p.unit, p.image_url, p.is_active,
+		       p.track_stock, p.decimal_quantity, p.parent_id, p.variant_options, p.option_values,
+		       p.created_at, p.updated_at, c.name as category_name
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.id
+		WHERE `+where+`
+		ORDER BY p.name ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []models.Product{}
+	for rows.Next() {
+		var p models.Product
+		if err := rows.Scan(
+			&p.ID, &p.StoreID, &p.CategoryID, &p.Name, &p.Barcode, &p.SKU, &p.Description,
+			&p.Price, &p.Cost, &p.Stock, &p.MinStock, &p.Unit, &p.ImageURL, &p.IsActive,
+			&p.TrackStock, &p.DecimalQuantity, &p.ParentID, &p.VariantOptions, &p.OptionValues,
+			&p.CreatedAt, &p.UpdatedAt, &p.CategoryName,
+		); err != nil {
+			return nil, err
+		}
+		variants = append(variants, p)
+	}
+	return variants, rows.Err()
+}
+
+// attachVariants fills in the variants of the parent products in products
+func attachVariants(q queryer, products []models.Product, activeOnly bool) error {
+	index := map[uuid.UUID]int{}
+	var ids []string
+	for i, p := range products {
+		if len(p.VariantOptions) > 0 {
+			index[p.ID] = i
+			ids = append(ids, p.ID.String())
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	where := `p.parent_id = ANY($1::uuid[])`
+	if activeOnly {
+		where += ` AND p.is_active = true`
+	}
+	variants, err := queryVariants(q, where, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, v := range variants {
+		p := &products[index[*v.ParentID]]
+		p.Variants = append(p.Variants, v)
+	}
+	return nil
+}
+
+// checkVariantOptions rejects option axes with repeated names or values
+func checkVariantOptions(options models.VariantOptions) error {
+	names := map[string]bool{}
+	for _, opt := range options {
+		name := strings.ToLower(strings.TrimSpace(opt.Name))
+		if name == "" {
+			return fmt.Errorf("Option name is required")
+		}
+		if names[name] {
+			return fmt.Errorf("Option %s is listed twice", opt.Name)
+		}
+		names[name] = true
+
+		values := map[string]bool{}
+		for _, v := range opt.Values {
+			if values[v] {
+				return fmt.Errorf("Value %s is listed twice for option %s", v, opt.Name)
+			}
+			values[v] = true
+		}
+	}
+	return nil
+}
+
+// variantName names a variant after its parent and option values, in the
+// order of the parent's options, e.g. "Kaos Polos M / Merah"
+func variantName(parentName string, options models.VariantOptions, values models.OptionValues) string {
+	parts := make([]string, 0, len(options))
+	for _, opt := range options {
+		parts = append(parts, values[opt.Name])
+	}
+	return parentName + " " + strings.Join(parts, " / ")
+}
+
+// ListProductVariants returns the variants of a parent product
+func ListProductVariants(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+
+	var exists bool
+	database.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND store_id = $2)
+	`, productUUID, storeID).Scan(&exists)
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+
+	where := `p.parent_id = $1`
+	if c.Query("active", "true") == "true" {
+		where += ` AND p.is_active = true`
+	}
+	variants, err := queryVariants(database.DB, where, productUUID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product variants",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    variants,
+	})
+}
+
+// CreateProductVariant adds a variant to a parent product. The variant is a
+// product of its own with its own barcode, SKU, price and stock; anything
+// not given is copied from the parent.
+func CreateProductVariant(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	productUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+	}
+
+	var req models.CreateVariantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	// Lock the parent so two requests can't add the same combination
+	var parent models.Product
+	err = tx.QueryRow(`
+		SELECT category_id, name, description, price, cost, min_stock, unit, image_url, variant_options,
+		       decimal_quantity
+		FROM products
+		WHERE id = $1 AND store_id = $2 AND is_active = true
+		FOR UPDATE
+	`, productUUID, storeID).Scan(
+		&parent.CategoryID, &parent.Name, &parent.Description, &parent.Price, &parent.Cost,
+		&parent.MinStock, &parent.Unit, &parent.ImageURL, &parent.VariantOptions,
+		&parent.DecimalQuantity,
+	)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch product",
+		})
+	}
+
+	if len(parent.VariantOptions) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Product has no variant options",
+		})
+	}
+	if err := parent.VariantOptions.Check(req.OptionValues); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	var duplicate bool
+	tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM products
+			WHERE parent_id = $1 AND is_active = true AND option_values = $2::jsonb
+		)
+	`, productUUID, req.OptionValues).Scan(&duplicate)
+	if duplicate {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "A variant with these options already exists",
+		})
+	}
+
+	name := variantName(parent.Name, parent.VariantOptions, req.OptionValues)
+	if req.Name != nil && *req.Name != "" {
+		name = *req.Name
+	}
+	if err := checkQuantity(name, parent.DecimalQuantity, req.Stock); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	price := parent.Price
+	if req.Price != nil {
+		price = *req.Price
+	}
+	cost := parent.Cost
+	if req.Cost != nil {
+		cost = *req.Cost
+	}
+	minStock := parent.MinStock
+	if req.MinStock != nil {
+		minStock = *req.MinStock
+	}
+	imageURL := parent.ImageURL
+	if req.ImageURL != nil {
+		imageURL = req.ImageURL
+	}
+	trackStock := true
+	if req.TrackStock != nil {
+		trackStock = *req.TrackStock
+	}
+
+	var p models.Product
+	err = tx.QueryRow(`
+		INSERT INTO products (store_id, category_id, name, barcode, sku, description,
+		                      price, cost, stock, min_stock, unit, image_url, track_stock,
+		                      parent_id, option_values, decimal_quantity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		RETURNING id, store_id, category_id, name, barcode, sku, description,
+		          price, cost, stock, min_stock, unit, image_url, is_active,
+		          track_stock, decimal_quantity, parent_id, variant_options, option_values,
+		          created_at, updated_at
+	`, storeID, parent.CategoryID, name, req.Barcode, req.SKU, parent.Description,
+		price, cost, req.Stock, minStock, parent.Unit, imageURL, trackStock,
+		productUUID, req.OptionValues, parent.DecimalQuantity).Scan(
+		&p.ID, &p.StoreID, &p.CategoryID, &p.Name, &p.Barcode, &p.SKU, &p.Description,
+		&p.Price, &p.Cost, &p.Stock, &p.MinStock, &p.Unit, &p.ImageURL, &p.IsActive,
+		&p.TrackStock, &p.DecimalQuantity, &p.ParentID, &p.VariantOptions, &p.OptionValues,
+		&p.CreatedAt, &p.UpdatedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create variant: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create variant",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    p,
+	})
+}
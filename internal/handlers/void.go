@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"kasirku/internal/database"
+	"kasirku/internal/middleware"
+	"kasirku/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RequestVoid asks for a transaction to be cancelled. Owners and managers
+// have their request approved straight away; cashiers wait for approval.
+func RequestVoid(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+	transactionID := c.Params("id")
+
+	txUUID, err := uuid.Parse(transactionID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid transaction ID",
+		})
+	}
+
+	var req models.VoidTransactionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var status string
+	var refundedAmount models.Money
+	err = tx.QueryRow(`
+		SELECT status, refunded_amount FROM transactions
+		WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, txUUID, storeID).Scan(&status, &refundedAmount)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Transaction not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch transaction",
+		})
+	}
+
+	if status != "completed" || refundedAmount > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Only completed transactions without refunds can be voided",
+		})
+	}
+
+	var void models.TransactionVoid
+	err = tx.QueryRow(`
+		INSERT INTO transaction_voids (transaction_id, store_id, reason_code, notes, requested_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, transaction_id, store_id, reason_code, notes, status, requested_by, created_at
+	`, txUUID, storeID, req.ReasonCode, req.Notes, userID).Scan(
+		&void.ID, &void.TransactionID, &void.StoreID, &void.ReasonCode, &void.Notes,
+		&void.Status, &void.RequestedBy, &void.CreatedAt,
+	)
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success": false,
+			"error":   "A void has already been requested for this transaction",
+		})
+	}
+
+	message := "Void requested, waiting for approval"
+	if middleware.IsOwnerOrManager(c) {
+		if err := approveVoid(tx, storeID, &void, userID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to void transaction: " + err.Error(),
+			})
+		}
+		message = "Transaction voided successfully"
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to complete operation",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    void,
+		"message": message,
+	})
+}
+
+// ListVoids returns void requests for a store
+func ListVoids(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+	status := c.Query("status", "pending")
+
+	query := `
+		SELECT v.id, v.transaction_id, v.store_id, v.reason_code, v.notes, v.status,
+		       v.requested_by, v.approved_by, v.decided_at, v.created_at,
+		       t.invoice_number, t.total, u.full_name
+		FROM transaction_voids v
+		JOIN transactions t ON v.transaction_id = t.id
+		LEFT JOIN users u ON v.requested_by = u.id
+		WHERE v.store_id = $1
+	`
+	args := []interface{}{storeID}
+	if status != "all" {
+		query += " AND v.status = $2"
+		args = append(args, status)
+	}
+	query += " ORDER BY v.created_at DESC LIMIT 100"
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch void requests",
+		})
+	}
+	defer rows.Close()
+
+	var voids []models.TransactionVoid
+	for rows.Next() {
+		var v models.TransactionVoid
+		rows.Scan(
+			&v.ID, &v.TransactionID, &v.StoreID, &v.ReasonCode, &v.Notes, &v.Status,
+			&v.RequestedBy, &v.ApprovedBy, &v.DecidedAt, &v.CreatedAt,
+			&v.InvoiceNumber, &v.Total, &v.RequestedByName,
+		)
+		voids = append(voids, v)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    voids,
+	})
+}
+
+// ApproveVoid approves a pending void request and cancels the transaction
+func ApproveVoid(c *fiber.Ctx) error {
+	return decideVoid(c, true)
+}
+
+// RejectVoid rejects a pending void request
+func RejectVoid(c *fiber.Ctx) error {
+	return decideVoid(c, false)
+}
+
+func decideVoid(c *fiber.Ctx, approve bool) error {
+	storeID := getStoreID(c)
+	userID := middleware.GetUserID(c)
+	voidID := c.Params("id")
+
+	voidUUID, err := uuid.Parse(voidID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid void ID",
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback()
+
+	var void models.TransactionVoid
+	err = tx.QueryRow(`
+		SELECT id, transaction_id, store_id, reason_code, notes, status, requested_by, created_at
+		FROM transaction_voids
+		WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, voidUUID, storeID).Scan(
+		&void.ID, &void.TransactionID, &void.StoreID, &void.ReasonCode, &void.Notes,
+		&void.Status, &void.RequestedBy, &void.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Void request not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch void request",
+		})
+	}
+
+	if void.Status != "pending" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Void request has already been decided",
+		})
+	}
+
+	message := "Transaction voided successfully"
+	if approve {
+		err = approveVoid(tx, storeID, &void, userID)
+	} else {
+		message = "Void request rejected"
+		err = tx.QueryRow(`
+			UPDATE transaction_voids SET status = 'rejected', approved_by = $2, decided_at = NOW()
+			WHERE id = $1
+			RETURNING status, approved_by, decided_at
+		`, void.ID, userID).Scan(&void.Status, &void.ApprovedBy, &void.DecidedAt)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update void request: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to complete operation",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    void,
+		"message": message,
+	})
+}
+
+// approveVoid cancels the transaction behind a void request: stock deducted
+// by the sale trigger goes back, customer stats are reversed and the free-plan
+// transaction counter is returned
+func approveVoid(tx *sql.Tx, storeID uuid.UUID, void *models.TransactionVoid, approverID uuid.UUID) error {
+	var customerID *uuid.UUID
+	var invoiceNumber, status string
+	var total, refundedAmount models.Money
+	err := tx.QueryRow(`
+		SELECT customer_id, invoice_number, total, refunded_amount, status
+		FROM transactions
+		WHERE id = $1 AND store_id = $2
+		FOR UPDATE
+	`, void.TransactionID, storeID).Scan(&customerID, &invoiceNumber, &total, &refundedAmount, &status)
+	if err != nil {
+		return err
+	}
+	if status != "completed" || refundedAmount > 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "transaction can no longer be voided")
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, product_id, (quantity - refunded_quantity) * unit_factor, quantity * unit_factor
+		FROM transaction_items
+		WHERE transaction_id = $1 AND product_id IS NOT NULL
+	`, void.TransactionID)
+	if err != nil {
+		return err
+	}
+	type voidLine struct {
+		ItemID    uuid.UUID
+		ProductID uuid.UUID
+		Quantity  models.Quantity // in base units
+		Sold      models.Quantity // in base units
+	}
+	var lines []voidLine
+	for rows.Next() {
+		var l voidLine
+		rows.Scan(&l.ItemID, &l.ProductID, &l.Quantity, &l.Sold)
+		lines = append(lines, l)
+	}
+	rows.Close()
+
+	for _, l := range lines {
+		if l.Quantity <= 0 {
+			continue
+		}
+		if err := returnSaleStock(tx, storeID, l.ItemID, l.ProductID, l.Quantity, l.Sold, "return", void.ID, "void",
+			"Void: "+invoiceNumber, approverID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE transactions SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1
+	`, void.TransactionID); err != nil {
+		return err
+	}
+
+	if customerID != nil {
+		if _, err := tx.Exec(`
+			UPDATE customers SET
+				total_spent = GREATEST(total_spent - $2, 0),
+				total_transactions = GREATEST(total_transactions - 1, 0),
+				updated_at = NOW()
+			WHERE id = $1
+		`, customerID, total); err != nil {
+			return err
+		}
+	}
+
+	// A voided sale doesn't count as a use of its promo
+	if _, err := tx.Exec(`
+		UPDATE promos SET usage_count = GREATEST(usage_count - 1, 0), updated_at = NOW()
+		WHERE id = (SELECT promo_id FROM transactions WHERE id = $1)
+	`, void.TransactionID); err != nil {
+		return err
+	}
+
+	// Give back the slot check_transaction_limit took for this sale
+	if _, err := tx.Exec(`
+		UPDATE subscriptions SET
+			transaction_used = GREATEST(transaction_used - 1, 0),
+			updated_at = NOW()
+		WHERE plan = 'free' AND user_id = (SELECT user_id FROM stores WHERE id = $1)
+	`, storeID); err != nil {
+		return err
+	}
+
+	return tx.QueryRow(`
+		UPDATE transaction_voids SET status = 'approved', approved_by = $2, decided_at = NOW()
+		WHERE id = $1
+		RETURNING status, approved_by, decided_at
+	`, void.ID, approverID).Scan(&void.Status, &void.ApprovedBy, &void.DecidedAt)
+}
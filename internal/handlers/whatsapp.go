@@ -63,13 +63,13 @@ func SendReceipt(c *fiber.Ctx) error {
 	// Get transaction with items
 	var transaction models.Transaction
 	err = database.DB.QueryRow(`
-		SELECT id, invoice_number, subtotal, discount_amount, tax_amount, total,
+		SELECT id, invoice_number, subtotal, discount_amount, service_charge, tax_amount, rounding_adjustment, total,
 		       payment_amount, change_amount, payment_type, created_at
 		FROM transactions
 		WHERE id = $1 AND store_id = $2
 	`, req.TransactionID, storeID).Scan(
 		&transaction.ID, &transaction.InvoiceNumber, &transaction.Subtotal,
-		&transaction.DiscountAmount, &transaction.TaxAmount, &transaction.Total,
+		&transaction.DiscountAmount, &transaction.ServiceCharge, &transaction.TaxAmount, &transaction.RoundingAdjustment, &transaction.Total,
 		&transaction.PaymentAmount, &transaction.ChangeAmount, &transaction.PaymentType,
 		&transaction.CreatedAt,
 	)
@@ -80,19 +80,9 @@ func SendReceipt(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get transaction items
-	rows, _ := database.DB.Query(`
-		SELECT product_name, product_price, quantity, subtotal
-		FROM transaction_items
-		WHERE transaction_id = $1
-	`, req.TransactionID)
-	defer rows.Close()
-
-	for rows.Next() {
-		var item models.TransactionItem
-		rows.Scan(&item.ProductName, &item.ProductPrice, &item.Quantity, &item.Subtotal)
-		transaction.Items = append(transaction.Items, item)
-	}
+	transaction.Items = loadTransactionItems(req.TransactionID)
+	transaction.Payments = loadTransactionPayments(req.TransactionID)
+	transaction.Taxes = loadTransactionTaxes(req.TransactionID)
 
 	// Generate receipt message
 	message := services.GenerateReceiptMessage(storeName, &transaction)
@@ -191,15 +181,15 @@ func SendStockAlert(c *fiber.Ctx) error {
 
 	var products []struct {
 		Name     string
-		Stock    int
-		MinStock int
+		Stock    models.Quantity
+		MinStock models.Quantity
 		Unit     string
 	}
 	for rows.Next() {
 		var p struct {
 			Name     string
-			Stock    int
-			MinStock int
+			Stock    models.Quantity
+			MinStock models.Quantity
 			Unit     string
 		}
 		rows.Scan(&p.Name, &p.Stock, &p.MinStock, &p.Unit)
@@ -245,6 +235,113 @@ func SendStockAlert(c *fiber.Ctx) error {
 	})
 }
 
+// SendExpiryAlert sends an alert of stock expiring within the given number
+// of days (30 by default) via WhatsApp
+func SendExpiryAlert(c *fiber.Ctx) error {
+	storeID := getStoreID(c)
+
+	var req struct {
+		Phone string `json:"phone" validate:"required"`
+		Days  *int   `json:"days,omitempty" validate:"omitempty,min=0"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	days := 30
+	if req.Days != nil {
+		days = *req.Days
+	}
+
+	// Get store info
+	var storeName, provider, apiKey string
+	err := database.DB.QueryRow(`
+		SELECT name, whatsapp_provider, COALESCE(whatsapp_api_key, '')
+		FROM stores WHERE id = $1
+	`, storeID).Scan(&storeName, &provider, &apiKey)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Store not found",
+		})
+	}
+
+	// Default provider if empty
+	if provider == "" {
+		provider = "fonnte"
+	}
+
+	// Fallback to config if apiKey is empty
+	if apiKey == "" {
+		if provider == "wablas" {
+			apiKey = config.AppConfig.WablasAPIKey
+		} else {
+			apiKey = config.AppConfig.FonnteAPIKey
+		}
+	}
+
+	if apiKey == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "WhatsApp API key not configured in store or server config",
+		})
+	}
+
+	batches, err := expiringStockBatches(database.DB, storeID, days)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch expiring stock",
+		})
+	}
+
+	if len(batches) == 0 {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "No expiring stock to alert",
+		})
+	}
+
+	// Generate alert message
+	message := services.GenerateExpiryAlert(storeName, batches)
+
+	// Send via WhatsApp
+	waService := services.NewWhatsAppService(provider, apiKey)
+	messageID, err := waService.SendMessage(req.Phone, message)
+
+	status := "sent"
+	errorMsg := ""
+	if err != nil {
+		status = "failed"
+		errorMsg = err.Error()
+	}
+
+	// Log the message
+	services.LogMessage(storeID, req.Phone, "stock_alert", message, status, provider, messageID, errorMsg, nil, nil)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to send message: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":       true,
+		"message":       "Expiry alert sent successfully",
+		"message_id":    messageID,
+		"batches_count": len(batches),
+	})
+}
+
 // SendBroadcast sends a promo broadcast to customers
 func SendBroadcast(c *fiber.Ctx) error {
 	storeID := getStoreID(c)
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"kasirku/internal/config"
+	"kasirku/internal/database"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -147,3 +148,39 @@ func OwnerOnlyMiddleware() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// IsOwnerOrManager reports whether the current user owns the store or is an
+// active manager on its staff
+func IsOwnerOrManager(c *fiber.Ctx) bool {
+	role := GetUserRole(c)
+	if role == "owner" || role == "admin" {
+		return true
+	}
+
+	storeID, ok := c.Locals("storeID").(uuid.UUID)
+	if !ok {
+		return false
+	}
+
+	var isManager bool
+	database.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM staff
+			WHERE user_id = $1 AND store_id = $2 AND role = 'manager' AND is_active = true
+		)
+	`, GetUserID(c), storeID).Scan(&isManager)
+	return isManager
+}
+
+// ManagerOrOwnerMiddleware restricts access to store owners and managers
+func ManagerOrOwnerMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !IsOwnerOrManager(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success": false,
+				"error":   "Owner or manager access required",
+			})
+		}
+		return c.Next()
+	}
+}
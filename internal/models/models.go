@@ -48,10 +48,24 @@ type Store struct {
 	WhatsAppAPIKey   *string   `json:"whatsapp_api_key,omitempty"`
 	WhatsAppProvider string    `json:"whatsapp_provider"`
 	TaxRate          float64   `json:"tax_rate"`
-	Currency         string    `json:"currency"`
-	IsActive         bool      `json:"is_active"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	// NegativeStockPolicy decides what happens when a sale needs more stock
+	// than is on hand: block, allow, or warn (allow and flag the sale)
+	NegativeStockPolicy string `json:"negative_stock_policy"`
+	// Invoice number template: PREFIX-DATE-0001, with the counter reset
+	// daily, monthly or never
+	InvoicePrefix     *string `json:"invoice_prefix,omitempty"`
+	InvoiceDateFormat string  `json:"invoice_date_format"`
+	InvoicePadding    int     `json:"invoice_padding"`
+	InvoiceReset      string  `json:"invoice_reset"`
+	// Totals are rounded to a multiple of RoundingIncrement (0 = off) with
+	// RoundingMode nearest, up or down; only for cash sales if RoundingCashOnly
+	RoundingIncrement Money     `json:"rounding_increment"`
+	RoundingMode      string    `json:"rounding_mode"`
+	RoundingCashOnly  bool      `json:"rounding_cash_only"`
+	Currency          string    `json:"currency"`
+	IsActive          bool      `json:"is_active"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
 // Category represents a product category
@@ -64,6 +78,7 @@ type Category struct {
 	SortOrder int       `json:"sort_order"`
 	IsActive  bool      `json:"is_active"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // Product represents a product in the store
@@ -75,18 +90,72 @@ type Product struct {
 	Barcode     *string    `json:"barcode,omitempty"`
 	SKU         *string    `json:"sku,omitempty"`
 	Description *string    `json:"description,omitempty"`
-	Price       float64    `json:"price"`
-	Cost        float64    `json:"cost"`
-	Stock       int        `json:"stock"`
-	MinStock    int        `json:"min_stock"`
+	Price       Money      `json:"price"`
+	Cost        Money      `json:"cost"`
+	Stock       Quantity   `json:"stock"`
+	MinStock    Quantity   `json:"min_stock"`
 	Unit        string     `json:"unit"`
 	ImageURL    *string    `json:"image_url,omitempty"`
 	IsActive    bool       `json:"is_active"`
 	TrackStock  bool       `json:"track_stock"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	// DecimalQuantity lets weighed and measured goods be sold in fractions,
+	// e.g. 0.75 kg; otherwise quantities must be whole
+	DecimalQuantity bool `json:"decimal_quantity"`
+	// A parent product has VariantOptions and is sold through its variants.
+	// A variant has ParentID and its own OptionValues, stock and barcode.
+	ParentID       *uuid.UUID     `json:"parent_id,omitempty"`
+	VariantOptions VariantOptions `json:"variant_options,omitempty"`
+	OptionValues   OptionValues   `json:"option_values,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
 	// Joined fields
-	CategoryName *string `json:"category_name,omitempty"`
+	CategoryName *string       `json:"category_name,omitempty"`
+	Variants     []Product     `json:"variants,omitempty"`
+	Units        []ProductUnit `json:"units,omitempty"`
+}
+
+// ProductUnit is another unit a product is sold or received in, holding
+// Factor of the product's base unit, e.g. a "dus" of 24 "pcs"
+type ProductUnit struct {
+	ID        uuid.UUID `json:"id"`
+	ProductID uuid.UUID `json:"product_id"`
+	Name      string    `json:"name"`
+	Factor    Quantity  `json:"factor"`
+	Barcode   *string   `json:"barcode,omitempty"`
+	Price     Money     `json:"price"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ScaleBarcodePattern describes the EAN-13 labels a store's scales print:
+// Prefix, then a PLU of PLULength digits matching a product's SKU, and a
+// weight or price in the ValueLength digits before the check digit, with
+// ValueDecimals of them after the decimal point. Digits in between, such as
+// a price check digit, are ignored.
+type ScaleBarcodePattern struct {
+	ID            uuid.UUID `json:"id"`
+	StoreID       uuid.UUID `json:"store_id"`
+	Name          string    `json:"name"`
+	Prefix        string    `json:"prefix"`
+	PLULength     int       `json:"plu_length"`
+	ValueType     string    `json:"value_type"` // weight, price
+	ValueLength   int       `json:"value_length"`
+	ValueDecimals int       `json:"value_decimals"`
+	IsActive      bool      `json:"is_active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ScaleBarcode is what a scanned scale label holds. A weight label gives the
+// Quantity directly; a price label gives Price, and Quantity is how much of
+// the product that price buys.
+type ScaleBarcode struct {
+	PatternID uuid.UUID `json:"pattern_id"`
+	PLU       string    `json:"plu"`
+	ValueType string    `json:"value_type"`
+	Quantity  Quantity  `json:"quantity"`
+	Price     *Money    `json:"price,omitempty"`
 }
 
 // StockMovement represents a stock movement record
@@ -95,9 +164,10 @@ type StockMovement struct {
 	ProductID     uuid.UUID  `json:"product_id"`
 	StoreID       uuid.UUID  `json:"store_id"`
 	Type          string     `json:"type"`
-	Quantity      int        `json:"quantity"`
-	StockBefore   int        `json:"stock_before"`
-	StockAfter    int        `json:"stock_after"`
+	Quantity      Quantity   `json:"quantity"`
+	StockBefore   Quantity   `json:"stock_before"`
+	StockAfter    Quantity   `json:"stock_after"`
+	UnitCost      *Money     `json:"unit_cost,omitempty"` // per base unit, for stock received at a known cost
 	ReferenceID   *uuid.UUID `json:"reference_id,omitempty"`
 	ReferenceType *string    `json:"reference_type,omitempty"`
 	Notes         *string    `json:"notes,omitempty"`
@@ -107,6 +177,129 @@ type StockMovement struct {
 	ProductName *string `json:"product_name,omitempty"`
 }
 
+// StockBatch is stock of a product received under one batch number or
+// expiry date. Quantity is what is left of it.
+type StockBatch struct {
+	ID               uuid.UUID `json:"id"`
+	ProductID        uuid.UUID `json:"product_id"`
+	StoreID          uuid.UUID `json:"store_id"`
+	BatchNumber      *string   `json:"batch_number,omitempty"`
+	ExpiryDate       *string   `json:"expiry_date,omitempty"` // YYYY-MM-DD
+	ReceivedQuantity Quantity  `json:"received_quantity"`
+	Quantity         Quantity  `json:"quantity"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	// Joined fields
+	ProductName string  `json:"product_name"`
+	Unit        string  `json:"unit"`
+	DaysLeft    *int    `json:"days_left,omitempty"` // negative once expired
+	Value       Money   `json:"value"`               // Quantity at the product's cost
+	Barcode     *string `json:"barcode,omitempty"`
+}
+
+// StockOpname is a physical stocktake session
+type StockOpname struct {
+	ID         uuid.UUID  `json:"id"`
+	StoreID    uuid.UUID  `json:"store_id"`
+	CategoryID *uuid.UUID `json:"category_id,omitempty"`
+	Status     string     `json:"status"` // open, finalized, cancelled
+	Notes      *string    `json:"notes,omitempty"`
+	CreatedBy  *uuid.UUID `json:"created_by,omitempty"`
+	ClosedBy   *uuid.UUID `json:"closed_by,omitempty"`
+	ClosedAt   *time.Time `json:"closed_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Joined fields
+	ItemCount    int               `json:"item_count"`
+	CountedCount int               `json:"counted_count"`
+	Items        []StockOpnameItem `json:"items,omitempty"`
+}
+
+// StockOpnameItem is one product of a stocktake. Variance is what was
+// counted less the stock when the session opened, once counted.
+type StockOpnameItem struct {
+	ID              uuid.UUID  `json:"id"`
+	OpnameID        uuid.UUID  `json:"opname_id"`
+	ProductID       uuid.UUID  `json:"product_id"`
+	ProductName     string     `json:"product_name"`
+	SystemStock     Quantity   `json:"system_stock"`
+	CountedQuantity *Quantity  `json:"counted_quantity,omitempty"`
+	Variance        *Quantity  `json:"variance,omitempty"`
+	CountedBy       *uuid.UUID `json:"counted_by,omitempty"`
+	CountedAt       *time.Time `json:"counted_at,omitempty"`
+	// Joined fields
+	Barcode       *string `json:"barcode,omitempty"`
+	Unit          string  `json:"unit"`
+	Cost          Money   `json:"cost"`
+	VarianceValue *Money  `json:"variance_value,omitempty"` // Variance at Cost
+}
+
+// StockOpnameVarianceReport sums up the differences found by a stocktake
+type StockOpnameVarianceReport struct {
+	Opname            StockOpname       `json:"opname"`
+	TotalItems        int               `json:"total_items"`
+	CountedItems      int               `json:"counted_items"`
+	ItemsWithVariance int               `json:"items_with_variance"`
+	ShortageValue     Money             `json:"shortage_value"`
+	SurplusValue      Money             `json:"surplus_value"`
+	NetValue          Money             `json:"net_value"`
+	Items             []StockOpnameItem `json:"items"`
+	Uncounted         []StockOpnameItem `json:"uncounted"`
+}
+
+// Supplier is a vendor the store buys stock from
+type Supplier struct {
+	ID          uuid.UUID `json:"id"`
+	StoreID     uuid.UUID `json:"store_id"`
+	Name        string    `json:"name"`
+	ContactName *string   `json:"contact_name,omitempty"`
+	Phone       *string   `json:"phone,omitempty"`
+	Email       *string   `json:"email,omitempty"`
+	Address     *string   `json:"address,omitempty"`
+	Notes       *string   `json:"notes,omitempty"`
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PurchaseOrder is an order of stock from a supplier. Total is what the
+// order is expected to cost.
+type PurchaseOrder struct {
+	ID         uuid.UUID  `json:"id"`
+	StoreID    uuid.UUID  `json:"store_id"`
+	SupplierID uuid.UUID  `json:"supplier_id"`
+	PONumber   string     `json:"po_number"`
+	Status     string     `json:"status"` // draft, sent, partially_received, received, cancelled
+	ExpectedAt *time.Time `json:"expected_at,omitempty"`
+	Notes      *string    `json:"notes,omitempty"`
+	Total      Money      `json:"total"`
+	CreatedBy  *uuid.UUID `json:"created_by,omitempty"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+	ReceivedAt *time.Time `json:"received_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	// Joined fields
+	SupplierName string              `json:"supplier_name"`
+	Items        []PurchaseOrderItem `json:"items,omitempty"`
+}
+
+// PurchaseOrderItem is one ordered product. Quantity, ReceivedQuantity and
+// UnitCost are in the unit given by UnitID, or the product's base unit
+// without one; Outstanding is what is still to be delivered.
+type PurchaseOrderItem struct {
+	ID               uuid.UUID  `json:"id"`
+	PurchaseOrderID  uuid.UUID  `json:"purchase_order_id"`
+	ProductID        uuid.UUID  `json:"product_id"`
+	UnitID           *uuid.UUID `json:"unit_id,omitempty"`
+	ProductName      string     `json:"product_name"`
+	UnitName         string     `json:"unit_name"`
+	UnitFactor       Quantity   `json:"unit_factor"`
+	Quantity         Quantity   `json:"quantity"`
+	ReceivedQuantity Quantity   `json:"received_quantity"`
+	UnitCost         Money      `json:"unit_cost"`
+	Subtotal         Money      `json:"subtotal"`
+	Outstanding      Quantity   `json:"outstanding"`
+}
+
 // Customer represents a store customer
 type Customer struct {
 	ID                uuid.UUID  `json:"id"`
@@ -117,7 +310,7 @@ type Customer struct {
 	Address           *string    `json:"address,omitempty"`
 	Notes             *string    `json:"notes,omitempty"`
 	TotalTransactions int        `json:"total_transactions"`
-	TotalSpent        float64    `json:"total_spent"`
+	TotalSpent        Money      `json:"total_spent"`
 	LastTransactionAt *time.Time `json:"last_transaction_at,omitempty"`
 	IsActive          bool       `json:"is_active"`
 	CreatedAt         time.Time  `json:"created_at"`
@@ -126,43 +319,254 @@ type Customer struct {
 
 // Transaction represents a sales transaction
 type Transaction struct {
-	ID               uuid.UUID  `json:"id"`
-	StoreID          uuid.UUID  `json:"store_id"`
-	CustomerID       *uuid.UUID `json:"customer_id,omitempty"`
-	CashierID        *uuid.UUID `json:"cashier_id,omitempty"`
-	InvoiceNumber    string     `json:"invoice_number"`
-	Subtotal         float64    `json:"subtotal"`
-	DiscountAmount   float64    `json:"discount_amount"`
-	DiscountPercent  float64    `json:"discount_percent"`
-	TaxAmount        float64    `json:"tax_amount"`
-	Total            float64    `json:"total"`
-	PaymentAmount    float64    `json:"payment_amount"`
-	ChangeAmount     float64    `json:"change_amount"`
-	PaymentType      string     `json:"payment_type"`
-	PaymentReference *string    `json:"payment_reference,omitempty"`
-	Status           string     `json:"status"`
-	Notes            *string    `json:"notes,omitempty"`
-	CreatedAt        time.Time  `json:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at"`
+	ID                 uuid.UUID  `json:"id"`
+	StoreID            uuid.UUID  `json:"store_id"`
+	CustomerID         *uuid.UUID `json:"customer_id,omitempty"`
+	CashierID          *uuid.UUID `json:"cashier_id,omitempty"`
+	InvoiceNumber      string     `json:"invoice_number"`
+	Subtotal           Money      `json:"subtotal"`
+	DiscountAmount     Money      `json:"discount_amount"`
+	DiscountPercent    float64    `json:"discount_percent"`
+	ServiceCharge      Money      `json:"service_charge"`
+	TaxAmount          Money      `json:"tax_amount"`
+	RoundingAdjustment Money      `json:"rounding_adjustment"`
+	Total              Money      `json:"total"`
+	PaymentAmount      Money      `json:"payment_amount"`
+	ChangeAmount       Money      `json:"change_amount"`
+	PaymentType        string     `json:"payment_type"`
+	PaymentReference   *string    `json:"payment_reference,omitempty"`
+	Status             string     `json:"status"`
+	RefundedAmount     Money      `json:"refunded_amount"`
+	PromoID            *uuid.UUID `json:"promo_id,omitempty"`
+	PromoDiscount      Money      `json:"promo_discount"`
+	ShiftID            *uuid.UUID `json:"shift_id,omitempty"`
+	Notes              *string    `json:"notes,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	CompletedAt        *time.Time `json:"completed_at,omitempty"`
+	SoldAt             *time.Time `json:"sold_at,omitempty"`
+	UpdatedAt          time.Time  `json:"updated_at"`
 	// Joined/computed fields
-	Items        []TransactionItem `json:"items,omitempty"`
-	CustomerName *string           `json:"customer_name,omitempty"`
-	CashierName  *string           `json:"cashier_name,omitempty"`
+	Items        []TransactionItem    `json:"items,omitempty"`
+	Payments     []TransactionPayment `json:"payments,omitempty"`
+	Taxes        []TransactionTax     `json:"taxes,omitempty"`
+	CustomerName *string              `json:"customer_name,omitempty"`
+	CashierName  *string              `json:"cashier_name,omitempty"`
+	// Set on a new sale when the store's negative stock policy is "warn"
+	StockWarnings []string `json:"stock_warnings,omitempty"`
 }
 
 // TransactionItem represents an item in a transaction
 type TransactionItem struct {
-	ID              uuid.UUID  `json:"id"`
-	TransactionID   uuid.UUID  `json:"transaction_id"`
-	ProductID       *uuid.UUID `json:"product_id,omitempty"`
-	ProductName     string     `json:"product_name"`
-	ProductPrice    float64    `json:"product_price"`
-	Quantity        int        `json:"quantity"`
-	DiscountAmount  float64    `json:"discount_amount"`
-	DiscountPercent float64    `json:"discount_percent"`
-	Subtotal        float64    `json:"subtotal"`
-	Cost            float64    `json:"cost"`
-	CreatedAt       time.Time  `json:"created_at"`
+	ID               uuid.UUID  `json:"id"`
+	TransactionID    uuid.UUID  `json:"transaction_id"`
+	ProductID        *uuid.UUID `json:"product_id,omitempty"`
+	ProductName      string     `json:"product_name"`
+	ProductPrice     Money      `json:"product_price"`
+	Quantity         Quantity   `json:"quantity"`
+	DiscountAmount   Money      `json:"discount_amount"`
+	DiscountPercent  float64    `json:"discount_percent"`
+	Subtotal         Money      `json:"subtotal"`
+	Cost             Money      `json:"cost"`
+	RefundedQuantity Quantity   `json:"refunded_quantity"`
+	CreatedAt        time.Time  `json:"created_at"`
+	// ModifierAmount is the price of the chosen modifiers for one unit, so
+	// Subtotal = (ProductPrice + ModifierAmount) * Quantity - DiscountAmount
+	ModifierAmount Money                     `json:"modifier_amount"`
+	Modifiers      []TransactionItemModifier `json:"modifiers,omitempty"`
+	// Components of a bundle, per unit of the item
+	Components []TransactionItemComponent `json:"components,omitempty"`
+	// Unit the item was sold in when not the base unit; the stock it took
+	// is Quantity * UnitFactor base units
+	UnitID     *uuid.UUID `json:"unit_id,omitempty"`
+	UnitName   *string    `json:"unit_name,omitempty"`
+	UnitFactor Quantity   `json:"unit_factor"`
+}
+
+// TransactionItemModifier is a modifier chosen for a transaction item. Its
+// name and price are copied so later menu changes don't alter past sales.
+type TransactionItemModifier struct {
+	ID                uuid.UUID  `json:"id"`
+	TransactionItemID uuid.UUID  `json:"transaction_item_id"`
+	ModifierID        *uuid.UUID `json:"modifier_id,omitempty"`
+	GroupName         string     `json:"group_name"`
+	Name              string     `json:"name"`
+	Price             Money      `json:"price"`
+}
+
+// TransactionItemComponent is a product a sold bundle was made of. Its name
+// and quantity are copied so later bundle changes don't alter past sales.
+type TransactionItemComponent struct {
+	ID                uuid.UUID  `json:"id"`
+	TransactionItemID uuid.UUID  `json:"transaction_item_id"`
+	ProductID         *uuid.UUID `json:"product_id,omitempty"`
+	ProductName       string     `json:"product_name"`
+	Quantity          int        `json:"quantity"`
+}
+
+// TransactionPayment represents one payment line of a transaction
+type TransactionPayment struct {
+	ID            uuid.UUID `json:"id"`
+	TransactionID uuid.UUID `json:"transaction_id"`
+	PaymentType   string    `json:"payment_type"`
+	Amount        Money     `json:"amount"`
+	Reference     *string   `json:"reference,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TransactionTax represents one tax or service charge line of a transaction.
+// Inclusive lines are already part of the item prices and not added to the total.
+type TransactionTax struct {
+	ID            uuid.UUID  `json:"id"`
+	TransactionID uuid.UUID  `json:"transaction_id"`
+	TaxRuleID     *uuid.UUID `json:"tax_rule_id,omitempty"`
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	Rate          float64    `json:"rate"`
+	IsInclusive   bool       `json:"is_inclusive"`
+	TaxableAmount Money      `json:"taxable_amount"`
+	Amount        Money      `json:"amount"`
+}
+
+// TaxRule represents a store's tax or service charge
+type TaxRule struct {
+	ID                uuid.UUID   `json:"id"`
+	StoreID           uuid.UUID   `json:"store_id"`
+	Name              string      `json:"name"`
+	Type              string      `json:"type"`
+	Rate              float64     `json:"rate"`
+	IsInclusive       bool        `json:"is_inclusive"`
+	SortOrder         int         `json:"sort_order"`
+	IsActive          bool        `json:"is_active"`
+	ExemptProductIDs  []uuid.UUID `json:"exempt_product_ids"`
+	ExemptCategoryIDs []uuid.UUID `json:"exempt_category_ids"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+}
+
+// ModifierGroup is a set of modifiers (add-ons or preferences such as
+// "Extra shot" or "Less sugar") offered with the products and categories it
+// is attached to. Customers pick between MinSelect and MaxSelect of them; a
+// MinSelect above zero makes the group required and a MaxSelect of zero
+// means no limit.
+type ModifierGroup struct {
+	ID          uuid.UUID   `json:"id"`
+	StoreID     uuid.UUID   `json:"store_id"`
+	Name        string      `json:"name"`
+	MinSelect   int         `json:"min_select"`
+	MaxSelect   int         `json:"max_select"`
+	SortOrder   int         `json:"sort_order"`
+	IsActive    bool        `json:"is_active"`
+	Modifiers   []Modifier  `json:"modifiers"`
+	ProductIDs  []uuid.UUID `json:"product_ids"`
+	CategoryIDs []uuid.UUID `json:"category_ids"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// Modifier is one choice of a modifier group, added to the item price
+type Modifier struct {
+	ID        uuid.UUID `json:"id"`
+	GroupID   uuid.UUID `json:"group_id"`
+	Name      string    `json:"name"`
+	Price     Money     `json:"price"`
+	SortOrder int       `json:"sort_order"`
+	IsActive  bool      `json:"is_active"`
+}
+
+// Refund represents a full or partial refund of a transaction
+type Refund struct {
+	ID            uuid.UUID    `json:"id"`
+	TransactionID uuid.UUID    `json:"transaction_id"`
+	StoreID       uuid.UUID    `json:"store_id"`
+	Amount        Money        `json:"amount"`
+	CostAmount    Money        `json:"cost_amount"`
+	IsFull        bool         `json:"is_full"`
+	Reason        *string      `json:"reason,omitempty"`
+	ShiftID       *uuid.UUID   `json:"shift_id,omitempty"`
+	CreatedBy     *uuid.UUID   `json:"created_by,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Items         []RefundItem `json:"items,omitempty"`
+}
+
+// RefundItem represents a refunded quantity of a transaction item
+type RefundItem struct {
+	ID                uuid.UUID  `json:"id"`
+	RefundID          uuid.UUID  `json:"refund_id"`
+	TransactionItemID uuid.UUID  `json:"transaction_item_id"`
+	ProductID         *uuid.UUID `json:"product_id,omitempty"`
+	Quantity          Quantity   `json:"quantity"`
+	Amount            Money      `json:"amount"`
+	Cost              Money      `json:"cost"`
+}
+
+// TransactionVoid represents a request to cancel a transaction
+type TransactionVoid struct {
+	ID            uuid.UUID  `json:"id"`
+	TransactionID uuid.UUID  `json:"transaction_id"`
+	StoreID       uuid.UUID  `json:"store_id"`
+	ReasonCode    string     `json:"reason_code"`
+	Notes         *string    `json:"notes,omitempty"`
+	Status        string     `json:"status"`
+	RequestedBy   *uuid.UUID `json:"requested_by,omitempty"`
+	ApprovedBy    *uuid.UUID `json:"approved_by,omitempty"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	// Joined fields
+	InvoiceNumber   *string `json:"invoice_number,omitempty"`
+	Total           *Money  `json:"total,omitempty"`
+	RequestedByName *string `json:"requested_by_name,omitempty"`
+}
+
+// Shift represents a cashier's shift on the cash drawer
+type Shift struct {
+	ID             uuid.UUID  `json:"id"`
+	StoreID        uuid.UUID  `json:"store_id"`
+	CashierID      uuid.UUID  `json:"cashier_id"`
+	Status         string     `json:"status"`
+	OpeningCash    Money      `json:"opening_cash"`
+	ExpectedCash   *Money     `json:"expected_cash,omitempty"`
+	CountedCash    *Money     `json:"counted_cash,omitempty"`
+	CashDifference *Money     `json:"cash_difference,omitempty"`
+	Notes          *string    `json:"notes,omitempty"`
+	OpenedAt       time.Time  `json:"opened_at"`
+	ClosedAt       *time.Time `json:"closed_at,omitempty"`
+	ClosedBy       *uuid.UUID `json:"closed_by,omitempty"`
+	// Joined fields
+	CashierName *string `json:"cashier_name,omitempty"`
+}
+
+// ShiftCashMovement represents petty cash put into or taken out of the drawer
+type ShiftCashMovement struct {
+	ID        uuid.UUID  `json:"id"`
+	ShiftID   uuid.UUID  `json:"shift_id"`
+	Type      string     `json:"type"`
+	Amount    Money      `json:"amount"`
+	Reason    *string    `json:"reason,omitempty"`
+	CreatedBy *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Promo represents a promotion or voucher code.
+// For buy_x_get_y promos, BuyQuantity units of the product earn GetQuantity free.
+type Promo struct {
+	ID          uuid.UUID  `json:"id"`
+	StoreID     uuid.UUID  `json:"store_id"`
+	Name        string     `json:"name"`
+	Code        *string    `json:"code,omitempty"`
+	Type        string     `json:"type"`
+	Value       float64    `json:"value"`
+	ProductID   *uuid.UUID `json:"product_id,omitempty"`
+	BuyQuantity int        `json:"buy_quantity"`
+	GetQuantity int        `json:"get_quantity"`
+	MinPurchase Money      `json:"min_purchase"`
+	MaxDiscount *Money     `json:"max_discount,omitempty"`
+	StartDate   *time.Time `json:"start_date,omitempty"`
+	EndDate     *time.Time `json:"end_date,omitempty"`
+	UsageLimit  *int       `json:"usage_limit,omitempty"`
+	UsageCount  int        `json:"usage_count"`
+	IsActive    bool       `json:"is_active"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
 // WhatsAppLog represents a WhatsApp message log
@@ -217,14 +621,22 @@ type CreateStoreRequest struct {
 
 // UpdateStoreRequest for updating a store
 type UpdateStoreRequest struct {
-	Name             *string  `json:"name,omitempty"`
-	Address          *string  `json:"address,omitempty"`
-	Phone            *string  `json:"phone,omitempty"`
-	Email            *string  `json:"email,omitempty"`
-	LogoURL          *string  `json:"logo_url,omitempty"`
-	WhatsAppAPIKey   *string  `json:"whatsapp_api_key,omitempty"`
-	WhatsAppProvider *string  `json:"whatsapp_provider,omitempty"`
-	TaxRate          *float64 `json:"tax_rate,omitempty"`
+	Name                *string  `json:"name,omitempty"`
+	Address             *string  `json:"address,omitempty"`
+	Phone               *string  `json:"phone,omitempty"`
+	Email               *string  `json:"email,omitempty"`
+	LogoURL             *string  `json:"logo_url,omitempty"`
+	WhatsAppAPIKey      *string  `json:"whatsapp_api_key,omitempty"`
+	WhatsAppProvider    *string  `json:"whatsapp_provider,omitempty"`
+	TaxRate             *float64 `json:"tax_rate,omitempty"`
+	NegativeStockPolicy *string  `json:"negative_stock_policy,omitempty" validate:"omitempty,oneof=block allow warn"`
+	InvoicePrefix       *string  `json:"invoice_prefix,omitempty" validate:"omitempty,max=20,alphanum"`
+	InvoiceDateFormat   *string  `json:"invoice_date_format,omitempty" validate:"omitempty,oneof=YYYYMMDD YYMMDD YYYYMM YYMM NONE"`
+	InvoicePadding      *int     `json:"invoice_padding,omitempty" validate:"omitempty,min=1,max=10"`
+	InvoiceReset        *string  `json:"invoice_reset,omitempty" validate:"omitempty,oneof=daily monthly never"`
+	RoundingIncrement   *Money   `json:"rounding_increment,omitempty" validate:"omitempty,min=0"`
+	RoundingMode        *string  `json:"rounding_mode,omitempty" validate:"omitempty,oneof=nearest up down"`
+	RoundingCashOnly    *bool    `json:"rounding_cash_only,omitempty"`
 }
 
 // CreateProductRequest for creating a product
@@ -234,13 +646,18 @@ type CreateProductRequest struct {
 	Barcode     *string    `json:"barcode,omitempty"`
 	SKU         *string    `json:"sku,omitempty"`
 	Description *string    `json:"description,omitempty"`
-	Price       float64    `json:"price" validate:"required,min=0"`
-	Cost        float64    `json:"cost,omitempty"`
-	Stock       int        `json:"stock,omitempty"`
-	MinStock    int        `json:"min_stock,omitempty"`
+	Price       Money      `json:"price" validate:"required,min=0"`
+	Cost        Money      `json:"cost,omitempty"`
+	Stock       Quantity   `json:"stock,omitempty"`
+	MinStock    Quantity   `json:"min_stock,omitempty"`
 	Unit        string     `json:"unit,omitempty"`
 	ImageURL    *string    `json:"image_url,omitempty"`
 	TrackStock  *bool      `json:"track_stock,omitempty"`
+	// DecimalQuantity allows fractional quantities, for goods sold by weight
+	// or length
+	DecimalQuantity bool `json:"decimal_quantity,omitempty"`
+	// Setting option axes makes this a parent product sold through variants
+	VariantOptions VariantOptions `json:"variant_options,omitempty" validate:"omitempty,dive"`
 }
 
 // UpdateProductRequest for updating a product
@@ -250,42 +667,435 @@ type UpdateProductRequest struct {
 	Barcode     *string    `json:"barcode,omitempty"`
 	SKU         *string    `json:"sku,omitempty"`
 	Description *string    `json:"description,omitempty"`
-	Price       *float64   `json:"price,omitempty"`
-	Cost        *float64   `json:"cost,omitempty"`
-	MinStock    *int       `json:"min_stock,omitempty"`
+	Price       *Money     `json:"price,omitempty"`
+	Cost        *Money     `json:"cost,omitempty"`
+	MinStock    *Quantity  `json:"min_stock,omitempty"`
 	Unit        *string    `json:"unit,omitempty"`
 	ImageURL    *string    `json:"image_url,omitempty"`
 	IsActive    *bool      `json:"is_active,omitempty"`
 	TrackStock  *bool      `json:"track_stock,omitempty"`
+	// Turning DecimalQuantity off is refused while the stock is fractional
+	DecimalQuantity *bool `json:"decimal_quantity,omitempty"`
+	// An empty list turns a parent back into a plain product once it has no
+	// active variants
+	VariantOptions *VariantOptions `json:"variant_options,omitempty" validate:"omitempty,dive"`
 }
 
-// StockAdjustRequest for stock adjustments
-type StockAdjustRequest struct {
+// CreateVariantRequest for adding a variant to a parent product. Fields left
+// out are copied from the parent.
+type CreateVariantRequest struct {
+	OptionValues OptionValues `json:"option_values" validate:"required"`
+	Name         *string      `json:"name,omitempty"`
+	Barcode      *string      `json:"barcode,omitempty"`
+	SKU          *string      `json:"sku,omitempty"`
+	Price        *Money       `json:"price,omitempty" validate:"omitempty,min=0"`
+	Cost         *Money       `json:"cost,omitempty"`
+	Stock        Quantity     `json:"stock,omitempty"`
+	MinStock     *Quantity    `json:"min_stock,omitempty"`
+	ImageURL     *string      `json:"image_url,omitempty"`
+	TrackStock   *bool        `json:"track_stock,omitempty"`
+}
+
+// RecipeItem is one ingredient of a composite product, per unit sold
+type RecipeItem struct {
+	IngredientID uuid.UUID `json:"ingredient_id"`
+	Quantity     Quantity  `json:"quantity"`
+	// Joined fields
+	IngredientName string   `json:"ingredient_name,omitempty"`
+	Unit           string   `json:"unit,omitempty"`
+	Cost           Money    `json:"cost"` // of Quantity at the ingredient's current cost
+	Stock          Quantity `json:"stock"`
+	TrackStock     bool     `json:"track_stock"`
+}
+
+// Recipe lists what a composite product is made of and what it costs
+type Recipe struct {
+	ProductID uuid.UUID    `json:"product_id"`
+	Items     []RecipeItem `json:"items"`
+	Cost      Money        `json:"cost"`
+}
+
+// SetRecipeRequest replaces a product's recipe. An empty list removes it.
+type SetRecipeRequest struct {
+	Items []RecipeItemRequest `json:"items" validate:"dive"`
+}
+
+// RecipeItemRequest for one ingredient of a recipe
+type RecipeItemRequest struct {
+	IngredientID uuid.UUID `json:"ingredient_id" validate:"required"`
+	Quantity     Quantity  `json:"quantity" validate:"gt=0"`
+}
+
+// BundleItem is one component of a bundle, per bundle sold
+type BundleItem struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	// Joined fields
+	ProductName string `json:"product_name,omitempty"`
+	Cost        Money  `json:"cost"` // of Quantity, by recipe for composite products
+}
+
+// Bundle lists the components of a bundle and what they cost
+type Bundle struct {
+	ProductID uuid.UUID    `json:"product_id"`
+	Items     []BundleItem `json:"items"`
+	Cost      Money        `json:"cost"`
+}
+
+// SetBundleRequest replaces a bundle's components. An empty list turns the
+// bundle back into a plain product.
+type SetBundleRequest struct {
+	Items []BundleItemRequest `json:"items" validate:"dive"`
+}
+
+// BundleItemRequest for one component of a bundle
+type BundleItemRequest struct {
 	ProductID uuid.UUID `json:"product_id" validate:"required"`
-	Type      string    `json:"type" validate:"oneof=in out adjustment"`
 	Quantity  int       `json:"quantity" validate:"required,min=1"`
-	Notes     *string   `json:"notes,omitempty"`
+}
+
+// CreateProductUnitRequest for adding a unit to a product
+type CreateProductUnitRequest struct {
+	Name    string   `json:"name" validate:"required,max=20"`
+	Factor  Quantity `json:"factor" validate:"gt=0"`
+	Barcode *string  `json:"barcode,omitempty"`
+	Price   Money    `json:"price" validate:"min=0"`
+}
+
+// UpdateProductUnitRequest for updating a product unit
+type UpdateProductUnitRequest struct {
+	Name     *string   `json:"name,omitempty" validate:"omitempty,max=20"`
+	Factor   *Quantity `json:"factor,omitempty" validate:"omitempty,gt=0"`
+	Barcode  *string   `json:"barcode,omitempty"`
+	Price    *Money    `json:"price,omitempty" validate:"omitempty,min=0"`
+	IsActive *bool     `json:"is_active,omitempty"`
+}
+
+// CreateScaleBarcodePatternRequest for adding a scale barcode pattern.
+// ValueDecimals defaults to 3 for weights (grams in a kg product) and 0 for
+// prices.
+type CreateScaleBarcodePatternRequest struct {
+	Name          string `json:"name" validate:"required,min=2"`
+	Prefix        string `json:"prefix" validate:"required,numeric,max=2"`
+	PLULength     int    `json:"plu_length" validate:"required,min=1,max=6"`
+	ValueType     string `json:"value_type" validate:"required,oneof=weight price"`
+	ValueLength   int    `json:"value_length" validate:"required,min=1,max=6"`
+	ValueDecimals *int   `json:"value_decimals,omitempty" validate:"omitempty,min=0,max=3"`
+}
+
+// UpdateScaleBarcodePatternRequest for updating a scale barcode pattern
+type UpdateScaleBarcodePatternRequest struct {
+	Name          *string `json:"name,omitempty" validate:"omitempty,min=2"`
+	Prefix        *string `json:"prefix,omitempty" validate:"omitempty,numeric,max=2"`
+	PLULength     *int    `json:"plu_length,omitempty" validate:"omitempty,min=1,max=6"`
+	ValueType     *string `json:"value_type,omitempty" validate:"omitempty,oneof=weight price"`
+	ValueLength   *int    `json:"value_length,omitempty" validate:"omitempty,min=1,max=6"`
+	ValueDecimals *int    `json:"value_decimals,omitempty" validate:"omitempty,min=0,max=3"`
+	IsActive      *bool   `json:"is_active,omitempty"`
+}
+
+// PrintLabelsRequest for a sheet of barcode labels. Layout is a4 (sticker
+// paper, the default) or thermal (one label per page).
+type PrintLabelsRequest struct {
+	Items  []PrintLabelItemRequest `json:"items" validate:"required,min=1,dive"`
+	Layout string                  `json:"layout,omitempty" validate:"omitempty,oneof=a4 thermal"`
+}
+
+// PrintLabelItemRequest for the labels of one product, or of one of its
+// units when UnitID is set
+type PrintLabelItemRequest struct {
+	ProductID uuid.UUID  `json:"product_id" validate:"required"`
+	UnitID    *uuid.UUID `json:"unit_id,omitempty"`
+	Copies    int        `json:"copies,omitempty" validate:"omitempty,min=1,max=500"`
+}
+
+// StockAdjustRequest for stock adjustments. Quantity is in the unit given by
+// UnitID, or the product's base unit without one, and so is UnitCost, what
+// stock coming in was bought for. Stock coming in can be given a
+// BatchNumber and ExpiryDate; stock going out can name the BatchID it is
+// taken from, as when pulling expired goods, and otherwise comes from the
+// batch that expires first.
+type StockAdjustRequest struct {
+	ProductID   uuid.UUID  `json:"product_id" validate:"required"`
+	Type        string     `json:"type" validate:"oneof=in out adjustment"`
+	Quantity    Quantity   `json:"quantity" validate:"gt=0"`
+	UnitID      *uuid.UUID `json:"unit_id,omitempty"`
+	UnitCost    *Money     `json:"unit_cost,omitempty" validate:"omitempty,min=0"`
+	BatchNumber *string    `json:"batch_number,omitempty" validate:"omitempty,max=100"`
+	ExpiryDate  *string    `json:"expiry_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	BatchID     *uuid.UUID `json:"batch_id,omitempty"`
+	Notes       *string    `json:"notes,omitempty"`
+}
+
+// OpenStockOpnameRequest for starting a stocktake, of one category or of
+// every stocked product
+type OpenStockOpnameRequest struct {
+	CategoryID *uuid.UUID `json:"category_id,omitempty"`
+	Notes      *string    `json:"notes,omitempty"`
+}
+
+// StockOpnameCountRequest for recording counted quantities
+type StockOpnameCountRequest struct {
+	Items []StockOpnameCountItem `json:"items" validate:"required,min=1,dive"`
+}
+
+// StockOpnameCountItem is a count of one product, found by ProductID or by a
+// scanned Barcode of the product or one of its units. Quantity is in that
+// unit, or in UnitID's. With Add set it is added to the count so far, as
+// when scanning items one by one; otherwise it replaces it.
+type StockOpnameCountItem struct {
+	ProductID *uuid.UUID `json:"product_id,omitempty"`
+	Barcode   *string    `json:"barcode,omitempty"`
+	UnitID    *uuid.UUID `json:"unit_id,omitempty"`
+	Quantity  Quantity   `json:"quantity" validate:"min=0"`
+	Add       bool       `json:"add,omitempty"`
+}
+
+// CreateSupplierRequest for creating a supplier
+type CreateSupplierRequest struct {
+	Name        string  `json:"name" validate:"required,min=2"`
+	ContactName *string `json:"contact_name,omitempty"`
+	Phone       *string `json:"phone,omitempty"`
+	Email       *string `json:"email,omitempty"`
+	Address     *string `json:"address,omitempty"`
+	Notes       *string `json:"notes,omitempty"`
+}
+
+// UpdateSupplierRequest for updating a supplier
+type UpdateSupplierRequest struct {
+	Name        *string `json:"name,omitempty"`
+	ContactName *string `json:"contact_name,omitempty"`
+	Phone       *string `json:"phone,omitempty"`
+	Email       *string `json:"email,omitempty"`
+	Address     *string `json:"address,omitempty"`
+	Notes       *string `json:"notes,omitempty"`
+	IsActive    *bool   `json:"is_active,omitempty"`
+}
+
+// CreatePurchaseOrderRequest for drafting a purchase order
+type CreatePurchaseOrderRequest struct {
+	SupplierID uuid.UUID                  `json:"supplier_id" validate:"required"`
+	ExpectedAt *time.Time                 `json:"expected_at,omitempty"`
+	Notes      *string                    `json:"notes,omitempty"`
+	Items      []PurchaseOrderItemRequest `json:"items" validate:"required,min=1,dive"`
+}
+
+// UpdatePurchaseOrderRequest for changing a draft purchase order. Items,
+// when given, replace the order's lines.
+type UpdatePurchaseOrderRequest struct {
+	SupplierID *uuid.UUID                 `json:"supplier_id,omitempty"`
+	ExpectedAt *time.Time                 `json:"expected_at,omitempty"`
+	Notes      *string                    `json:"notes,omitempty"`
+	Items      []PurchaseOrderItemRequest `json:"items,omitempty" validate:"omitempty,min=1,dive"`
+}
+
+// PurchaseOrderItemRequest orders Quantity of a product at the expected
+// UnitCost, both in the unit given by UnitID or the base unit without one
+type PurchaseOrderItemRequest struct {
+	ProductID uuid.UUID  `json:"product_id" validate:"required"`
+	UnitID    *uuid.UUID `json:"unit_id,omitempty"`
+	Quantity  Quantity   `json:"quantity" validate:"gt=0"`
+	UnitCost  Money      `json:"unit_cost" validate:"min=0"`
+}
+
+// ReceivePurchaseOrderRequest for booking in a delivery against a purchase
+// order
+type ReceivePurchaseOrderRequest struct {
+	Items []ReceivePurchaseOrderItem `json:"items" validate:"required,min=1,dive"`
+	Notes *string                    `json:"notes,omitempty"`
+}
+
+// ReceivePurchaseOrderItem is the delivered Quantity of one order line, in
+// the line's unit. UnitCost is what the supplier actually charged, when it
+// differs from the order. BatchNumber and ExpiryDate are those printed on
+// the delivered goods.
+type ReceivePurchaseOrderItem struct {
+	ItemID      uuid.UUID `json:"item_id" validate:"required"`
+	Quantity    Quantity  `json:"quantity" validate:"gt=0"`
+	UnitCost    *Money    `json:"unit_cost,omitempty" validate:"omitempty,min=0"`
+	BatchNumber *string   `json:"batch_number,omitempty" validate:"omitempty,max=100"`
+	ExpiryDate  *string   `json:"expiry_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
 }
 
 // CreateTransactionRequest for creating a transaction
 type CreateTransactionRequest struct {
-	CustomerID      *uuid.UUID                     `json:"customer_id,omitempty"`
-	Items           []CreateTransactionItemRequest `json:"items" validate:"required,min=1"`
-	DiscountAmount  float64                        `json:"discount_amount,omitempty"`
-	DiscountPercent float64                        `json:"discount_percent,omitempty"`
-	PaymentAmount   float64                        `json:"payment_amount" validate:"required,min=0"`
-	PaymentType     string                         `json:"payment_type" validate:"required,oneof=cash qris transfer debit credit"`
-	PaymentRef      *string                        `json:"payment_reference,omitempty"`
-	Notes           *string                        `json:"notes,omitempty"`
-	SendReceipt     bool                           `json:"send_receipt,omitempty"`
+	CustomerID      *uuid.UUID                        `json:"customer_id,omitempty"`
+	Items           []CreateTransactionItemRequest    `json:"items" validate:"required,min=1,dive"`
+	DiscountAmount  Money                             `json:"discount_amount,omitempty"`
+	DiscountPercent float64                           `json:"discount_percent,omitempty"`
+	PaymentAmount   Money                             `json:"payment_amount" validate:"min=0"`
+	PaymentType     string                            `json:"payment_type" validate:"omitempty,oneof=cash qris transfer debit credit"`
+	PaymentRef      *string                           `json:"payment_reference,omitempty"`
+	Payments        []CreateTransactionPaymentRequest `json:"payments,omitempty" validate:"dive"`
+	PromoCode       *string                           `json:"promo_code,omitempty"`
+	Notes           *string                           `json:"notes,omitempty"`
+	SendReceipt     bool                              `json:"send_receipt,omitempty"`
+	// ClientTransactionID is used as the idempotency key when the
+	// Idempotency-Key header is not sent
+	ClientTransactionID *uuid.UUID `json:"client_transaction_id,omitempty"`
 }
 
 // CreateTransactionItemRequest for transaction items
 type CreateTransactionItemRequest struct {
 	ProductID       uuid.UUID `json:"product_id" validate:"required"`
-	Quantity        int       `json:"quantity" validate:"required,min=1"`
-	DiscountAmount  float64   `json:"discount_amount,omitempty"`
+	Quantity        Quantity  `json:"quantity" validate:"gt=0"`
+	DiscountAmount  Money     `json:"discount_amount,omitempty"`
 	DiscountPercent float64   `json:"discount_percent,omitempty"`
+	// ModifierIDs are the modifiers chosen for each unit of the item
+	ModifierIDs []uuid.UUID `json:"modifier_ids,omitempty"`
+	// UnitID sells the item in one of the product's other units
+	UnitID *uuid.UUID `json:"unit_id,omitempty"`
+}
+
+// HoldTransactionRequest for parking a cart as a pending transaction
+type HoldTransactionRequest struct {
+	CustomerID      *uuid.UUID                     `json:"customer_id,omitempty"`
+	Items           []CreateTransactionItemRequest `json:"items" validate:"required,min=1,dive"`
+	DiscountAmount  Money                          `json:"discount_amount,omitempty"`
+	DiscountPercent float64                        `json:"discount_percent,omitempty"`
+	PromoCode       *string                        `json:"promo_code,omitempty"`
+	Notes           *string                        `json:"notes,omitempty"`
+}
+
+// FinalizeTransactionRequest for paying and completing a held transaction
+type FinalizeTransactionRequest struct {
+	PaymentAmount Money                             `json:"payment_amount" validate:"min=0"`
+	PaymentType   string                            `json:"payment_type" validate:"omitempty,oneof=cash qris transfer debit credit"`
+	PaymentRef    *string                           `json:"payment_reference,omitempty"`
+	Payments      []CreateTransactionPaymentRequest `json:"payments,omitempty" validate:"dive"`
+}
+
+// CreateTransactionPaymentRequest for one payment line of a split payment
+type CreateTransactionPaymentRequest struct {
+	PaymentType string  `json:"payment_type" validate:"required,oneof=cash qris transfer debit credit"`
+	Amount      Money   `json:"amount" validate:"required,gt=0"`
+	Reference   *string `json:"reference,omitempty"`
+}
+
+// RefundTransactionRequest for refunding a transaction.
+// Leaving Items empty refunds everything that has not been refunded yet.
+type RefundTransactionRequest struct {
+	Items  []RefundItemRequest `json:"items,omitempty" validate:"dive"`
+	Reason *string             `json:"reason,omitempty"`
+}
+
+// RefundItemRequest for refunding part of a transaction item
+type RefundItemRequest struct {
+	TransactionItemID uuid.UUID `json:"transaction_item_id" validate:"required"`
+	Quantity          Quantity  `json:"quantity" validate:"gt=0"`
+}
+
+// VoidTransactionRequest for requesting a transaction void
+type VoidTransactionRequest struct {
+	ReasonCode string  `json:"reason_code" validate:"required,oneof=wrong_item wrong_quantity wrong_payment customer_cancelled duplicate other"`
+	Notes      *string `json:"notes,omitempty"`
+}
+
+// OpenShiftRequest for opening a shift
+type OpenShiftRequest struct {
+	OpeningCash Money   `json:"opening_cash" validate:"min=0"`
+	Notes       *string `json:"notes,omitempty"`
+}
+
+// ShiftCashRequest for petty cash in or out of the drawer
+type ShiftCashRequest struct {
+	Type   string  `json:"type" validate:"required,oneof=in out"`
+	Amount Money   `json:"amount" validate:"required,gt=0"`
+	Reason *string `json:"reason,omitempty"`
+}
+
+// CloseShiftRequest for closing a shift with the counted drawer cash
+type CloseShiftRequest struct {
+	CountedCash Money   `json:"counted_cash" validate:"min=0"`
+	Notes       *string `json:"notes,omitempty"`
+}
+
+// CreatePromoRequest for creating a promo
+type CreatePromoRequest struct {
+	Name        string     `json:"name" validate:"required,min=2"`
+	Code        *string    `json:"code,omitempty"`
+	Type        string     `json:"type" validate:"required,oneof=percentage fixed buy_x_get_y"`
+	Value       float64    `json:"value" validate:"min=0"`
+	ProductID   *uuid.UUID `json:"product_id,omitempty"`
+	BuyQuantity int        `json:"buy_quantity,omitempty" validate:"min=0"`
+	GetQuantity int        `json:"get_quantity,omitempty" validate:"min=0"`
+	MinPurchase Money      `json:"min_purchase,omitempty" validate:"min=0"`
+	MaxDiscount *Money     `json:"max_discount,omitempty"`
+	StartDate   *time.Time `json:"start_date,omitempty"`
+	EndDate     *time.Time `json:"end_date,omitempty"`
+	UsageLimit  *int       `json:"usage_limit,omitempty"`
+}
+
+// UpdatePromoRequest for updating a promo
+type UpdatePromoRequest struct {
+	Name        *string    `json:"name,omitempty"`
+	Code        *string    `json:"code,omitempty"`
+	Value       *float64   `json:"value,omitempty"`
+	ProductID   *uuid.UUID `json:"product_id,omitempty"`
+	BuyQuantity *int       `json:"buy_quantity,omitempty"`
+	GetQuantity *int       `json:"get_quantity,omitempty"`
+	MinPurchase *Money     `json:"min_purchase,omitempty"`
+	MaxDiscount *Money     `json:"max_discount,omitempty"`
+	StartDate   *time.Time `json:"start_date,omitempty"`
+	EndDate     *time.Time `json:"end_date,omitempty"`
+	UsageLimit  *int       `json:"usage_limit,omitempty"`
+	IsActive    *bool      `json:"is_active,omitempty"`
+}
+
+// CreateTaxRuleRequest for creating a tax or service charge rule
+type CreateTaxRuleRequest struct {
+	Name              string      `json:"name" validate:"required,min=2"`
+	Type              string      `json:"type" validate:"omitempty,oneof=tax service_charge"`
+	Rate              float64     `json:"rate" validate:"min=0,max=100"`
+	IsInclusive       bool        `json:"is_inclusive,omitempty"`
+	SortOrder         int         `json:"sort_order,omitempty"`
+	ExemptProductIDs  []uuid.UUID `json:"exempt_product_ids,omitempty"`
+	ExemptCategoryIDs []uuid.UUID `json:"exempt_category_ids,omitempty"`
+}
+
+// UpdateTaxRuleRequest for updating a tax rule. Exemption lists replace the
+// current ones when sent.
+type UpdateTaxRuleRequest struct {
+	Name              *string      `json:"name,omitempty"`
+	Rate              *float64     `json:"rate,omitempty" validate:"omitempty,min=0,max=100"`
+	IsInclusive       *bool        `json:"is_inclusive,omitempty"`
+	SortOrder         *int         `json:"sort_order,omitempty"`
+	IsActive          *bool        `json:"is_active,omitempty"`
+	ExemptProductIDs  *[]uuid.UUID `json:"exempt_product_ids,omitempty"`
+	ExemptCategoryIDs *[]uuid.UUID `json:"exempt_category_ids,omitempty"`
+}
+
+// CreateModifierGroupRequest for creating a modifier group with its modifiers
+type CreateModifierGroupRequest struct {
+	Name        string            `json:"name" validate:"required,min=2"`
+	MinSelect   int               `json:"min_select,omitempty" validate:"min=0"`
+	MaxSelect   int               `json:"max_select,omitempty" validate:"min=0"`
+	SortOrder   int               `json:"sort_order,omitempty"`
+	Modifiers   []ModifierRequest `json:"modifiers" validate:"required,min=1,dive"`
+	ProductIDs  []uuid.UUID       `json:"product_ids,omitempty"`
+	CategoryIDs []uuid.UUID       `json:"category_ids,omitempty"`
+}
+
+// UpdateModifierGroupRequest for updating a modifier group. Lists replace the
+// current ones when sent; modifiers left out of the list are deactivated.
+type UpdateModifierGroupRequest struct {
+	Name        *string            `json:"name,omitempty"`
+	MinSelect   *int               `json:"min_select,omitempty" validate:"omitempty,min=0"`
+	MaxSelect   *int               `json:"max_select,omitempty" validate:"omitempty,min=0"`
+	SortOrder   *int               `json:"sort_order,omitempty"`
+	IsActive    *bool              `json:"is_active,omitempty"`
+	Modifiers   *[]ModifierRequest `json:"modifiers,omitempty" validate:"omitempty,dive"`
+	ProductIDs  *[]uuid.UUID       `json:"product_ids,omitempty"`
+	CategoryIDs *[]uuid.UUID       `json:"category_ids,omitempty"`
+}
+
+// ModifierRequest is a modifier of a group request. Sending the ID of an
+// existing modifier updates it instead of adding a new one.
+type ModifierRequest struct {
+	ID        *uuid.UUID `json:"id,omitempty"`
+	Name      string     `json:"name" validate:"required"`
+	Price     Money      `json:"price,omitempty" validate:"min=0"`
+	SortOrder int        `json:"sort_order,omitempty"`
 }
 
 // CreateCustomerRequest for creating a customer
@@ -307,6 +1117,37 @@ type UpdateCustomerRequest struct {
 	IsActive *bool   `json:"is_active,omitempty"`
 }
 
+// SyncPushRequest for uploading sales made while a terminal was offline
+type SyncPushRequest struct {
+	Sales []SyncSaleRequest `json:"sales" validate:"required,min=1,max=200,dive"`
+}
+
+// SyncSaleRequest is an offline sale. ClientTransactionID is required so a
+// re-sent batch doesn't create the sale twice.
+type SyncSaleRequest struct {
+	CreateTransactionRequest
+	SoldAt *time.Time `json:"sold_at,omitempty"`
+}
+
+// SyncSaleResult reports what happened to one offline sale
+type SyncSaleResult struct {
+	ClientTransactionID *uuid.UUID     `json:"client_transaction_id"`
+	Status              string         `json:"status"` // created, duplicate, rejected
+	TransactionID       *uuid.UUID     `json:"transaction_id,omitempty"`
+	InvoiceNumber       *string        `json:"invoice_number,omitempty"`
+	Error               string         `json:"error,omitempty"`
+	Conflicts           []SyncConflict `json:"conflicts,omitempty"`
+}
+
+// SyncConflict describes a cart item that could not be sold
+type SyncConflict struct {
+	ProductID   uuid.UUID `json:"product_id"`
+	ProductName string    `json:"product_name,omitempty"`
+	Reason      string    `json:"reason"` // not_found, inactive, insufficient_stock
+	Requested   Quantity  `json:"requested"`
+	Available   Quantity  `json:"available"`
+}
+
 // SendWhatsAppRequest for sending WhatsApp messages
 type SendWhatsAppRequest struct {
 	Phone       string `json:"phone" validate:"required"`
@@ -327,30 +1168,96 @@ type BroadcastRequest struct {
 
 // DailySalesReport for daily sales data
 type DailySalesReport struct {
-	Date               string  `json:"date"`
-	TotalTransactions  int     `json:"total_transactions"`
-	TotalSales         float64 `json:"total_sales"`
-	TotalDiscounts     float64 `json:"total_discounts"`
-	GrossProfit        float64 `json:"gross_profit"`
-	AverageTransaction float64 `json:"average_transaction"`
+	Date               string `json:"date"`
+	TotalTransactions  int    `json:"total_transactions"`
+	TotalSales         Money  `json:"total_sales"`
+	TotalDiscounts     Money  `json:"total_discounts"`
+	TotalRounding      Money  `json:"total_rounding"`
+	TotalRefunds       Money  `json:"total_refunds"`
+	GrossProfit        Money  `json:"gross_profit"`
+	AverageTransaction Money  `json:"average_transaction"`
 }
 
 // ProductReport for product performance
 type ProductReport struct {
 	ProductID        uuid.UUID `json:"product_id"`
 	ProductName      string    `json:"product_name"`
-	TotalSold        int       `json:"total_sold"`
-	TotalRevenue     float64   `json:"total_revenue"`
-	TotalProfit      float64   `json:"total_profit"`
+	TotalSold        Quantity  `json:"total_sold"` // in the base unit
+	TotalRevenue     Money     `json:"total_revenue"`
+	TotalProfit      Money     `json:"total_profit"`
 	TransactionCount int       `json:"transaction_count"`
+	// SoldInBundles is how many units went out as components of bundles.
+	// Bundle revenue stays with the bundle.
+	SoldInBundles Quantity `json:"sold_in_bundles"`
+}
+
+// ShiftReport is the X-report of an open shift or the Z-report of a closed one
+type ShiftReport struct {
+	Shift            Shift               `json:"shift"`
+	TransactionCount int                 `json:"transaction_count"`
+	GrossSales       Money               `json:"gross_sales"`
+	TotalDiscounts   Money               `json:"total_discounts"`
+	TotalRefunds     Money               `json:"total_refunds"`
+	RefundCount      int                 `json:"refund_count"`
+	VoidCount        int                 `json:"void_count"`
+	NetSales         Money               `json:"net_sales"`
+	Payments         []ShiftPaymentTotal `json:"payments"`
+	CashIn           Money               `json:"cash_in"`
+	CashOut          Money               `json:"cash_out"`
+	CashMovements    []ShiftCashMovement `json:"cash_movements"`
+	OpeningCash      Money               `json:"opening_cash"`
+	ExpectedCash     Money               `json:"expected_cash"`
+	CountedCash      *Money              `json:"counted_cash,omitempty"`
+	CashDifference   *Money              `json:"cash_difference,omitempty"`
+}
+
+// ShiftPaymentTotal is the amount taken per payment type during a shift.
+// Cash is net of change given.
+type ShiftPaymentTotal struct {
+	PaymentType string `json:"payment_type"`
+	Amount      Money  `json:"amount"`
+	Count       int    `json:"count"`
+}
+
+// PromoReport for discounts given per promo
+type PromoReport struct {
+	PromoID          uuid.UUID `json:"promo_id"`
+	Name             string    `json:"name"`
+	Code             *string   `json:"code,omitempty"`
+	Type             string    `json:"type"`
+	TransactionCount int       `json:"transaction_count"`
+	TotalDiscount    Money     `json:"total_discount"`
+	TotalSales       Money     `json:"total_sales"`
+}
+
+// TaxReport for tax and service charge collected per rate and period.
+// Amounts are net of refunds.
+type TaxReport struct {
+	Period           string  `json:"period"`
+	Name             string  `json:"name"`
+	Type             string  `json:"type"`
+	Rate             float64 `json:"rate"`
+	IsInclusive      bool    `json:"is_inclusive"`
+	TransactionCount int     `json:"transaction_count"`
+	TaxableAmount    Money   `json:"taxable_amount"`
+	TaxAmount        Money   `json:"tax_amount"`
+}
+
+// ModifierReport for revenue from modifiers, net of refunded quantities
+type ModifierReport struct {
+	GroupName        string   `json:"group_name"`
+	Name             string   `json:"name"`
+	TotalSold        Quantity `json:"total_sold"`
+	TotalRevenue     Money    `json:"total_revenue"`
+	TransactionCount int      `json:"transaction_count"`
 }
 
 // ProfitLossReport for profit and loss
 type ProfitLossReport struct {
 	Period       string  `json:"period"`
-	TotalRevenue float64 `json:"total_revenue"`
-	TotalCost    float64 `json:"total_cost"`
-	GrossProfit  float64 `json:"gross_profit"`
+	TotalRevenue Money   `json:"total_revenue"`
+	TotalCost    Money   `json:"total_cost"`
+	GrossProfit  Money   `json:"gross_profit"`
 	Margin       float64 `json:"margin"`
 }
 
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in hundredths of the currency unit (sen for rupiah),
+// matching the DECIMAL(15,2) columns it is stored in. Adding, subtracting
+// and multiplying by a quantity are exact. Rounding only happens when a
+// result has more than two decimals (percentages, ratios, parsed input), and
+// always goes to the nearest sen with halves away from zero:
+//
+//	0.005 -> 0.01   0.0049 -> 0.00   -0.005 -> -0.01
+//
+// Money is written to the database and JSON as a plain decimal number, so
+// API clients keep seeing the same numbers as before.
+type Money int64
+
+const moneyScale = 100
+
+// NewMoney returns whole currency units as Money
+func NewMoney(units int64) Money {
+	return Money(units * moneyScale)
+}
+
+// ParseMoney parses a decimal string such as "1500", "-12.5" or "0.125".
+// Digits past the second decimal place are rounded half away from zero.
+func ParseMoney(s string) (Money, error) {
+	amount, err := parseScaled(s, 2)
+	if err != nil {
+		return 0, fmt.Errorf("%s money amount %q", err, strings.TrimSpace(s))
+	}
+	return Money(amount), nil
+}
+
+// parseScaled parses a decimal string into an integer count of 10^-places,
+// rounding further digits half away from zero. The error only says what is
+// wrong ("invalid" or "out of range") for the caller to wrap.
+func parseScaled(s string, places int) (int64, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid")
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	digits := s
+	negative := false
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+	whole, frac := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		whole, frac = digits[:i], digits[i+1:]
+	}
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("invalid")
+	}
+	// Anything longer doesn't fit in an int64 at this scale
+	if len(strings.TrimLeft(whole, "0")) > 18-places {
+		return 0, fmt.Errorf("out of range")
+	}
+
+	var units int64
+	if whole != "" {
+		units, _ = strconv.ParseInt(whole, 10, 64)
+	}
+	padded := frac + strings.Repeat("0", places)
+	part, _ := strconv.ParseInt(padded[:places], 10, 64)
+
+	scale := int64(1)
+	for i := 0; i < places; i++ {
+		scale *= 10
+	}
+	amount := units*scale + part
+	if len(frac) > places && frac[places] >= '5' {
+		amount++
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// MoneyFromFloat converts a float amount, rounding half away from zero at
+// the sen. The float's shortest decimal form is used, so 1.005 becomes 1.01
+// rather than whatever 1.005 * 100 happens to be in binary.
+func MoneyFromFloat(f float64) Money {
+	m, _ := ParseMoney(strconv.FormatFloat(f, 'f', -1, 64))
+	return m
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Mul multiplies the amount by a quantity
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// Percent returns pct percent of the amount. pct is taken to two decimal
+// places, like the DECIMAL(5,2) rate columns.
+func (m Money) Percent(pct float64) Money {
+	return m.MulDiv(int64(MoneyFromFloat(pct)), 100*moneyScale)
+}
+
+// MulDiv returns m * num / den, rounded half away from zero. The
+// intermediate product can't overflow.
+func (m Money) MulDiv(num, den int64) Money {
+	return Money(mulDiv(int64(m), num, den))
+}
+
+// mulDiv returns a * num / den, rounded half away from zero, through big
+// integers so the intermediate product can't overflow
+func mulDiv(a, num, den int64) int64 {
+	if den == 0 {
+		return 0
+	}
+	product := new(big.Int).Mul(big.NewInt(a), big.NewInt(num))
+	divisor := big.NewInt(den)
+	quo, rem := new(big.Int).QuoRem(product, divisor, new(big.Int))
+
+	// Round away from zero when the remainder is at least half the divisor
+	rem.Abs(rem).Lsh(rem, 1)
+	if rem.CmpAbs(divisor) >= 0 {
+		if product.Sign()*divisor.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return quo.Int64()
+}
+
+// RoundTo rounds the amount to a multiple of step. mode "up" rounds towards
+// positive infinity, "down" towards negative infinity, and anything else to
+// the nearest multiple with halves away from zero.
+func (m Money) RoundTo(step Money, mode string) Money {
+	if step <= 0 {
+		return m
+	}
+	floor := m - m%step
+	if m%step < 0 {
+		floor -= step
+	}
+	rem := m - floor
+	if rem == 0 {
+		return m
+	}
+
+	switch mode {
+	case "up":
+		return floor + step
+	case "down":
+		return floor
+	}
+	if 2*rem > step || (2*rem == step && m > 0) {
+		return floor + step
+	}
+	return floor
+}
+
+// Float64 returns the amount as a float, for ratios and display only
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// String formats the amount with exactly two decimals, e.g. "-1500.50"
+func (m Money) String() string {
+	sign := ""
+	abs := int64(m)
+	if abs < 0 {
+		sign = "-"
+		abs = -abs
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs/moneyScale, abs%moneyScale)
+}
+
+// Scan implements sql.Scanner for DECIMAL columns
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case []byte:
+		parsed, err := ParseMoney(string(v))
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case string:
+		parsed, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case int64:
+		*m = NewMoney(v)
+	case float64:
+		*m = MoneyFromFloat(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer, passing the amount as exact decimal text
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// MarshalJSON writes the amount as a JSON number without trailing zeros
+func (m Money) MarshalJSON() ([]byte, error) {
+	s := m.String()
+	s = strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+	if s == "" || s == "-" {
+		s = "0"
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	parsed, err := ParseMoney(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,263 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"1500", 150000},
+		{"-12.5", -1250},
+		{"0.01", 1},
+		{" 7.25 ", 725},
+		{"+3", 300},
+		{".5", 50},
+		{"5.", 500},
+		{"0.005", 1},
+		{"-0.005", -1},
+		{"0.0049", 0},
+		{"-0.0049", 0},
+		{"1.125", 113},
+		{"-1.125", -113},
+		{"1.999", 200},
+		{"1e3", 100000},
+		{"1.5E-2", 2},
+		{"9999999999999999.99", 999999999999999999},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyInvalid(t *testing.T) {
+	for _, in := range []string{"", "-", ".", "abc", "1.2.3", "1,5", "--1", "12a", "10000000000000000"} {
+		if got, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want Money
+	}{
+		{0, 0},
+		{1.005, 101},
+		{-1.005, -101},
+		{0.1 + 0.2, 30},
+		{1500.5, 150050},
+	}
+	for _, tt := range tests {
+		if got := MoneyFromFloat(tt.in); got != tt.want {
+			t.Errorf("MoneyFromFloat(%v) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMulDiv(t *testing.T) {
+	tests := []struct {
+		m        Money
+		num, den int64
+		want     Money
+	}{
+		{1000, 1, 3, 333},
+		{1000, 2, 3, 667},
+		{-1000, 2, 3, -667},
+		{1000, -2, 3, -667},
+		{1000, 2, -3, -667},
+		{-1000, -2, 3, 667},
+		{5, 1, 2, 3},
+		{-5, 1, 2, -3},
+		{3, 1, 2, 2},
+		{-3, 1, 2, -2},
+		{7, 1, 4, 2},
+		{700, 3, 3, 700},
+		{1000, 1, 0, 0},
+		// The intermediate product overflows int64 but the result doesn't
+		{Money(1) << 62, 4, 8, Money(1) << 61},
+	}
+	for _, tt := range tests {
+		if got := tt.m.MulDiv(tt.num, tt.den); got != tt.want {
+			t.Errorf("Money(%d).MulDiv(%d, %d) = %d, want %d", tt.m, tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyPercent(t *testing.T) {
+	tests := []struct {
+		m    Money
+		pct  float64
+		want Money
+	}{
+		{NewMoney(10000), 11, NewMoney(1100)},
+		{NewMoney(10000), 0, 0},
+		{NewMoney(10000), 100, NewMoney(10000)},
+		{NewMoney(1234), 10, 12340},
+		{NewMoney(1), 12.5, 13},
+		{NewMoney(-1), 12.5, -13},
+		{999, 2.5, 25},
+		{NewMoney(10000), 7.125, 71300},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Percent(tt.pct); got != tt.want {
+			t.Errorf("Money(%d).Percent(%v) = %d, want %d", tt.m, tt.pct, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyRoundTo(t *testing.T) {
+	step := NewMoney(500)
+	tests := []struct {
+		m    Money
+		mode string
+		want Money
+	}{
+		{NewMoney(12300), "nearest", NewMoney(12500)},
+		{NewMoney(12200), "nearest", NewMoney(12000)},
+		{NewMoney(12250), "nearest", NewMoney(12500)},
+		{NewMoney(12500), "nearest", NewMoney(12500)},
+		{NewMoney(-12300), "nearest", NewMoney(-12500)},
+		{NewMoney(-12200), "nearest", NewMoney(-12000)},
+		{NewMoney(-12250), "nearest", NewMoney(-12500)},
+		{NewMoney(12001), "up", NewMoney(12500)},
+		{NewMoney(12000), "up", NewMoney(12000)},
+		{NewMoney(-12001), "up", NewMoney(-12000)},
+		{NewMoney(12499), "down", NewMoney(12000)},
+		{NewMoney(12500), "down", NewMoney(12500)},
+		{NewMoney(-12001), "down", NewMoney(-12500)},
+		{0, "nearest", 0},
+		{0, "up", 0},
+	}
+	for _, tt := range tests {
+		if got := tt.m.RoundTo(step, tt.mode); got != tt.want {
+			t.Errorf("Money(%s).RoundTo(%s, %q) = %s, want %s", tt.m, step, tt.mode, got, tt.want)
+		}
+	}
+
+	if got := NewMoney(12345).RoundTo(0, "up"); got != NewMoney(12345) {
+		t.Errorf("RoundTo with no step = %s, want the amount unchanged", got)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{0, "0.00"},
+		{1, "0.01"},
+		{-1, "-0.01"},
+		{150050, "1500.50"},
+		{-150050, "-1500.50"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.m), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{0, "0"},
+		{150000, "1500"},
+		{150050, "1500.5"},
+		{150055, "1500.55"},
+		{-5, "-0.05"},
+		{-150000, "-1500"},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.m)
+		if err != nil {
+			t.Fatalf("Marshal(%s) error: %v", tt.m, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("Marshal(%s) = %s, want %s", tt.m, data, tt.want)
+		}
+
+		var back Money
+		if err := json.Unmarshal(data, &back); err != nil {
+			t.Fatalf("Unmarshal(%s) error: %v", data, err)
+		}
+		if back != tt.m {
+			t.Errorf("Unmarshal(%s) = %s, want %s", data, back, tt.m)
+		}
+	}
+
+	var v struct {
+		Price  Money  `json:"price"`
+		Total  Money  `json:"total"`
+		Tip    *Money `json:"tip"`
+		Change Money  `json:"change"`
+	}
+	v.Change = 99
+	if err := json.Unmarshal([]byte(`{"price": "12.5", "total": 0.005, "tip": null, "change": null}`), &v); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if v.Price != 1250 || v.Total != 1 || v.Tip != nil || v.Change != 99 {
+		t.Errorf("Unmarshal = %+v, want price 1250, total 1, no tip and change left at 99", v)
+	}
+	if err := json.Unmarshal([]byte(`{"price": "abc"}`), &v); err == nil {
+		t.Error("Unmarshal of a non-numeric string succeeded")
+	}
+}
+
+func TestMoneyDatabase(t *testing.T) {
+	value, err := Money(-150050).Value()
+	if err != nil || value != "-1500.50" {
+		t.Errorf("Value() = %v, %v, want \"-1500.50\"", value, err)
+	}
+
+	tests := []struct {
+		src  interface{}
+		want Money
+	}{
+		{nil, 0},
+		{[]byte("1500.50"), 150050},
+		{[]byte("-0.01"), -1},
+		{"12.345", 1235},
+		{int64(7), 700},
+		{float64(2.675), 268},
+	}
+	for _, tt := range tests {
+		m := Money(42)
+		if err := m.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%#v) error: %v", tt.src, err)
+			continue
+		}
+		if m != tt.want {
+			t.Errorf("Scan(%#v) = %d, want %d", tt.src, m, tt.want)
+		}
+	}
+
+	var m Money
+	if err := m.Scan(true); err == nil {
+		t.Error("Scan(bool) succeeded")
+	}
+	if err := m.Scan([]byte("x")); err == nil {
+		t.Error("Scan of invalid text succeeded")
+	}
+
+	for _, want := range []Money{0, 1, -1, 150050, -999999} {
+		value, _ := want.Value()
+		var got Money
+		if err := got.Scan(value); err != nil || got != want {
+			t.Errorf("Scan(Value()) of %d = %d, %v", want, got, err)
+		}
+	}
+}
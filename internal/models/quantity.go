@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// Quantity is an amount of stock in thousandths of the product's unit,
+// matching the DECIMAL(15,3) stock columns. It lets ingredients be kept in
+// kg or liters and used a fraction at a time, e.g. 0.015 kg of coffee per
+// cup, while whole-unit products simply never see a fraction.
+//
+// Like Money it is written to the database and JSON as a plain decimal
+// number, without trailing zeros.
+type Quantity int64
+
+const quantityScale = 1000
+
+// NewQuantity returns whole units as a Quantity
+func NewQuantity(units int64) Quantity {
+	return Quantity(units * quantityScale)
+}
+
+// ParseQuantity parses a decimal string such as "12", "0.25" or "-1.5".
+// Digits past the third decimal place are rounded half away from zero.
+func ParseQuantity(s string) (Quantity, error) {
+	q, err := parseScaled(s, 3)
+	if err != nil {
+		return 0, fmt.Errorf("%s quantity %q", err, strings.TrimSpace(s))
+	}
+	return Quantity(q), nil
+}
+
+// Mul multiplies the quantity by a whole count, e.g. a recipe amount by the
+// number of portions sold
+func (q Quantity) Mul(count int) Quantity {
+	return q * Quantity(count)
+}
+
+// MulQuantity multiplies two quantities, e.g. a recipe amount by a
+// quantity sold in a unit of 0.5 kg, rounded half away from zero at the
+// thousandth
+func (q Quantity) MulQuantity(other Quantity) Quantity {
+	return Quantity(mulDiv(int64(q), int64(other), quantityScale))
+}
+
+// MulDiv returns q * num / den, rounded half away from zero at the
+// thousandth, e.g. the share of a sale line's stock a partial refund returns
+func (q Quantity) MulDiv(num, den int64) Quantity {
+	return Quantity(mulDiv(int64(q), num, den))
+}
+
+// MulQuantity returns the price of q units at m per unit, rounded half away
+// from zero at the sen
+func (m Money) MulQuantity(q Quantity) Money {
+	return m.MulDiv(int64(q), quantityScale)
+}
+
+// QuantityFor returns how much amount buys at unitPrice, rounded half away
+// from zero at the thousandth, e.g. the weight behind a priced scale label
+func QuantityFor(amount, unitPrice Money) Quantity {
+	return Quantity(mulDiv(int64(amount), quantityScale, int64(unitPrice)))
+}
+
+// IsWhole reports whether the quantity has no fractional part
+func (q Quantity) IsWhole() bool {
+	return q%quantityScale == 0
+}
+
+// String formats the quantity without trailing zeros, e.g. "2", "0.25"
+func (q Quantity) String() string {
+	sign := ""
+	abs := int64(q)
+	if abs < 0 {
+		sign = "-"
+		abs = -abs
+	}
+	s := fmt.Sprintf("%s%d.%03d", sign, abs/quantityScale, abs%quantityScale)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// Scan implements sql.Scanner for DECIMAL and INTEGER columns
+func (q *Quantity) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*q = 0
+	case []byte:
+		parsed, err := ParseQuantity(string(v))
+		if err != nil {
+			return err
+		}
+		*q = parsed
+	case string:
+		parsed, err := ParseQuantity(v)
+		if err != nil {
+			return err
+		}
+		*q = parsed
+	case int64:
+		*q = NewQuantity(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Quantity", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer, passing the quantity as exact decimal text
+func (q Quantity) Value() (driver.Value, error) {
+	return q.String(), nil
+}
+
+// MarshalJSON writes the quantity as a JSON number
+func (q Quantity) MarshalJSON() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string
+func (q *Quantity) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	parsed, err := ParseQuantity(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
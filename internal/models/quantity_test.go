@@ -0,0 +1,186 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Quantity
+	}{
+		{"12", 12000},
+		{"0.25", 250},
+		{"-1.5", -1500},
+		{"0.015", 15},
+		{"0.0005", 1},
+		{"-0.0005", -1},
+		{"0.0004", 0},
+		{"2.9999", 3000},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuantity(tt.in)
+		if err != nil {
+			t.Errorf("ParseQuantity(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseQuantity(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "kg", "1/2", "1000000000000000"} {
+		if got, err := ParseQuantity(in); err == nil {
+			t.Errorf("ParseQuantity(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestQuantityArithmetic(t *testing.T) {
+	if got := Quantity(15).Mul(3); got != 45 {
+		t.Errorf("Mul = %d, want 45", got)
+	}
+
+	mulTests := []struct {
+		q, other, want Quantity
+	}{
+		{15, 500, 8}, // 0.015 * 0.5 = 0.0075
+		{15, -500, -8},
+		{NewQuantity(2), 250, 500},
+		{333, 333, 111}, // 0.333 * 0.333 = 0.110889
+	}
+	for _, tt := range mulTests {
+		if got := tt.q.MulQuantity(tt.other); got != tt.want {
+			t.Errorf("Quantity(%d).MulQuantity(%d) = %d, want %d", tt.q, tt.other, got, tt.want)
+		}
+	}
+
+	divTests := []struct {
+		q        Quantity
+		num, den int64
+		want     Quantity
+	}{
+		{NewQuantity(3), 1, 3, 1000},
+		{1000, 1, 3, 333},
+		{1000, 2, 3, 667},
+		{-1000, 2, 3, -667},
+		{45, 1, 2, 23}, // 0.045 / 2 = 0.0225
+		{1000, 1, 0, 0},
+	}
+	for _, tt := range divTests {
+		if got := tt.q.MulDiv(tt.num, tt.den); got != tt.want {
+			t.Errorf("Quantity(%d).MulDiv(%d, %d) = %d, want %d", tt.q, tt.num, tt.den, got, tt.want)
+		}
+	}
+
+	priceTests := []struct {
+		price Money
+		q     Quantity
+		want  Money
+	}{
+		{NewMoney(12000), NewQuantity(3), NewMoney(36000)},
+		{NewMoney(89000), 250, NewMoney(22250)},
+		{333, 500, 167}, // 3.33 * 0.5 = 1.665
+		{-333, 500, -167},
+	}
+	for _, tt := range priceTests {
+		if got := tt.price.MulQuantity(tt.q); got != tt.want {
+			t.Errorf("Money(%s).MulQuantity(%s) = %s, want %s", tt.price, tt.q, got, tt.want)
+		}
+	}
+
+	forTests := []struct {
+		amount, unitPrice Money
+		want              Quantity
+	}{
+		{NewMoney(22250), NewMoney(89000), 250},
+		{NewMoney(10000), NewMoney(30000), 333},
+		{NewMoney(20000), NewMoney(30000), 667},
+		{NewMoney(10000), 0, 0},
+	}
+	for _, tt := range forTests {
+		if got := QuantityFor(tt.amount, tt.unitPrice); got != tt.want {
+			t.Errorf("QuantityFor(%s, %s) = %d, want %d", tt.amount, tt.unitPrice, got, tt.want)
+		}
+	}
+
+	for q, want := range map[Quantity]bool{0: true, 3000: true, -2000: true, 1: false, 2500: false, -500: false} {
+		if got := q.IsWhole(); got != want {
+			t.Errorf("Quantity(%d).IsWhole() = %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestQuantityString(t *testing.T) {
+	tests := []struct {
+		q    Quantity
+		want string
+	}{
+		{0, "0"},
+		{2000, "2"},
+		{250, "0.25"},
+		{15, "0.015"},
+		{-1500, "-1.5"},
+		{10000, "10"},
+	}
+	for _, tt := range tests {
+		if got := tt.q.String(); got != tt.want {
+			t.Errorf("Quantity(%d).String() = %q, want %q", int64(tt.q), got, tt.want)
+		}
+
+		data, err := json.Marshal(tt.q)
+		if err != nil || string(data) != tt.want {
+			t.Errorf("Marshal(%d) = %s, %v, want %s", int64(tt.q), data, err, tt.want)
+		}
+		var back Quantity
+		if err := json.Unmarshal(data, &back); err != nil || back != tt.q {
+			t.Errorf("Unmarshal(%s) = %d, %v, want %d", data, back, err, tt.q)
+		}
+	}
+
+	var q Quantity
+	if err := json.Unmarshal([]byte(`"1.25"`), &q); err != nil || q != 1250 {
+		t.Errorf("Unmarshal of a numeric string = %d, %v, want 1250", q, err)
+	}
+}
+
+func TestQuantityDatabase(t *testing.T) {
+	value, err := Quantity(-1500).Value()
+	if err != nil || value != "-1.5" {
+		t.Errorf("Value() = %v, %v, want \"-1.5\"", value, err)
+	}
+
+	tests := []struct {
+		src  interface{}
+		want Quantity
+	}{
+		{nil, 0},
+		{[]byte("12.500"), 12500},
+		{"0.015", 15},
+		{int64(4), 4000},
+	}
+	for _, tt := range tests {
+		q := Quantity(42)
+		if err := q.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%#v) error: %v", tt.src, err)
+			continue
+		}
+		if q != tt.want {
+			t.Errorf("Scan(%#v) = %d, want %d", tt.src, q, tt.want)
+		}
+	}
+
+	var q Quantity
+	if err := q.Scan(1.5); err == nil {
+		t.Error("Scan(float64) succeeded")
+	}
+
+	for _, want := range []Quantity{0, 1, -1, 12500, -999999} {
+		value, _ := want.Value()
+		var got Quantity
+		if err := got.Scan(value); err != nil || got != want {
+			t.Errorf("Scan(Value()) of %d = %d, %v", want, got, err)
+		}
+	}
+}
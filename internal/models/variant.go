@@ -0,0 +1,89 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// VariantOption is one option axis of a product with variants, such as
+// Ukuran with S, M and L
+type VariantOption struct {
+	Name   string   `json:"name" validate:"required"`
+	Values []string `json:"values" validate:"required,min=1,dive,required"`
+}
+
+// VariantOptions are the option axes of a parent product, stored as JSONB.
+// An empty list is stored as NULL, which marks a product without variants.
+type VariantOptions []VariantOption
+
+// Scan implements sql.Scanner for JSONB columns
+func (o *VariantOptions) Scan(src interface{}) error {
+	return scanJSON(src, o)
+}
+
+// Value implements driver.Valuer
+func (o VariantOptions) Value() (driver.Value, error) {
+	if len(o) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(o)
+	return string(b), err
+}
+
+// Check reports whether values picks exactly one allowed value for every
+// option axis and nothing else
+func (o VariantOptions) Check(values OptionValues) error {
+	if len(values) != len(o) {
+		return fmt.Errorf("A value is required for each of the %d product options", len(o))
+	}
+	for _, opt := range o {
+		value, ok := values[opt.Name]
+		if !ok {
+			return fmt.Errorf("Missing value for option %s", opt.Name)
+		}
+		allowed := false
+		for _, v := range opt.Values {
+			if v == value {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%s is not a value of option %s", value, opt.Name)
+		}
+	}
+	return nil
+}
+
+// OptionValues are the option values of one variant keyed by option name,
+// such as {"Ukuran": "M", "Warna": "Merah"}, stored as JSONB
+type OptionValues map[string]string
+
+// Scan implements sql.Scanner for JSONB columns
+func (v *OptionValues) Scan(src interface{}) error {
+	return scanJSON(src, v)
+}
+
+// Value implements driver.Valuer
+func (v OptionValues) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// scanJSON decodes a JSONB column into dst, leaving it empty for NULL
+func scanJSON(src interface{}, dst interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dst)
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, dst)
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EAN13CheckDigit returns the check digit for the first 12 digits of an
+// EAN-13 barcode
+func EAN13CheckDigit(digits string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		n := int(digits[i] - '0')
+		if i%2 == 1 {
+			n *= 3
+		}
+		sum += n
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// IsEAN13 reports whether code is 13 digits with a valid check digit
+func IsEAN13(code string) bool {
+	if len(code) != 13 {
+		return false
+	}
+	for i := 0; i < 13; i++ {
+		if code[i] < '0' || code[i] > '9' {
+			return false
+		}
+	}
+	return code[12] == EAN13CheckDigit(code)
+}
+
+// EAN-13 digit patterns, one module per character. G codes are the R codes
+// reversed; R codes are the L codes inverted.
+var ean13L = [10]string{
+	"0001101", "0011001", "0010011", "0111101", "0100011",
+	"0110001", "0101111", "0111011", "0110111", "0001011",
+}
+
+// ean13Parity is the L/G choice for the left six digits, set by the first
+var ean13Parity = [10]string{
+	"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG",
+	"LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL",
+}
+
+// encodeEAN13 returns the 95 modules of an EAN-13 barcode, "1" for a bar
+func encodeEAN13(code string) string {
+	invert := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '0' {
+				return '1'
+			}
+			return '0'
+		}, s)
+	}
+	reverse := func(s string) string {
+		b := []byte(s)
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+		return string(b)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("101")
+	parity := ean13Parity[code[0]-'0']
+	for i := 1; i <= 6; i++ {
+		l := ean13L[code[i]-'0']
+		if parity[i-1] == 'G' {
+			l = reverse(invert(l))
+		}
+		sb.WriteString(l)
+	}
+	sb.WriteString("01010")
+	for i := 7; i <= 12; i++ {
+		sb.WriteString(invert(ean13L[code[i]-'0']))
+	}
+	sb.WriteString("101")
+	return sb.String()
+}
+
+// Code 128 symbols as bar and space widths, starting with a bar
+var code128Widths = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128StartB = 104
+	code128Stop   = 106
+)
+
+// encodeCode128 returns the modules of code in Code 128 set B, which covers
+// printable ASCII
+func encodeCode128(code string) (string, error) {
+	symbols := []int{code128StartB}
+	checksum := code128StartB
+	for i := 0; i < len(code); i++ {
+		if code[i] < 32 || code[i] > 126 {
+			return "", fmt.Errorf("barcode %q has characters Code 128 can't print", code)
+		}
+		value := int(code[i] - 32)
+		symbols = append(symbols, value)
+		checksum += (i + 1) * value
+	}
+	symbols = append(symbols, checksum%103, code128Stop)
+
+	var sb strings.Builder
+	for _, s := range symbols {
+		for i, w := range code128Widths[s] {
+			module := "1"
+			if i%2 == 1 {
+				module = "0"
+			}
+			sb.WriteString(strings.Repeat(module, int(w-'0')))
+		}
+	}
+	return sb.String(), nil
+}
+
+// EncodeBarcode returns the modules of code as EAN-13 when it is a valid
+// EAN-13 and as Code 128 otherwise
+func EncodeBarcode(code string) (string, error) {
+	if IsEAN13(code) {
+		return encodeEAN13(code), nil
+	}
+	if code == "" {
+		return "", fmt.Errorf("empty barcode")
+	}
+	return encodeCode128(code)
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+
+	"kasirku/internal/models"
+)
+
+// Label is one printed sticker: product name, price and barcode
+type Label struct {
+	Name    string
+	Price   models.Money
+	Barcode string
+}
+
+// LabelLayout places labels on a page, in PDF points (1/72 inch)
+type LabelLayout struct {
+	PageWidth   float64
+	PageHeight  float64
+	LabelWidth  float64
+	LabelHeight float64
+	MarginLeft  float64
+	MarginTop   float64
+	Columns     int
+	Rows        int
+}
+
+const mm = 72 / 25.4
+
+// LabelLayouts are the supported sheets: A4 sticker paper of 3 x 8 labels of
+// 70 x 37 mm, and a thermal label printer feeding one 50 x 30 mm label per page
+var LabelLayouts = map[string]LabelLayout{
+	"a4": {
+		PageWidth: 210 * mm, PageHeight: 297 * mm,
+		LabelWidth: 70 * mm, LabelHeight: 37 * mm,
+		MarginLeft: 0, MarginTop: 0.5 * mm,
+		Columns: 3, Rows: 8,
+	},
+	"thermal": {
+		PageWidth: 50 * mm, PageHeight: 30 * mm,
+		LabelWidth: 50 * mm, LabelHeight: 30 * mm,
+		Columns: 1, Rows: 1,
+	},
+}
+
+// RenderLabelsPDF draws the labels onto as many pages of layout as needed,
+// using the PDF base fonts so nothing has to be embedded
+func RenderLabelsPDF(labels []Label, layout LabelLayout) ([]byte, error) {
+	perPage := layout.Columns * layout.Rows
+	var pages []string
+	for start := 0; start < len(labels); start += perPage {
+		end := start + perPage
+		if end > len(labels) {
+			end = len(labels)
+		}
+		var content strings.Builder
+		for i, label := range labels[start:end] {
+			x := layout.MarginLeft + float64(i%layout.Columns)*layout.LabelWidth
+			top := layout.PageHeight - layout.MarginTop - float64(i/layout.Columns)*layout.LabelHeight
+			if err := drawLabel(&content, label, x, top, layout.LabelWidth, layout.LabelHeight); err != nil {
+				return nil, err
+			}
+		}
+		pages = append(pages, content.String())
+	}
+	return writePDF(pages, layout.PageWidth, layout.PageHeight)
+}
+
+// drawLabel writes the drawing operators for one label whose top-left
+// corner is at x, top
+func drawLabel(content *strings.Builder, label Label, x, top, width, height float64) error {
+	modules, err := EncodeBarcode(label.Barcode)
+	if err != nil {
+		return err
+	}
+
+	const padding = 6.0
+	nameSize, priceSize, digitSize := 8.0, 11.0, 7.0
+	inner := width - 2*padding
+
+	// Name, cut to what fits at roughly half an em per character
+	name := label.Name
+	if maxChars := int(inner / (nameSize * 0.5)); len([]rune(name)) > maxChars {
+		name = string([]rune(name)[:maxChars-1]) + "."
+	}
+	nameY := top - padding - nameSize
+	priceY := nameY - priceSize - 2
+	writeText(content, "F1", nameSize, x+padding, nameY, name)
+	writeText(content, "F2", priceSize, x+padding, priceY, "Rp "+formatMoney(label.Price))
+
+	// Bars fill the space between the price and the digits, with ten modules
+	// of quiet zone on each side
+	digitY := top - height + padding
+	barBottom := digitY + digitSize + 1
+	barTop := priceY - 4
+	moduleWidth := inner / float64(len(modules)+20)
+	if moduleWidth > 1.5 {
+		moduleWidth = 1.5
+	}
+	barsX := x + (width-moduleWidth*float64(len(modules)))/2
+	for i := 0; i < len(modules); {
+		if modules[i] != '1' {
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(modules) && modules[i+run] == '1' {
+			run++
+		}
+		fmt.Fprintf(content, "%.3f %.3f %.3f %.3f re\n",
+			barsX+float64(i)*moduleWidth, barBottom, float64(run)*moduleWidth, barTop-barBottom)
+		i += run
+	}
+	content.WriteString("f\n")
+
+	// Helvetica digits are 0.556 em wide, which is enough to center them
+	digitsWidth := float64(len(label.Barcode)) * digitSize * 0.556
+	writeText(content, "F1", digitSize, x+(width-digitsWidth)/2, digitY, label.Barcode)
+	return nil
+}
+
+// writeText writes s at x, y in font, as WinAnsi text
+func writeText(content *strings.Builder, font string, size, x, y float64, s string) {
+	var text strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			text.WriteByte('\\')
+			text.WriteRune(r)
+		case r >= 32 && r < 127:
+			text.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&text, "\\%03o", r)
+		default:
+			text.WriteByte('?')
+		}
+	}
+	fmt.Fprintf(content, "BT /%s %.1f Tf %.3f %.3f Td (%s) Tj ET\n", font, size, x, y, text.String())
+}
+
+// writePDF assembles a PDF with one page per content stream
+func writePDF(pages []string, width, height float64) ([]byte, error) {
+	var objects []string
+	// 1: catalog, 2: page tree, 3 and 4: fonts, then a page and its content
+	// stream for each page
+	objects = append(objects, "<< /Type /Catalog /Pages 2 0 R >>")
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	objects = append(objects, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range pages {
+		objects = append(objects, fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			width, height, 6+2*i))
+
+		var stream bytes.Buffer
+		w := zlib.NewWriter(&stream)
+		if _, err := w.Write([]byte(content)); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		objects = append(objects, fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.String()))
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes(), nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -194,9 +195,23 @@ func GenerateReceiptMessage(storeName string, transaction *models.Transaction) s
 
 	for _, item := range transaction.Items {
 		sb.WriteString(fmt.Sprintf("%s\n", item.ProductName))
-		sb.WriteString(fmt.Sprintf("  %d x Rp %s = Rp %s\n",
-			item.Quantity,
-			formatMoney(item.ProductPrice),
+		for _, comp := range item.Components {
+			sb.WriteString(fmt.Sprintf("  - %dx %s\n", comp.Quantity, comp.ProductName))
+		}
+		for _, m := range item.Modifiers {
+			if m.Price > 0 {
+				sb.WriteString(fmt.Sprintf("  + %s (Rp %s)\n", m.Name, formatMoney(m.Price)))
+			} else {
+				sb.WriteString(fmt.Sprintf("  + %s\n", m.Name))
+			}
+		}
+		quantity := item.Quantity.String()
+		if item.UnitName != nil {
+			quantity += " " + *item.UnitName
+		}
+		sb.WriteString(fmt.Sprintf("  %s x Rp %s = Rp %s\n",
+			quantity,
+			formatMoney(item.ProductPrice+item.ModifierAmount),
 			formatMoney(item.Subtotal)))
 	}
 
@@ -206,12 +221,31 @@ func GenerateReceiptMessage(storeName string, transaction *models.Transaction) s
 	if transaction.DiscountAmount > 0 {
 		sb.WriteString(fmt.Sprintf("Diskon: -Rp %s\n", formatMoney(transaction.DiscountAmount)))
 	}
-	if transaction.TaxAmount > 0 {
+	if len(transaction.Taxes) > 0 {
+		for _, t := range transaction.Taxes {
+			if t.IsInclusive {
+				sb.WriteString(fmt.Sprintf("Termasuk %s %s%%: Rp %s\n", t.Name, formatRate(t.Rate), formatMoney(t.Amount)))
+			} else {
+				sb.WriteString(fmt.Sprintf("%s %s%%: Rp %s\n", t.Name, formatRate(t.Rate), formatMoney(t.Amount)))
+			}
+		}
+	} else if transaction.TaxAmount > 0 {
 		sb.WriteString(fmt.Sprintf("Pajak: Rp %s\n", formatMoney(transaction.TaxAmount)))
 	}
+	if transaction.RoundingAdjustment < 0 {
+		sb.WriteString(fmt.Sprintf("Pembulatan: -Rp %s\n", formatMoney(-transaction.RoundingAdjustment)))
+	} else if transaction.RoundingAdjustment > 0 {
+		sb.WriteString(fmt.Sprintf("Pembulatan: Rp %s\n", formatMoney(transaction.RoundingAdjustment)))
+	}
 
 	sb.WriteString(fmt.Sprintf("*TOTAL: Rp %s*\n", formatMoney(transaction.Total)))
-	sb.WriteString(fmt.Sprintf("Bayar (%s): Rp %s\n", transaction.PaymentType, formatMoney(transaction.PaymentAmount)))
+	if len(transaction.Payments) > 1 {
+		for _, p := range transaction.Payments {
+			sb.WriteString(fmt.Sprintf("Bayar (%s): Rp %s\n", p.PaymentType, formatMoney(p.Amount)))
+		}
+	} else {
+		sb.WriteString(fmt.Sprintf("Bayar (%s): Rp %s\n", transaction.PaymentType, formatMoney(transaction.PaymentAmount)))
+	}
 	sb.WriteString(fmt.Sprintf("Kembali: Rp %s\n", formatMoney(transaction.ChangeAmount)))
 	sb.WriteString("━━━━━━━━━━━━━━━━━━━\n")
 	sb.WriteString("Terima kasih! 🙏\n")
@@ -222,8 +256,8 @@ func GenerateReceiptMessage(storeName string, transaction *models.Transaction) s
 // GenerateLowStockAlert generates a low stock alert message
 func GenerateLowStockAlert(storeName string, products []struct {
 	Name     string
-	Stock    int
-	MinStock int
+	Stock    models.Quantity
+	MinStock models.Quantity
 	Unit     string
 }) string {
 	var sb strings.Builder
@@ -234,7 +268,7 @@ func GenerateLowStockAlert(storeName string, products []struct {
 	sb.WriteString("Produk berikut stoknya hampir habis:\n\n")
 
 	for _, p := range products {
-		sb.WriteString(fmt.Sprintf("• %s: %d %s (min: %d)\n", p.Name, p.Stock, p.Unit, p.MinStock))
+		sb.WriteString(fmt.Sprintf("• %s: %s %s (min: %s)\n", p.Name, p.Stock, p.Unit, p.MinStock))
 	}
 
 	sb.WriteString("\nSegera lakukan restock! 📦")
@@ -242,10 +276,45 @@ func GenerateLowStockAlert(storeName string, products []struct {
 	return sb.String()
 }
 
+// GenerateExpiryAlert generates an alert message for stock batches that
+// are about to expire or already have
+func GenerateExpiryAlert(storeName string, batches []models.StockBatch) string {
+	var sb strings.Builder
+
+	sb.WriteString("⚠️ PERINGATAN KEDALUWARSA\n")
+	sb.WriteString("━━━━━━━━━━━━━━━━━━━━━━━━\n")
+	sb.WriteString(fmt.Sprintf("🏪 %s\n\n", storeName))
+	sb.WriteString("Produk berikut akan atau sudah kedaluwarsa:\n\n")
+
+	for _, b := range batches {
+		name := b.ProductName
+		if b.BatchNumber != nil {
+			name += " (batch " + *b.BatchNumber + ")"
+		}
+		when := fmt.Sprintf("%d hari lagi", *b.DaysLeft)
+		switch {
+		case *b.DaysLeft < 0:
+			when = "sudah kedaluwarsa"
+		case *b.DaysLeft == 0:
+			when = "hari ini"
+		}
+		sb.WriteString(fmt.Sprintf("• %s: %s %s, exp %s (%s)\n", name, b.Quantity, b.Unit, *b.ExpiryDate, when))
+	}
+
+	sb.WriteString("\nSegera beri diskon atau tarik dari rak! 🏷️")
+
+	return sb.String()
+}
+
+// formatRate formats a percentage without trailing zeros, e.g. 11 or 2.5
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64)
+}
+
 // formatMoney formats number to Indonesian money format
-func formatMoney(amount float64) string {
+func formatMoney(amount models.Money) string {
 	// Simple formatting for now to avoid invalid format string error
 	// In Go, there's no built-in %,.0f for thousands separators.
 	// We'll use a simple approach or a dedicated library if needed.
-	return fmt.Sprintf("%.0f", amount)
+	return fmt.Sprintf("%.0f", amount.Float64())
 }